@@ -27,11 +27,17 @@ func main() {
 	ensureAlfredEnv()
 	wf := aw.New()
 
+	args := strings.Fields(strings.Join(os.Args, " "))
+
 	// 初始化配置
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "加载配置失败: %v\n", err)
-		os.Exit(1)
+		// 配置不完整时仍允许通过 config/profile 子命令补全配置，或通过 doctor 诊断
+		if len(args) < 2 || (args[1] != "config" && args[1] != "profile" && args[1] != "doctor") {
+			os.Exit(1)
+		}
+		cfg = &config.Config{LogPath: os.DevNull}
 	}
 
 	// 初始化日志
@@ -41,30 +47,45 @@ func main() {
 	log.Info("Alfred Workflow FRP 安全组助手启动")
 
 	log.Info("os.Args: %#v, wf.Args(): %#v", os.Args, wf.Args())
-	args := strings.Fields(strings.Join(os.Args, " "))
 	wf.Run(func() {
 		if len(args) > 1 && args[1] == "list" {
 			workflow.List(wf)
 		} else if len(args) > 1 && args[1] == "config" {
 			workflow.ConfigCommand(wf, args[1:])
 		} else if len(args) > 1 && args[1] == "open" {
-			// 检查是否有格式为 open:服务名|协议|远程端口|本地端口 的参数
-			if len(args) > 2 {
+			// 检查是否有格式为 open:服务名|协议|远程端口|本地端口 的参数，--all 表示接管没有属主的旧规则
+			if target, _ := workflow.SplitAllFlag(args[2:]); len(target) > 0 {
 				workflow.OpenPort(wf, args[2:])
 			} else {
 				// 显示可以开放的服务列表
 				workflow.OpenCommand(wf)
 			}
 		} else if len(args) > 1 && args[1] == "close" {
-			// 处理close子命令
-			if len(args) > 2 {
+			// 处理close子命令，--all 表示包括其他人的规则
+			if target, _ := workflow.SplitAllFlag(args[2:]); len(target) > 0 {
 				workflow.ClosePort(wf, args[2:])
 			} else {
 				// 显示可以关闭的服务列表
-				workflow.CloseCommand(wf)
+				workflow.CloseCommand(wf, args[2:])
 			}
+		} else if len(args) > 1 && args[1] == "profile" {
+			// 列出或切换配置文件中的 profile
+			workflow.ProfileCommand(wf, args[1:])
+		} else if len(args) > 1 && args[1] == "watch" {
+			// 后台监测公网 IP 变化，不输出 Alfred 结果
+			workflow.Watch(wf, args[2:])
+		} else if len(args) > 1 && args[1] == "doctor" {
+			// 诊断配置、密钥、云 API 权限等，在终端中运行时输出文本清单
+			workflow.DoctorCommand(wf, args[2:])
+		} else if len(args) > 1 && args[1] == "permissions" {
+			// 探测 CAM 权限并生成最小权限策略
+			workflow.PermissionsCommand(wf, args[2:])
+		} else if len(args) > 1 && args[1] == "install-agent" {
+			workflow.InstallAgent(wf, args[2:])
+		} else if len(args) > 1 && args[1] == "uninstall-agent" {
+			workflow.UninstallAgent(wf)
 		} else {
-			wf.NewItem("用法: list | open | close").Valid(false)
+			wf.NewItem("用法: list | open | close | profile | watch | doctor | permissions | install-agent | uninstall-agent").Valid(false)
 			wf.SendFeedback()
 		}
 	})
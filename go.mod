@@ -1,6 +1,6 @@
 module github.com/kevin1sMe/alfred-workflow-sg-manager
 
-go 1.21
+go 1.24
 
 require (
 	github.com/BurntSushi/toml v1.3.2
@@ -2,10 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
-
-	"github.com/keybase/go-keychain"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -16,82 +17,204 @@ const (
 	secretKeyLabel = "TENCENTCLOUD_FRP_SECRET_KEY"
 )
 
+// 支持的 PROVIDER 取值
+const (
+	ProviderTencent    = "tencent"    // 腾讯云 VPC 安全组（默认）
+	ProviderLighthouse = "lighthouse" // 腾讯云轻量应用服务器防火墙
+	ProviderAWS        = "aws"        // AWS EC2 安全组
+	ProviderAliyun     = "aliyun"     // 阿里云 ECS 安全组
+	ProviderNftables   = "nftables"   // frps 主机本机 nftables（通过 SSH 管理）
+	ProviderIptables   = "iptables"   // frps 主机本机 iptables（通过 SSH 管理）
+)
+
 type Config struct {
 	FrpcTomlPath    string `json:"frpc_toml_path"`
 	SecurityGroupId string `json:"security_group_id"`
+	InstanceId      string `json:"instance_id,omitempty"`
 	Region          string `json:"region"`
 	LogPath         string `json:"log_path"`
+	Provider        string `json:"provider,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	AddressTemplate string `json:"address_template,omitempty"`
+	ServiceTemplate string `json:"service_template,omitempty"`
+	IPResolvers     string `json:"ip_resolvers,omitempty"`
+	IPTimeout       string `json:"ip_timeout,omitempty"`
+	IPQuorum        string `json:"ip_quorum,omitempty"`
+	MinCIDRPrefix   string `json:"min_cidr_prefix,omitempty"`
+	Owner           string `json:"owner,omitempty"`
+	SSHHost         string `json:"ssh_host,omitempty"`
+	SSHPort         string `json:"ssh_port,omitempty"`
+	NftInputChain   string `json:"nft_input_chain,omitempty"`
 	SecretId        string `json:"secret_id,omitempty"`
 	SecretKey       string `json:"secret_key,omitempty"`
+	// SecretStore 密钥存储方式，见 SecretStore* 常量，未设置时使用当前系统的默认存储
+	SecretStore string `json:"secret_store,omitempty"`
+	// RoleArn 非空时只用基础密钥调用 STS AssumeRole，以扮演该角色获得的临时密钥调用云 API
+	RoleArn string `json:"role_arn,omitempty"`
+	// RoleDuration 临时密钥的有效期，如 1h
+	RoleDuration string `json:"role_duration,omitempty"`
+	// Credentials 密钥存储中 SecretId/SecretKey 条目的名称后缀，不同 profile 可使用不同的密钥
+	Credentials string `json:"credentials,omitempty"`
+	// Profile 当前生效的 profile 名称，不写入配置文件
+	Profile string `json:"-"`
+}
+
+// envBindings 返回环境变量与配置项的对应关系，环境变量非空时覆盖配置文件中的值
+func (c *Config) envBindings() []struct {
+	key   string
+	value *string
+} {
+	return []struct {
+		key   string
+		value *string
+	}{
+		{"FRPC_TOML_PATH", &c.FrpcTomlPath},
+		{"SECURITY_GROUP_ID", &c.SecurityGroupId},
+		{"INSTANCE_ID", &c.InstanceId},
+		{"REGION", &c.Region},
+		{"LOG_PATH", &c.LogPath},
+		{"PROVIDER", &c.Provider},
+		{"API_ENDPOINT", &c.Endpoint},
+		{"ADDRESS_TEMPLATE", &c.AddressTemplate},
+		{"SERVICE_TEMPLATE", &c.ServiceTemplate},
+		{"IP_RESOLVERS", &c.IPResolvers},
+		{"IP_TIMEOUT", &c.IPTimeout},
+		{"IP_QUORUM", &c.IPQuorum},
+		{"MIN_CIDR_PREFIX", &c.MinCIDRPrefix},
+		{"OWNER", &c.Owner},
+		{"SSH_HOST", &c.SSHHost},
+		{"SSH_PORT", &c.SSHPort},
+		{"NFT_INPUT_CHAIN", &c.NftInputChain},
+		{"SECRET_ID", &c.SecretId},
+		{"SECRET_KEY", &c.SecretKey},
+		{"SECRET_STORE", &c.SecretStore},
+		{"CREDENTIALS", &c.Credentials},
+		{"ROLE_ARN", &c.RoleArn},
+		{"ROLE_DURATION", &c.RoleDuration},
+	}
 }
 
+// Load 读取配置：先取配置文件中当前 profile（PROFILE 环境变量可临时指定）的配置，再用非空的环境变量覆盖
 func Load() (*Config, error) {
-	cfg := Config{
-		FrpcTomlPath:    os.Getenv("FRPC_TOML_PATH"),
-		SecurityGroupId: os.Getenv("SECURITY_GROUP_ID"),
-		Region:          os.Getenv("REGION"),
-		LogPath:         os.Getenv("LOG_PATH"),
-		SecretId:        os.Getenv("SECRET_ID"),
-		SecretKey:       os.Getenv("SECRET_KEY"),
+	cfg, err := LoadPartial()
+	if err != nil {
+		return nil, err
 	}
-	log.Println("load config:", cfg)
-	if cfg.FrpcTomlPath == "" || cfg.SecurityGroupId == "" || cfg.Region == "" || cfg.LogPath == "" {
-		return nil, errors.New("FRPC_TOML_PATH, SECURITY_GROUP_ID, REGION, LOG_PATH 这些环境变量必须全部设置")
+	log.Println("load config:", cfg.redacted())
+	// SECURITY_GROUP_ID 可留空，此时根据 INSTANCE_ID 或 frpc.toml 中的 serverAddr 反查实例绑定的安全组
+	if cfg.FrpcTomlPath == "" || cfg.Region == "" || cfg.LogPath == "" {
+		return nil, errors.New("FRPC_TOML_PATH, REGION, LOG_PATH 这些配置必须全部设置（环境变量或配置文件中的 profile）")
+	}
+	return cfg, nil
+}
+
+// LoadPartial 合并配置文件与环境变量，不检查必填项，供 config 等补全配置的子命令使用
+func LoadPartial() (*Config, error) {
+	file, err := LoadFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{}
+	if name := file.activeProfileName(); name != "" {
+		profile, ok := file.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("配置文件中没有 profile: %s", name)
+		}
+		cfg = *profile
+		cfg.Profile = name
+	}
+	for _, binding := range cfg.envBindings() {
+		if value := os.Getenv(binding.key); value != "" {
+			*binding.value = value
+		}
 	}
 	return &cfg, nil
 }
 
-func SaveSecretId(secretId string) error {
-	return saveKeychain(secretIdLabel, secretId)
+// redacted 返回隐藏了 SecretId/SecretKey 的配置副本，用于写入日志
+func (c *Config) redacted() Config {
+	redacted := *c
+	for _, secret := range []*string{&redacted.SecretId, &redacted.SecretKey} {
+		if *secret != "" {
+			*secret = "******"
+		}
+	}
+	return redacted
 }
 
-func SaveSecretKey(secretKey string) error {
-	return saveKeychain(secretKeyLabel, secretKey)
+// UsesCloudAPI 返回当前后端是否需要调用云 API（即是否需要 SecretId/SecretKey）
+func (c *Config) UsesCloudAPI() bool {
+	return c.Provider != ProviderNftables && c.Provider != ProviderIptables
 }
 
-func GetSecretId() (string, error) {
-	cfg, err := Load()
-	if err != nil {
-		return "", err
-	}
-	if cfg.SecretId != "" {
-		return cfg.SecretId, nil
-	}
-	return getKeychain(secretIdLabel)
+// UsesTencentCloud 返回当前后端是否为腾讯云（VPC 安全组或轻量应用服务器防火墙）
+func (c *Config) UsesTencentCloud() bool {
+	return c.Provider == "" || c.Provider == ProviderTencent || c.Provider == ProviderLighthouse
 }
 
-func GetSecretKey() (string, error) {
-	cfg, err := Load()
-	if err != nil {
-		return "", err
+// UseAddressTemplate 返回是否启用 IP 地址模板模式（ADDRESS_TEMPLATE=1/true）
+func (c *Config) UseAddressTemplate() bool {
+	enabled, _ := strconv.ParseBool(c.AddressTemplate)
+	return enabled
+}
+
+// UseServiceTemplate 返回是否启用协议端口模板模式（SERVICE_TEMPLATE=1/true）
+func (c *Config) UseServiceTemplate() bool {
+	enabled, _ := strconv.ParseBool(c.ServiceTemplate)
+	return enabled
+}
+
+// SecurityGroups 返回配置的全部安全组 ID，SECURITY_GROUP_ID 中的多个 ID 以逗号分隔
+func (c *Config) SecurityGroups() []string {
+	return splitList(c.SecurityGroupId)
+}
+
+// IPResolverList 返回 IP_RESOLVERS 中按优先级排列的公网 IP 来源
+func (c *Config) IPResolverList() []string {
+	return splitList(c.IPResolvers)
+}
+
+// InstanceIds 返回配置的全部实例 ID，INSTANCE_ID 中的多个 ID 以逗号分隔
+func (c *Config) InstanceIds() []string {
+	return splitList(c.InstanceId)
+}
+
+// splitList 拆分以逗号分隔的列表，忽略空白项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	if cfg.SecretKey != "" {
-		return cfg.SecretKey, nil
+	return items
+}
+
+// credentialLabel 返回密钥存储中的条目名称，配置了 credentials 时追加后缀
+func (c *Config) credentialLabel(label string) string {
+	if c.Credentials == "" {
+		return label
 	}
-	return getKeychain(secretKeyLabel)
+	return label + "_" + c.Credentials
 }
 
-func saveKeychain(label, value string) error {
-	item := keychain.NewGenericPassword(secretService, label, label, []byte(value), "")
-	item.SetSynchronizable(keychain.SynchronizableNo)
-	item.SetAccessible(keychain.AccessibleWhenUnlocked)
-	_ = keychain.DeleteGenericPasswordItem(secretService, label)
-	return keychain.AddItem(item)
+func SaveSecretId(secretId string) error {
+	return saveSecret(secretIdLabel, secretId)
 }
 
-func getKeychain(label string) (string, error) {
-	q := keychain.NewItem()
-	q.SetSecClass(keychain.SecClassGenericPassword)
-	q.SetService(secretService)
-	q.SetAccount(label)
-	q.SetMatchLimit(keychain.MatchLimitOne)
-	q.SetReturnData(true)
-	results, err := keychain.QueryItem(q)
+func SaveSecretKey(secretKey string) error {
+	return saveSecret(secretKeyLabel, secretKey)
+}
+
+// saveSecret 将密钥写入当前配置的密钥存储
+func saveSecret(label, value string) error {
+	cfg, err := LoadPartial()
 	if err != nil {
-		return "", err
+		return err
 	}
-	if len(results) == 0 {
-		return "", errors.New("not found")
+	store, err := NewSecretStore(cfg)
+	if err != nil {
+		return err
 	}
-	return string(results[0].Data), nil
+	return store.Set(cfg.credentialLabel(label), value)
 }
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package config
+
+import (
+	"testing"
+)
+
+func TestKeychainRW(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SECRET_STORE", SecretStoreKeychain)
+	t.Setenv("CREDENTIALS", "")
+	testSecretRW(t)
+
+	// 清理
+	store, _ := newKeychainStore()
+	_ = store.Delete(secretIdLabel)
+	_ = store.Delete(secretKeyLabel)
+}
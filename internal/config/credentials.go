@@ -0,0 +1,222 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrCredentialNotFound 密钥链中所有来源都没有找到密钥
+var ErrCredentialNotFound = errors.New("未找到 SecretId/SecretKey")
+
+// Credential 云 API 密钥，Source 说明密钥来自哪个来源；Token 仅 STS 临时密钥使用
+type Credential struct {
+	SecretId  string
+	SecretKey string
+	Token     string
+	Source    string
+}
+
+// CredentialLookup 密钥链中单个来源的查找结果，Credential 为 nil 表示该来源没有密钥
+type CredentialLookup struct {
+	Source     string
+	Credential *Credential
+	Err        error
+}
+
+// 来自环境变量的密钥来源名称，与 Credential.Source 比较
+const (
+	CredentialSourceVariables  = "SECRET_ID/SECRET_KEY"
+	CredentialSourceTencentEnv = "TENCENTCLOUD_SECRET_ID/TENCENTCLOUD_SECRET_KEY"
+)
+
+// credentialProvider 密钥链中的一个来源，没有密钥时返回 nil, nil
+type credentialProvider struct {
+	source string
+	load   func() (*Credential, error)
+}
+
+// credentialProviders 按优先级返回密钥来源：
+// Workflow 变量或配置文件中的 SECRET_ID/SECRET_KEY、TENCENTCLOUD_* 环境变量、
+// ~/.tencentcloud/credentials 中的 profile、密钥存储。TENCENTCLOUD_* 环境变量与凭证文件仅适用于腾讯云后端
+func (c *Config) credentialProviders() []credentialProvider {
+	providers := []credentialProvider{
+		{CredentialSourceVariables, func() (*Credential, error) {
+			return envPairCredential(CredentialSourceVariables, c.SecretId, c.SecretKey)
+		}},
+	}
+	if c.UsesTencentCloud() {
+		providers = append(providers,
+			credentialProvider{CredentialSourceTencentEnv, func() (*Credential, error) {
+				return envPairCredential(CredentialSourceTencentEnv,
+					os.Getenv("TENCENTCLOUD_SECRET_ID"), os.Getenv("TENCENTCLOUD_SECRET_KEY"))
+			}},
+			credentialProvider{"~/.tencentcloud/credentials", c.credentialsFileCredential},
+		)
+	}
+	providers = append(providers, credentialProvider{c.SecretStoreSource(), c.storedCredential})
+	return providers
+}
+
+// SecretStoreName 返回生效的 SECRET_STORE，未配置时为当前系统的默认存储
+func (c *Config) SecretStoreName() string {
+	if c.SecretStore == "" {
+		return defaultSecretStore
+	}
+	return c.SecretStore
+}
+
+// SecretStoreSource 返回从密钥存储读取的密钥的来源名称
+func (c *Config) SecretStoreSource() string {
+	return "密钥存储(" + c.SecretStoreName() + ")"
+}
+
+// envPairCredential 成对读取的密钥只设置了一半时视为配置错误
+func envPairCredential(source, secretId, secretKey string) (*Credential, error) {
+	if secretId == "" && secretKey == "" {
+		return nil, nil
+	}
+	if secretId == "" || secretKey == "" {
+		return nil, errors.New("SecretId 与 SecretKey 需同时设置")
+	}
+	return &Credential{SecretId: secretId, SecretKey: secretKey, Source: source}, nil
+}
+
+// credentialsFilePath 返回腾讯云 SDK 与 tccli 共用的凭证文件路径，可通过 TENCENTCLOUD_CREDENTIALS_FILE 指定
+func credentialsFilePath() (string, error) {
+	if path := os.Getenv("TENCENTCLOUD_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".tencentcloud", "credentials"), nil
+}
+
+// credentialsFileCredential 从凭证文件读取密钥，profile 为 CREDENTIALS，未设置时为 default
+func (c *Config) credentialsFileCredential() (*Credential, error) {
+	path, err := credentialsFilePath()
+	if err != nil {
+		return nil, err
+	}
+	section := c.Credentials
+	if section == "" {
+		section = "default"
+	}
+	values, err := readINISection(path, section)
+	if err != nil || values == nil {
+		return nil, err
+	}
+	secretId, secretKey := values["secret_id"], values["secret_key"]
+	if secretId == "" || secretKey == "" {
+		return nil, fmt.Errorf("%s 的 [%s] 中缺少 secret_id 或 secret_key", path, section)
+	}
+	return &Credential{
+		SecretId:  secretId,
+		SecretKey: secretKey,
+		Source:    fmt.Sprintf("%s [%s]", path, section),
+	}, nil
+}
+
+// readINISection 读取 INI 文件中指定段落的键值，文件或段落不存在时返回 nil
+func readINISection(path, section string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var values map[string]string
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if current == section && values == nil {
+				values = make(map[string]string)
+			}
+		case current == section:
+			if key, value, ok := strings.Cut(line, "="); ok {
+				values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return values, scanner.Err()
+}
+
+// storedCredential 从密钥存储读取 SecretId 和 SecretKey
+func (c *Config) storedCredential() (*Credential, error) {
+	store, err := NewSecretStore(c)
+	if err != nil {
+		return nil, err
+	}
+	secretId, err := store.Get(c.credentialLabel(secretIdLabel))
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretKey, err := store.Get(c.credentialLabel(secretKeyLabel))
+	if errors.Is(err, ErrSecretNotFound) {
+		return nil, errors.New("只保存了 SecretId，缺少 SecretKey")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{SecretId: secretId, SecretKey: secretKey}, nil
+}
+
+// LoadCredential 按密钥链的优先级查找密钥，返回第一个同时提供 SecretId 和 SecretKey 的来源
+func LoadCredential() (*Credential, error) {
+	cfg, err := LoadPartial()
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, provider := range cfg.credentialProviders() {
+		cred, err := provider.load()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.source, err))
+			continue
+		}
+		if cred != nil {
+			if cred.Source == "" {
+				cred.Source = provider.source
+			}
+			return cred, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(append([]error{ErrCredentialNotFound}, errs...)...)
+	}
+	return nil, ErrCredentialNotFound
+}
+
+// InspectCredentials 依次查询密钥链中的所有来源，用于在配置列表中展示各来源的状态
+func InspectCredentials() ([]CredentialLookup, error) {
+	cfg, err := LoadPartial()
+	if err != nil {
+		return nil, err
+	}
+	var lookups []CredentialLookup
+	for _, provider := range cfg.credentialProviders() {
+		cred, err := provider.load()
+		if cred != nil && cred.Source == "" {
+			cred.Source = provider.source
+		}
+		lookups = append(lookups, CredentialLookup{Source: provider.source, Credential: cred, Err: err})
+	}
+	return lookups, nil
+}
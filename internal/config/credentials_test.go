@@ -0,0 +1,92 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCredentialChain(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("PROFILE", "")
+	t.Setenv("PROVIDER", "")
+	t.Setenv("CREDENTIALS", "")
+	t.Setenv("SECRET_STORE", SecretStoreMemory)
+	t.Setenv("TENCENTCLOUD_CREDENTIALS_FILE", "")
+	for _, key := range []string{"SECRET_ID", "SECRET_KEY", "TENCENTCLOUD_SECRET_ID", "TENCENTCLOUD_SECRET_KEY"} {
+		t.Setenv(key, "")
+	}
+	sharedMemoryStore.Delete(secretIdLabel)
+	sharedMemoryStore.Delete(secretKeyLabel)
+
+	if _, err := LoadCredential(); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("empty chain = %v, want ErrCredentialNotFound", err)
+	}
+
+	expect := func(id, source string) {
+		t.Helper()
+		cred, err := LoadCredential()
+		if err != nil {
+			t.Fatalf("LoadCredential failed: %v", err)
+		}
+		if cred.SecretId != id || !strings.Contains(cred.Source, source) {
+			t.Errorf("LoadCredential = %s from %s, want %s from %s", cred.SecretId, cred.Source, id, source)
+		}
+	}
+
+	// 优先级从低到高依次加入各来源
+	SaveSecretId("store-id")
+	SaveSecretKey("store-key")
+	expect("store-id", "密钥存储(memory)")
+
+	dir := filepath.Join(home, ".tencentcloud")
+	os.MkdirAll(dir, 0o700)
+	os.WriteFile(filepath.Join(dir, "credentials"), []byte(`# tccli
+[default]
+secret_id = file-id
+secret_key = file-key
+
+[work]
+secret_id = work-id
+secret_key = work-key
+`), 0o600)
+	expect("file-id", "[default]")
+	t.Setenv("CREDENTIALS", "work")
+	expect("work-id", "[work]")
+	t.Setenv("CREDENTIALS", "")
+
+	t.Setenv("TENCENTCLOUD_SECRET_ID", "env-id")
+	t.Setenv("TENCENTCLOUD_SECRET_KEY", "env-key")
+	expect("env-id", "TENCENTCLOUD_SECRET_ID")
+
+	t.Setenv("SECRET_ID", "wf-id")
+	t.Setenv("SECRET_KEY", "wf-key")
+	expect("wf-id", "SECRET_ID/SECRET_KEY")
+
+	// 只设置一半的来源报错并跳过
+	t.Setenv("SECRET_KEY", "")
+	expect("env-id", "TENCENTCLOUD_SECRET_ID")
+	lookups, err := InspectCredentials()
+	if err != nil || len(lookups) != 4 || lookups[0].Err == nil || lookups[3].Credential == nil {
+		t.Errorf("InspectCredentials = %+v, %v", lookups, err)
+	}
+
+	// 非腾讯云后端不读取 TENCENTCLOUD_* 环境变量和凭证文件
+	t.Setenv("PROVIDER", ProviderAWS)
+	expect("store-id", "密钥存储")
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := &Config{Region: "ap-guangzhou", SecretId: "AKIDabcdefgh", SecretKey: "secret-key"}
+	logged := fmt.Sprint(cfg.redacted())
+	if strings.Contains(logged, "AKIDabcdefgh") || strings.Contains(logged, "secret-key") || !strings.Contains(logged, "ap-guangzhou") {
+		t.Errorf("redacted config = %s", logged)
+	}
+	if cfg.SecretId != "AKIDabcdefgh" {
+		t.Error("redacted should not modify the original config")
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// File 配置文件 ~/.alfred-frp-sg/config.json 的内容
+type File struct {
+	// CurrentProfile 当前使用的 profile，可通过 profile use 切换
+	CurrentProfile string             `json:"current_profile,omitempty"`
+	Profiles       map[string]*Config `json:"profiles,omitempty"`
+}
+
+// FilePath 返回配置文件路径
+func FilePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户目录失败: %w", err)
+	}
+	return filepath.Join(home, configDirName, configFileName), nil
+}
+
+// LoadFile 读取配置文件，文件不存在时返回空配置
+func LoadFile() (*File, error) {
+	file := &File{Profiles: map[string]*Config{}}
+	path, err := FilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 失败: %w", path, err)
+	}
+	if file.Profiles == nil {
+		file.Profiles = map[string]*Config{}
+	}
+	return file, nil
+}
+
+// Save 写入配置文件，配置中可能含有密钥，仅当前用户可读写
+func (f *File) Save() error {
+	path, err := FilePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return nil
+}
+
+// ProfileNames 返回按名称排序的 profile 列表
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseProfile 将 name 设为当前 profile 并保存
+func UseProfile(name string) error {
+	file, err := LoadFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := file.Profiles[name]; !ok {
+		return fmt.Errorf("配置文件中没有 profile: %s", name)
+	}
+	file.CurrentProfile = name
+	return file.Save()
+}
+
+// defaultProfileName 尚无配置文件时，设置项写入的 profile
+const defaultProfileName = "default"
+
+// IsDefaultProfile 判断是否为未使用配置文件或默认的 profile，按 profile 区分的缓存、后台任务沿用原有名称
+func IsDefaultProfile(name string) bool {
+	return name == "" || name == defaultProfileName
+}
+
+// activeProfileName 返回当前生效的 profile：PROFILE 环境变量优先，其次为配置文件中的 current_profile
+func (f *File) activeProfileName() string {
+	if name := os.Getenv("PROFILE"); name != "" {
+		return name
+	}
+	return f.CurrentProfile
+}
+
+// Value 返回环境变量名 key 对应的配置值
+func (c *Config) Value(key string) (string, bool) {
+	for _, binding := range c.envBindings() {
+		if binding.key == key {
+			return *binding.value, true
+		}
+	}
+	return "", false
+}
+
+// SaveSetting 将环境变量名 key 对应的配置写入当前 profile，返回写入的 profile 名称。
+// 尚未使用配置文件时创建 default profile 并设为当前 profile；value 为空表示清除该项
+func SaveSetting(key, value string) (string, error) {
+	file, err := LoadFile()
+	if err != nil {
+		return "", err
+	}
+	name := file.activeProfileName()
+	if name == "" {
+		name = defaultProfileName
+		file.CurrentProfile = name
+	}
+	profile, ok := file.Profiles[name]
+	if !ok {
+		profile = &Config{}
+		file.Profiles[name] = profile
+	}
+	found := false
+	for _, binding := range profile.envBindings() {
+		if binding.key == key {
+			*binding.value = value
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("未知的配置项: %s", key)
+	}
+	return name, file.Save()
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, content string) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, configDirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, configFileName), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadProfiles(t *testing.T) {
+	writeConfigFile(t, `{
+  "current_profile": "home",
+  "profiles": {
+    "home": {"frpc_toml_path": "/home/frpc.toml", "region": "ap-guangzhou", "log_path": "/tmp/home.log", "security_group_id": "sg-home"},
+    "work": {"frpc_toml_path": "/work/frpc.toml", "region": "ap-shanghai", "log_path": "/tmp/work.log", "provider": "aws", "credentials": "work"}
+  }
+}`)
+	for _, key := range []string{"PROFILE", "FRPC_TOML_PATH", "REGION", "LOG_PATH", "SECURITY_GROUP_ID", "PROVIDER", "CREDENTIALS"} {
+		t.Setenv(key, "")
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Profile != "home" || cfg.Region != "ap-guangzhou" || cfg.SecurityGroupId != "sg-home" {
+		t.Errorf("home profile = %+v", cfg)
+	}
+
+	// 环境变量覆盖配置文件中的值
+	t.Setenv("REGION", "ap-beijing")
+	if cfg, _ := Load(); cfg.Region != "ap-beijing" || cfg.FrpcTomlPath != "/home/frpc.toml" {
+		t.Errorf("env override = %+v", cfg)
+	}
+	t.Setenv("REGION", "")
+
+	if err := UseProfile("work"); err != nil {
+		t.Fatalf("UseProfile failed: %v", err)
+	}
+	cfg, _ = Load()
+	if cfg.Profile != "work" || cfg.Provider != "aws" {
+		t.Errorf("work profile = %+v", cfg)
+	}
+	if got := cfg.credentialLabel(secretIdLabel); got != secretIdLabel+"_work" {
+		t.Errorf("credentialLabel = %s", got)
+	}
+
+	if err := UseProfile("missing"); err == nil {
+		t.Error("UseProfile should reject unknown profiles")
+	}
+	t.Setenv("PROFILE", "missing")
+	if _, err := Load(); err == nil {
+		t.Error("Load should fail for an unknown PROFILE")
+	}
+}
+
+func TestLoadWithoutConfigFile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROFILE", "")
+	t.Setenv("FRPC_TOML_PATH", "/tmp/frpc.toml")
+	t.Setenv("REGION", "ap-guangzhou")
+	t.Setenv("LOG_PATH", "/tmp/alfred-frp.log")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Profile != "" || cfg.FrpcTomlPath != "/tmp/frpc.toml" {
+		t.Errorf("env-only config = %+v", cfg)
+	}
+}
+
+func TestSaveSetting(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("PROFILE", "")
+
+	// 没有配置文件时写入 default profile 并设为当前 profile
+	name, err := SaveSetting("REGION", "ap-shanghai")
+	if err != nil || name != defaultProfileName {
+		t.Fatalf("SaveSetting = %s, %v", name, err)
+	}
+	file, err := LoadFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.CurrentProfile != defaultProfileName || file.Profiles[defaultProfileName].Region != "ap-shanghai" {
+		t.Errorf("file = %+v", file)
+	}
+
+	// PROFILE 指定的 profile 不存在时新建
+	t.Setenv("PROFILE", "work")
+	if name, err := SaveSetting("SECURITY_GROUP_ID", "sg-abcd1234"); err != nil || name != "work" {
+		t.Fatalf("SaveSetting = %s, %v", name, err)
+	}
+	cfg, err := LoadPartial()
+	if err != nil || cfg.SecurityGroupId != "sg-abcd1234" || cfg.Region != "" {
+		t.Errorf("work profile = %+v, %v", cfg, err)
+	}
+
+	if _, err := SaveSetting("UNKNOWN", "x"); err == nil {
+		t.Error("SaveSetting should reject unknown keys")
+	}
+}
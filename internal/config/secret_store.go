@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// 支持的 SECRET_STORE 取值
+const (
+	SecretStoreKeychain      = "keychain"       // macOS 钥匙串
+	SecretStoreSecretService = "secret-service" // Linux Secret Service（通过 secret-tool 访问 D-Bus）
+	SecretStoreFile          = "file"           // 以 SECRET_PASSPHRASE 加密的本地文件
+	SecretStoreMemory        = "memory"         // 仅保存在进程内存中，用于测试
+)
+
+// ErrSecretNotFound 密钥存储中没有对应条目
+var ErrSecretNotFound = errors.New("密钥不存在")
+
+// SecretStore 保存 SecretId/SecretKey 等密钥，key 为条目名称
+type SecretStore interface {
+	Get(key string) (string, error)
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// NewSecretStore 返回配置指定的密钥存储，未配置时使用当前系统的默认存储
+func NewSecretStore(cfg *Config) (SecretStore, error) {
+	name := cfg.SecretStoreName()
+	switch name {
+	case SecretStoreKeychain:
+		return newKeychainStore()
+	case SecretStoreSecretService:
+		return newSecretServiceStore()
+	case SecretStoreFile:
+		path, err := secretFilePath()
+		if err != nil {
+			return nil, err
+		}
+		return newFileStore(path, os.Getenv("SECRET_PASSPHRASE"))
+	case SecretStoreMemory:
+		return sharedMemoryStore, nil
+	}
+	return nil, fmt.Errorf("不支持的 SECRET_STORE: %s", name)
+}
+
+// memoryStore 进程内的密钥存储
+type memoryStore struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
+
+// sharedMemoryStore 同一进程内多次 NewSecretStore 共用的内存存储
+var sharedMemoryStore = newMemoryStore()
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{secrets: make(map[string]string)}
+}
+
+func (s *memoryStore) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (s *memoryStore) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[key] = value
+	return nil
+}
+
+func (s *memoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, key)
+	return nil
+}
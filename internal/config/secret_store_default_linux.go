@@ -0,0 +1,6 @@
+//go:build linux
+
+package config
+
+// defaultSecretStore Linux 默认使用 Secret Service（GNOME Keyring、KWallet 等）
+const defaultSecretStore = SecretStoreSecretService
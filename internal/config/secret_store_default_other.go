@@ -0,0 +1,6 @@
+//go:build !darwin && !linux
+
+package config
+
+// defaultSecretStore 其他系统没有统一的密钥服务，默认使用加密文件
+const defaultSecretStore = SecretStoreFile
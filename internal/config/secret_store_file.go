@@ -0,0 +1,202 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// secretFileName 加密密钥文件，与配置文件放在同一目录
+	secretFileName = "secrets.enc"
+	// secretFileIterations PBKDF2 迭代次数，派生的密钥在进程内缓存，每个进程只需派生一次
+	secretFileIterations = 200000
+	// secretFileMaxIterations 读取密钥文件时允许的最大迭代次数，防止被篡改的文件让每次读取都卡住
+	secretFileMaxIterations = 10 * secretFileIterations
+)
+
+// secretFile 加密密钥文件的内容，密文解密后为 JSON 格式的 条目名→密钥
+type secretFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// fileStore 以口令加密的本地文件存储密钥：PBKDF2-SHA256 派生密钥，AES-256-GCM 加密
+type fileStore struct {
+	path       string
+	passphrase string
+	salt       []byte // 最近一次读取的文件所用的盐，写入时沿用以复用已派生的密钥
+}
+
+// derivedKeyCache 缓存最近一次派生的密钥，同一进程内多次读写密钥文件时不必重复二十万次迭代
+var derivedKeyCache struct {
+	sync.Mutex
+	id  [sha256.Size]byte
+	key []byte
+}
+
+// deriveSecretKey 由口令、盐和迭代次数派生 AES-256 密钥，参数相同时返回缓存的结果
+func deriveSecretKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	id := sha256.Sum256(fmt.Appendf(nil, "%d\x00%x\x00%s", iterations, salt, passphrase))
+	derivedKeyCache.Lock()
+	defer derivedKeyCache.Unlock()
+	if derivedKeyCache.key == nil || derivedKeyCache.id != id {
+		key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+		if err != nil {
+			return nil, fmt.Errorf("派生密钥失败: %w", err)
+		}
+		derivedKeyCache.id = id
+		derivedKeyCache.key = key
+	}
+	return derivedKeyCache.key, nil
+}
+
+// secretFilePath 返回加密密钥文件路径
+func secretFilePath() (string, error) {
+	path, err := FilePath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), secretFileName), nil
+}
+
+func newFileStore(path, passphrase string) (SecretStore, error) {
+	if passphrase == "" {
+		return nil, errors.New("SECRET_STORE 为 file 时需设置 SECRET_PASSPHRASE")
+	}
+	return &fileStore{path: path, passphrase: passphrase}, nil
+}
+
+func (s *fileStore) Get(key string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[key]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+func (s *fileStore) Set(key, value string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[key] = value
+	return s.save(secrets)
+}
+
+func (s *fileStore) Delete(key string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[key]; !ok {
+		return nil
+	}
+	delete(secrets, key)
+	return s.save(secrets)
+}
+
+// load 读取并解密密钥文件，文件不存在时返回空集合
+func (s *fileStore) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+	var file secretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析密钥文件失败: %w", err)
+	}
+	if file.KDF != "pbkdf2-sha256" {
+		return nil, fmt.Errorf("不支持的密钥派生算法: %s", file.KDF)
+	}
+	gcm, err := newSecretCipher(s.passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("解密密钥文件失败，SECRET_PASSPHRASE 不正确或文件已损坏")
+	}
+	if file.Iterations == secretFileIterations {
+		s.salt = file.Salt
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("解析密钥文件失败: %w", err)
+	}
+	return secrets, nil
+}
+
+// save 使用新的随机数加密后写入，先写临时文件再改名，避免中断时损坏原文件。
+// 已读取过密钥文件时沿用原来的盐，否则生成新的盐
+func (s *fileStore) save(secrets map[string]string) error {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	file := secretFile{Version: 1, KDF: "pbkdf2-sha256", Iterations: secretFileIterations, Salt: s.salt}
+	if file.Salt == nil {
+		file.Salt = make([]byte, 16)
+		if _, err := rand.Read(file.Salt); err != nil {
+			return err
+		}
+		s.salt = file.Salt
+	}
+	gcm, err := newSecretCipher(s.passphrase, file.Salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("创建密钥目录失败: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("写入密钥文件失败: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// newSecretCipher 由口令派生 AES-256-GCM 密钥
+func newSecretCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if iterations <= 0 || iterations > secretFileMaxIterations {
+		return nil, fmt.Errorf("无效的迭代次数: %d", iterations)
+	}
+	key, err := deriveSecretKey(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,48 @@
+//go:build darwin
+
+package config
+
+import "github.com/keybase/go-keychain"
+
+// defaultSecretStore macOS 默认使用钥匙串
+const defaultSecretStore = SecretStoreKeychain
+
+// keychainStore 基于 macOS 钥匙串的密钥存储
+type keychainStore struct{}
+
+func newKeychainStore() (SecretStore, error) {
+	return keychainStore{}, nil
+}
+
+func (keychainStore) Get(key string) (string, error) {
+	q := keychain.NewItem()
+	q.SetSecClass(keychain.SecClassGenericPassword)
+	q.SetService(secretService)
+	q.SetAccount(key)
+	q.SetMatchLimit(keychain.MatchLimitOne)
+	q.SetReturnData(true)
+	results, err := keychain.QueryItem(q)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "", ErrSecretNotFound
+	}
+	return string(results[0].Data), nil
+}
+
+func (keychainStore) Set(key, value string) error {
+	item := keychain.NewGenericPassword(secretService, key, key, []byte(value), "")
+	item.SetSynchronizable(keychain.SynchronizableNo)
+	item.SetAccessible(keychain.AccessibleWhenUnlocked)
+	_ = keychain.DeleteGenericPasswordItem(secretService, key)
+	return keychain.AddItem(item)
+}
+
+func (keychainStore) Delete(key string) error {
+	err := keychain.DeleteGenericPasswordItem(secretService, key)
+	if err == keychain.ErrorItemNotFound {
+		return nil
+	}
+	return err
+}
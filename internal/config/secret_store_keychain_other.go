@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package config
+
+import "errors"
+
+func newKeychainStore() (SecretStore, error) {
+	return nil, errors.New("钥匙串仅支持 macOS，请将 SECRET_STORE 设为 secret-service 或 file")
+}
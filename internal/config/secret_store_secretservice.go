@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretServiceStore 通过 libsecret 的 secret-tool 命令访问 Secret Service（D-Bus），
+// 条目以 service、account 两个属性定位，与钥匙串中的服务名和条目名一致。
+// 模块未引入 D-Bus 客户端库，直接调用 secret-tool 可以复用 libsecret 的会话加密和解锁提示
+type secretServiceStore struct {
+	tool string
+}
+
+func newSecretServiceStore() (SecretStore, error) {
+	tool, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return nil, errors.New("未找到 secret-tool，请安装 libsecret-tools，或将 SECRET_STORE 设为 file")
+	}
+	return &secretServiceStore{tool: tool}, nil
+}
+
+func (s *secretServiceStore) Get(key string) (string, error) {
+	out, errOut, err := s.run("", "lookup", "service", secretService, "account", key)
+	if err != nil {
+		// 没有匹配条目时 secret-tool lookup 以非零状态退出，且没有任何输出
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && out == "" && errOut == "" {
+			return "", ErrSecretNotFound
+		}
+		return "", err
+	}
+	return out, nil
+}
+
+func (s *secretServiceStore) Set(key, value string) error {
+	_, _, err := s.run(value, "store", "--label="+key, "service", secretService, "account", key)
+	return err
+}
+
+func (s *secretServiceStore) Delete(key string) error {
+	_, _, err := s.run("", "clear", "service", secretService, "account", key)
+	return err
+}
+
+// run 执行 secret-tool，密钥通过标准输入传递，避免出现在进程参数中
+func (s *secretServiceStore) run(stdin string, args ...string) (stdout, stderr string, err error) {
+	cmd := exec.Command(s.tool, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	stdout, stderr = outBuf.String(), strings.TrimSpace(errBuf.String())
+	if err != nil && stderr != "" {
+		err = fmt.Errorf("secret-tool %s 失败: %s: %w", args[0], stderr, err)
+	}
+	return stdout, stderr, err
+}
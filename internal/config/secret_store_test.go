@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testSecretRW 通过 SaveSecretId/LoadCredential 等接口读写当前 SECRET_STORE 中的密钥
+func testSecretRW(t *testing.T) {
+	t.Helper()
+	t.Setenv("SECRET_ID", "")
+	t.Setenv("SECRET_KEY", "")
+	t.Setenv("TENCENTCLOUD_SECRET_ID", "")
+	t.Setenv("TENCENTCLOUD_SECRET_KEY", "")
+	secretId := "test-secret-id-abcdefg"
+	secretKey := "test-secret-key-1234567"
+	if err := SaveSecretId(secretId); err != nil {
+		t.Fatalf("SaveSecretId failed: %v", err)
+	}
+	if err := SaveSecretKey(secretKey); err != nil {
+		t.Fatalf("SaveSecretKey failed: %v", err)
+	}
+	cred, err := LoadCredential()
+	if err != nil {
+		t.Fatalf("LoadCredential failed: %v", err)
+	}
+	if cred.SecretId != secretId {
+		t.Errorf("SecretId not match: got %s, want %s", cred.SecretId, secretId)
+	}
+	if cred.SecretKey != secretKey {
+		t.Errorf("SecretKey not match: got %s, want %s", cred.SecretKey, secretKey)
+	}
+	if !strings.HasPrefix(cred.Source, "密钥存储") {
+		t.Errorf("credential source = %s, want the secret store", cred.Source)
+	}
+}
+
+func TestMemorySecretRW(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("SECRET_STORE", SecretStoreMemory)
+	t.Setenv("CREDENTIALS", "")
+	testSecretRW(t)
+}
+
+func TestFileSecretRW(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SECRET_STORE", SecretStoreFile)
+	t.Setenv("SECRET_PASSPHRASE", "correct horse")
+	t.Setenv("CREDENTIALS", "")
+	testSecretRW(t)
+
+	path := filepath.Join(home, configDirName, secretFileName)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("secret file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	wrong, _ := newFileStore(path, "wrong")
+	if _, err := wrong.Get(secretIdLabel); err == nil {
+		t.Error("Get with a wrong passphrase should fail")
+	}
+	if _, err := newFileStore(path, ""); err == nil {
+		t.Error("file store should require a passphrase")
+	}
+
+	// 写入时沿用原来的盐，进程内缓存的派生密钥仍然有效
+	readSalt := func() []byte {
+		data, _ := os.ReadFile(path)
+		var file secretFile
+		json.Unmarshal(data, &file)
+		return file.Salt
+	}
+	salt := readSalt()
+	store, _ := newFileStore(path, "correct horse")
+	if err := store.Set("extra", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readSalt(); !bytes.Equal(got, salt) {
+		t.Errorf("salt changed on write: %x -> %x", salt, got)
+	}
+	a, _ := deriveSecretKey("correct horse", salt, secretFileIterations)
+	b, _ := deriveSecretKey("correct horse", salt, secretFileIterations)
+	if &a[0] != &b[0] {
+		t.Error("derived key should be cached")
+	}
+	if err := store.Delete(secretIdLabel); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(secretIdLabel); err != ErrSecretNotFound {
+		t.Errorf("Get after Delete = %v, want ErrSecretNotFound", err)
+	}
+}
+
+func TestSecretStoreSelection(t *testing.T) {
+	if _, err := NewSecretStore(&Config{SecretStore: "vault"}); err == nil {
+		t.Error("unknown SECRET_STORE should be rejected")
+	}
+	store, err := NewSecretStore(&Config{SecretStore: SecretStoreMemory})
+	if err != nil || store != sharedMemoryStore {
+		t.Errorf("memory store = %v, %v", store, err)
+	}
+}
+
+func TestSecretFileIterationsBound(t *testing.T) {
+	path := filepath.Join(t.TempDir(), secretFileName)
+	store, _ := newFileStore(path, "correct horse")
+	if err := store.Set(secretIdLabel, "AKID"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 被篡改为超大迭代次数的文件应直接拒绝，而不是卡在密钥派生上
+	data, _ := os.ReadFile(path)
+	var file secretFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	for _, iterations := range []int{0, secretFileMaxIterations + 1, 1 << 40} {
+		file.Iterations = iterations
+		data, _ = json.Marshal(file)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Get(secretIdLabel); err == nil || !strings.Contains(err.Error(), "迭代次数") {
+			t.Errorf("iterations %d: Get = %v, want an iteration error", iterations, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package workflow
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// addressBookName 地址簿保存在 Workflow 数据目录中，不随缓存清理
+const addressBookName = "address_book.json"
+
+// addressBookModifiers 打开服务列表时，依次绑定到地址簿前几个条目的修饰键
+var addressBookModifiers = []struct {
+	key    aw.ModKey
+	symbol string
+}{
+	{aw.ModAlt, "⌥"},
+	{aw.ModCtrl, "⌃"},
+	{aw.ModShift, "⇧"},
+	{aw.ModFn, "fn"},
+}
+
+// addressBook 常用来源的地址簿，名称 -> CIDR（如 alice=198.51.100.7/32）
+type addressBook map[string]string
+
+// loadAddressBook 读取地址簿，不存在时返回空地址簿
+func loadAddressBook(wf *aw.Workflow) (addressBook, error) {
+	book := addressBook{}
+	if !wf.Data.Exists(addressBookName) {
+		return book, nil
+	}
+	if err := wf.Data.LoadJSON(addressBookName, &book); err != nil {
+		return book, fmt.Errorf("读取地址簿失败: %w", err)
+	}
+	return book, nil
+}
+
+// saveAddressBook 保存地址簿
+func saveAddressBook(wf *aw.Workflow, book addressBook) error {
+	return wf.Data.StoreJSON(addressBookName, book)
+}
+
+// names 返回按名称排序的条目名
+func (b addressBook) names() []string {
+	names := make([]string, 0, len(b))
+	for name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// nameOf 返回 CIDR 或 IP 对应的条目名，不在地址簿中时返回空字符串
+func (b addressBook) nameOf(source string) string {
+	if !strings.Contains(source, "/") {
+		source += "/32"
+	}
+	for _, name := range b.names() {
+		if b[name] == source {
+			return name
+		}
+	}
+	return ""
+}
+
+// label 返回规则来源的展示文本，来源在地址簿中时显示条目名
+func (b addressBook) label(rule FetchedRuleInfo) string {
+	if name := b.nameOf(rule.CidrBlock); name != "" {
+		return name
+	}
+	if rule.TemplateAddresses != "" {
+		addresses := strings.Split(rule.TemplateAddresses, ",")
+		for i, address := range addresses {
+			if name := b.nameOf(address); name != "" {
+				addresses[i] = name
+			}
+		}
+		rule.TemplateAddresses = strings.Join(addresses, ",")
+	}
+	return ruleSource(rule)
+}
+
+// parseAddressBookEntry 解析 name=IP 或 name=CIDR，网段宽度受 MIN_CIDR_PREFIX 限制
+func parseAddressBookEntry(entry string, minPrefix int) (name, cidrBlock string, err error) {
+	name, source, found := strings.Cut(entry, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" {
+		return "", "", fmt.Errorf("格式应为 名称=IP 或 名称=网段: %s", entry)
+	}
+	if err := validateAddressBookName(name); err != nil {
+		return "", "", err
+	}
+	cidrBlock, err = normalizeSource(strings.TrimSpace(source), minPrefix)
+	if err != nil {
+		return "", "", err
+	}
+	return name, cidrBlock, nil
+}
+
+// validateAddressBookName 条目名会出现在 open 参数和规则备注中，不能含空白、参数分隔符和备注分隔符，也不能是 IP
+func validateAddressBookName(name string) error {
+	if strings.ContainsAny(name, "|=,/@#") || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("名称不能包含空白或 | = , / @ # 字符: %s", name)
+	}
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("名称不能是 IP 地址: %s", name)
+	}
+	return nil
+}
+
+// resolveSource 将 open 参数中的 ip= 值解析为 CIDR，值为地址簿条目名时使用条目中的地址。
+// grant 是写入规则备注的授权来源：地址簿条目为条目名，条目地址变化后重新开放仍替换同一条规则；直接指定时为 CIDR
+func resolveSource(wf *aw.Workflow, source string, minPrefix int) (cidrBlock, grant string, err error) {
+	if strings.Contains(source, "/") || net.ParseIP(source) != nil {
+		cidrBlock, err = normalizeSource(source, minPrefix)
+		return cidrBlock, cidrBlock, err
+	}
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		return "", "", err
+	}
+	cidrBlock, ok := book[source]
+	if !ok {
+		return "", "", fmt.Errorf("地址簿中没有 %s", source)
+	}
+	log.Info("使用地址簿条目 %s: %s", source, cidrBlock)
+	// 条目保存后 MIN_CIDR_PREFIX 可能被调小，开放前重新校验
+	cidrBlock, err = normalizeSource(cidrBlock, minPrefix)
+	return cidrBlock, source, err
+}
+
+// addAddressBookModifiers 为开放服务的条目绑定修饰键，按住修饰键回车即开放给对应的地址簿条目
+func addAddressBookModifiers(item *aw.Item, arg string, book addressBook) {
+	names := book.names()
+	for i, modifier := range addressBookModifiers {
+		if i >= len(names) {
+			return
+		}
+		item.NewModifier(modifier.key).
+			Subtitle(fmt.Sprintf("%s 开放给 %s (%s)", modifier.symbol, names[i], book[names[i]])).
+			Arg(arg + "|ip=" + names[i]).
+			Valid(true)
+	}
+}
+
+// showAddressBook 在配置列表中展示地址簿及增删入口
+func showAddressBook(wf *aw.Workflow) {
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		wf.NewItem("📒 地址簿读取失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconWarning)
+		return
+	}
+	for i, name := range book.names() {
+		subtitle := book[name]
+		if i < len(addressBookModifiers) {
+			subtitle += fmt.Sprintf(" | open 时按住 %s 回车开放给该来源", addressBookModifiers[i].symbol)
+		}
+		wf.NewItem("📒 " + name).
+			Subtitle(subtitle).
+			Valid(false)
+	}
+	wf.NewItem("📒 添加地址簿条目").
+		Subtitle("输入 名称=IP 或 名称=网段，如 alice=198.51.100.7、office=203.0.113.0/28").
+		Valid(true).
+		Arg("address_add")
+	if len(book) > 0 {
+		wf.NewItem("📒 删除地址簿条目").
+			Subtitle("输入要删除的名称").
+			Valid(true).
+			Arg("address_remove")
+	}
+}
+
+// addAddressBookEntry 新增或覆盖地址簿条目
+func addAddressBookEntry(wf *aw.Workflow, args []string, minPrefix int) {
+	if len(args) < 3 {
+		wf.NewItem("请输入 名称=IP 或 名称=网段 后回车").Valid(false)
+		wf.SendFeedback()
+		return
+	}
+	name, cidrBlock, err := parseAddressBookEntry(strings.Join(args[2:], ""), minPrefix)
+	if err == nil {
+		err = updateAddressBook(wf, func(book addressBook) error {
+			book[name] = cidrBlock
+			return nil
+		})
+	}
+	if err != nil {
+		log.Error("保存地址簿条目失败: %v", err)
+		wf.NewItem("保存地址簿条目失败").Subtitle(err.Error()).Valid(false)
+	} else {
+		log.Info("地址簿条目已保存: %s=%s", name, cidrBlock)
+		wf.NewItem(fmt.Sprintf("地址簿条目已保存: %s=%s", name, cidrBlock)).Valid(false)
+	}
+	wf.SendFeedback()
+}
+
+// removeAddressBookEntry 删除地址簿条目
+func removeAddressBookEntry(wf *aw.Workflow, args []string) {
+	if len(args) < 3 {
+		wf.NewItem("请输入要删除的名称后回车").Valid(false)
+		wf.SendFeedback()
+		return
+	}
+	name := args[2]
+	err := updateAddressBook(wf, func(book addressBook) error {
+		if _, ok := book[name]; !ok {
+			return fmt.Errorf("地址簿中没有 %s", name)
+		}
+		delete(book, name)
+		return nil
+	})
+	if err != nil {
+		wf.NewItem("删除地址簿条目失败").Subtitle(err.Error()).Valid(false)
+	} else {
+		log.Info("地址簿条目已删除: %s", name)
+		wf.NewItem("地址簿条目已删除: " + name).Valid(false)
+	}
+	wf.SendFeedback()
+}
+
+// updateAddressBook 读取地址簿，修改后保存
+func updateAddressBook(wf *aw.Workflow, update func(addressBook) error) error {
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		return err
+	}
+	if err := update(book); err != nil {
+		return err
+	}
+	if err := saveAddressBook(wf, book); err != nil {
+		return fmt.Errorf("保存地址簿失败: %w", err)
+	}
+	return nil
+}
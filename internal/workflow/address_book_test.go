@@ -0,0 +1,68 @@
+package workflow
+
+import "testing"
+
+func TestParseAddressBookEntry(t *testing.T) {
+	tests := []struct {
+		entry, name, cidrBlock string
+	}{
+		{"alice=198.51.100.7", "alice", "198.51.100.7/32"},
+		{"office = 203.0.113.0/28", "office", "203.0.113.0/28"},
+		{"家里=192.0.2.1", "家里", "192.0.2.1/32"},
+	}
+	for _, tt := range tests {
+		name, cidrBlock, err := parseAddressBookEntry(tt.entry, 16)
+		if err != nil || name != tt.name || cidrBlock != tt.cidrBlock {
+			t.Errorf("parseAddressBookEntry(%s) = %s, %s, %v", tt.entry, name, cidrBlock, err)
+		}
+	}
+
+	for _, bad := range []string{"alice", "=198.51.100.7", "a|b=198.51.100.7", "a#b=198.51.100.7", "a@b=198.51.100.7", "my home=198.51.100.7",
+		"198.51.100.7=198.51.100.7", "everyone=0.0.0.0/0", "carrier=100.64.0.0/10"} {
+		if _, _, err := parseAddressBookEntry(bad, 16); err == nil {
+			t.Errorf("parseAddressBookEntry(%s) should fail", bad)
+		}
+	}
+}
+
+func TestAddressBookLabel(t *testing.T) {
+	book := addressBook{"alice": "198.51.100.7/32", "office": "203.0.113.0/28"}
+	tests := []struct {
+		rule FetchedRuleInfo
+		want string
+	}{
+		{FetchedRuleInfo{CidrBlock: "198.51.100.7/32"}, "alice"},
+		{FetchedRuleInfo{CidrBlock: "198.51.100.7"}, "alice"},
+		{FetchedRuleInfo{CidrBlock: "203.0.113.0/28"}, "office"},
+		{FetchedRuleInfo{CidrBlock: "192.0.2.1/32"}, "192.0.2.1/32"},
+		{FetchedRuleInfo{CidrBlock: "ipm-abc", TemplateAddresses: "198.51.100.7/32,192.0.2.1/32"}, "ipm-abc(alice,192.0.2.1/32)"},
+	}
+	for _, tt := range tests {
+		if got := book.label(tt.rule); got != tt.want {
+			t.Errorf("label(%+v) = %s, want %s", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestResolveSourceGrant(t *testing.T) {
+	wf := newTestWorkflow(t)
+	if err := saveAddressBook(wf, addressBook{"alice": "198.51.100.7/32"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		source, cidrBlock, grant string
+	}{
+		{"alice", "198.51.100.7/32", "alice"},
+		{"203.0.113.9", "203.0.113.9/32", "203.0.113.9/32"},
+		{"203.0.113.0/28", "203.0.113.0/28", "203.0.113.0/28"},
+	}
+	for _, tt := range tests {
+		cidrBlock, grant, err := resolveSource(wf, tt.source, 16)
+		if err != nil || cidrBlock != tt.cidrBlock || grant != tt.grant {
+			t.Errorf("resolveSource(%s) = %s, %s, %v", tt.source, cidrBlock, grant, err)
+		}
+	}
+	if _, _, err := resolveSource(wf, "bob", 16); err == nil {
+		t.Error("unknown entry should fail")
+	}
+}
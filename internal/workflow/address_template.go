@@ -0,0 +1,113 @@
+package workflow
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/BurntSushi/toml"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// frpcUser 返回 frpc.toml 中的 user，未配置时使用当前系统用户名
+func frpcUser(cfg *config.Config) string {
+	var frpcConf SimpleFrpcConfig
+	if _, err := toml.DecodeFile(cfg.FrpcTomlPath, &frpcConf); err == nil && frpcConf.User != "" {
+		return frpcConf.User
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "default"
+}
+
+// templateNamePrefix Workflow 维护的地址模板、协议端口模板名称前缀，其后为属主
+const templateNamePrefix = "AlfredFRP-"
+
+// ownerTemplateName 返回 Workflow 为属主维护的 IP 地址模板和协议端口模板的名称。
+// 两种模板是不同的资源，同名不会冲突；引用协议端口模板的规则也以此为备注
+func ownerTemplateName(owner string) string {
+	return templateNamePrefix + owner
+}
+
+// describeAddressTemplate 按名称或 ID 查询 IP 地址模板，不存在时返回 nil
+func describeAddressTemplate(client *vpc.Client, filterName, value string) (*vpc.AddressTemplate, error) {
+	request := vpc.NewDescribeAddressTemplatesRequest()
+	request.Filters = []*vpc.Filter{
+		{Name: common.StringPtr(filterName), Values: common.StringPtrs([]string{value})},
+	}
+	response, err := client.DescribeAddressTemplates(request)
+	if err != nil {
+		return nil, sdkError("查询地址模板", err)
+	}
+	if response.Response == nil {
+		return nil, nil
+	}
+	for _, template := range response.Response.AddressTemplateSet {
+		// 名称过滤为模糊匹配，需要再精确比对一次
+		if filterName == "address-template-name" && stringValue(template.AddressTemplateName) != value {
+			continue
+		}
+		return template, nil
+	}
+	return nil, nil
+}
+
+// addressTemplateAddresses 返回地址模板中的全部地址
+func addressTemplateAddresses(template *vpc.AddressTemplate) []string {
+	var addresses []string
+	for _, address := range template.AddressSet {
+		addresses = append(addresses, stringValue(address))
+	}
+	if len(addresses) == 0 {
+		for _, extra := range template.AddressExtraSet {
+			addresses = append(addresses, stringValue(extra.Address))
+		}
+	}
+	return addresses
+}
+
+// ensureAddressTemplate 确保名为 name 的地址模板存在且只包含 cidrBlock，返回模板 ID。
+// 已存在的模板地址不同时直接修改模板，所有引用该模板的规则随之生效。
+func ensureAddressTemplate(client *vpc.Client, name, cidrBlock string) (string, error) {
+	template, err := describeAddressTemplate(client, "address-template-name", name)
+	if err != nil {
+		return "", err
+	}
+
+	if template == nil {
+		request := vpc.NewCreateAddressTemplateRequest()
+		request.AddressTemplateName = common.StringPtr(name)
+		request.Addresses = common.StringPtrs([]string{cidrBlock})
+		response, err := client.CreateAddressTemplate(request)
+		if err != nil {
+			return "", sdkError("创建地址模板", err)
+		}
+		if response.Response == nil || response.Response.AddressTemplate == nil {
+			return "", fmt.Errorf("创建地址模板 %s 未返回模板信息", name)
+		}
+		id := stringValue(response.Response.AddressTemplate.AddressTemplateId)
+		log.Info("创建地址模板 %s(%s): %s", name, id, cidrBlock)
+		return id, nil
+	}
+
+	id := stringValue(template.AddressTemplateId)
+	addresses := addressTemplateAddresses(template)
+	if len(addresses) == 1 && (addresses[0] == cidrBlock || addresses[0]+"/32" == cidrBlock) {
+		log.Info("地址模板 %s(%s) 已是 %s，无需更新", name, id, cidrBlock)
+		return id, nil
+	}
+
+	request := vpc.NewModifyAddressTemplateAttributeRequest()
+	request.AddressTemplateId = common.StringPtr(id)
+	request.Addresses = common.StringPtrs([]string{cidrBlock})
+	if _, err := client.ModifyAddressTemplateAttribute(request); err != nil {
+		return "", sdkError("更新地址模板", err)
+	}
+	log.Info("更新地址模板 %s(%s): %s -> %s", name, id, strings.Join(addresses, ","), cidrBlock)
+	return id, nil
+}
@@ -0,0 +1,379 @@
+package workflow
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// 后台任务的标识，launchd 的 Label 与 systemd 的 unit 名，非默认 profile 的任务再加上 profile 名
+const (
+	agentLabel    = "com.alfred-frp-sg.watch"
+	agentUnitName = "alfred-frp-sg-watch"
+)
+
+// defaultAgentInterval 后台任务检测公网 IP 的默认间隔
+const defaultAgentInterval = 5 * time.Minute
+
+// agentOutputSuffix launchd 任务的标准输出和标准错误写入 LOG_PATH 加此后缀的文件
+const agentOutputSuffix = ".agent"
+
+// agentEnvKeys 写入后台任务的环境变量，密钥及 SECRET_PASSPHRASE 不写入文件，仍从密钥存储读取
+var agentEnvKeys = []string{
+	"FRPC_TOML_PATH",
+	"SECURITY_GROUP_ID",
+	"INSTANCE_ID",
+	"REGION",
+	"LOG_PATH",
+	"PROVIDER",
+	"API_ENDPOINT",
+	"ADDRESS_TEMPLATE",
+	"SERVICE_TEMPLATE",
+	"IP_RESOLVERS",
+	"IP_TIMEOUT",
+	"IP_QUORUM",
+	"OWNER",
+	"SSH_HOST",
+	"SSH_PORT",
+	"NFT_INPUT_CHAIN",
+	"PROFILE",
+	"CREDENTIALS",
+	"SECRET_STORE",
+	"TENCENTCLOUD_CREDENTIALS_FILE",
+	"ROLE_ARN",
+	"ROLE_DURATION",
+	"alfred_workflow_bundleid",
+	"alfred_workflow_cache",
+	"alfred_workflow_data",
+}
+
+// agentSpec 描述一个定时执行的后台任务
+type agentSpec struct {
+	Binary   string
+	Args     []string
+	Env      map[string]string
+	Interval time.Duration
+	LogPath  string
+	// Profile 任务使用的 profile，每个 profile 可以有各自的任务
+	Profile string
+}
+
+// label 返回 launchd 的 Label
+func (s agentSpec) label() string {
+	if config.IsDefaultProfile(s.Profile) {
+		return agentLabel
+	}
+	return agentLabel + "." + sanitizeOwner(s.Profile)
+}
+
+// unitName 返回 systemd 的 unit 名
+func (s agentSpec) unitName() string {
+	if config.IsDefaultProfile(s.Profile) {
+		return agentUnitName
+	}
+	return agentUnitName + "-" + sanitizeOwner(s.Profile)
+}
+
+// agentFile 需要写入的单个文件
+type agentFile struct {
+	Path    string
+	Content string
+}
+
+// newAgentSpec 以当前可执行文件和环境变量生成定时执行 watch once 的后台任务，
+// profile 非空时写入 PROFILE，任务固定使用该 profile，不随 profile use 切换
+func newAgentSpec(interval time.Duration, profile string) (agentSpec, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return agentSpec{}, fmt.Errorf("获取可执行文件路径失败: %w", err)
+	}
+	if binary, err = filepath.Abs(binary); err != nil {
+		return agentSpec{}, err
+	}
+
+	env := make(map[string]string)
+	for _, key := range agentEnvKeys {
+		if value := os.Getenv(key); value != "" {
+			env[key] = value
+		}
+	}
+	if profile != "" {
+		env["PROFILE"] = profile
+	}
+	return agentSpec{
+		Binary:   binary,
+		Args:     []string{"watch", "once"},
+		Env:      env,
+		Interval: interval,
+		LogPath:  os.Getenv("LOG_PATH"),
+		Profile:  profile,
+	}, nil
+}
+
+// sortedEnvKeys 返回排序后的环境变量名，保证生成的文件内容稳定
+func sortedEnvKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// xmlEscape 转义 plist 中的文本
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// renderLaunchdPlist 生成 macOS launchd 的 LaunchAgent plist
+func renderLaunchdPlist(spec agentSpec) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + spec.label() + `</string>
+	<key>ProgramArguments</key>
+	<array>
+`)
+	for _, arg := range append([]string{spec.Binary}, spec.Args...) {
+		fmt.Fprintf(&b, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	b.WriteString("\t</array>\n\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+	for _, key := range sortedEnvKeys(spec.Env) {
+		fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(key), xmlEscape(spec.Env[key]))
+	}
+	b.WriteString("\t</dict>\n")
+	fmt.Fprintf(&b, "\t<key>StartInterval</key>\n\t<integer>%d</integer>\n", int(spec.Interval.Seconds()))
+	b.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	if spec.LogPath != "" {
+		// 程序自身的日志已写入 LOG_PATH，标准输出另存一个文件，避免 launchd 与日志同时追加同一文件
+		outputPath := xmlEscape(spec.LogPath + agentOutputSuffix)
+		fmt.Fprintf(&b, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", outputPath)
+		fmt.Fprintf(&b, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", outputPath)
+	}
+	b.WriteString("</dict>\n</plist>\n")
+	return b.String()
+}
+
+// systemdQuote 按 systemd 的规则为参数加引号，并转义 % 说明符
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "%", "%%")
+	return `"` + s + `"`
+}
+
+// renderSystemdService 生成 systemd 用户级 service，由同名 timer 定时触发
+func renderSystemdService(spec agentSpec) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\nDescription=Alfred FRP 安全组助手: 公网 IP 变化时改指向规则\n\n[Service]\nType=oneshot\n")
+	for _, key := range sortedEnvKeys(spec.Env) {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(key+"="+spec.Env[key]))
+	}
+	command := []string{systemdQuote(spec.Binary)}
+	for _, arg := range spec.Args {
+		command = append(command, systemdQuote(arg))
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(command, " "))
+	return b.String()
+}
+
+// renderSystemdTimer 生成 systemd 用户级 timer
+func renderSystemdTimer(spec agentSpec) string {
+	return fmt.Sprintf(`[Unit]
+Description=定时运行 %s.service
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=%ds
+Unit=%s.service
+
+[Install]
+WantedBy=timers.target
+`, spec.unitName(), int(spec.Interval.Seconds()), spec.unitName())
+}
+
+// agentFiles 返回当前系统需要写入的文件，以及加载/卸载所需的命令
+func agentFiles(goos, home string, spec agentSpec) (files []agentFile, load, unload [][]string, err error) {
+	switch goos {
+	case "darwin":
+		path := filepath.Join(home, "Library", "LaunchAgents", spec.label()+".plist")
+		files = []agentFile{{Path: path, Content: renderLaunchdPlist(spec)}}
+		load = [][]string{{"launchctl", "load", "-w", path}}
+		unload = [][]string{{"launchctl", "unload", "-w", path}}
+	case "linux":
+		unit := spec.unitName()
+		dir := filepath.Join(home, ".config", "systemd", "user")
+		files = []agentFile{
+			{Path: filepath.Join(dir, unit+".service"), Content: renderSystemdService(spec)},
+			{Path: filepath.Join(dir, unit+".timer"), Content: renderSystemdTimer(spec)},
+		}
+		load = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", unit + ".timer"},
+		}
+		unload = [][]string{
+			{"systemctl", "--user", "disable", "--now", unit + ".timer"},
+		}
+	default:
+		err = fmt.Errorf("不支持在 %s 上安装后台任务", goos)
+	}
+	return files, load, unload, err
+}
+
+// runCommands 依次执行命令，返回第一个失败的命令
+func runCommands(commands [][]string) error {
+	for _, command := range commands {
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s 执行失败: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(output)))
+		}
+		log.Info("执行成功: %s", strings.Join(command, " "))
+	}
+	return nil
+}
+
+// InstallAgent 写入并加载后台任务，定时执行 watch once
+func InstallAgent(wf *aw.Workflow, args []string) {
+	interval := defaultAgentInterval
+	for _, arg := range args {
+		parsed, err := time.ParseDuration(strings.TrimPrefix(arg, "interval="))
+		if !strings.HasPrefix(arg, "interval=") || err != nil || parsed < time.Minute {
+			wf.NewItem("参数错误").Subtitle("用法: install-agent [interval=5m]，间隔不能小于 1 分钟").Valid(false).Icon(aw.IconError)
+			wf.SendFeedback()
+			return
+		}
+		interval = parsed
+	}
+
+	profile := agentProfile()
+	err := installAgent(interval, profile)
+	if err != nil {
+		log.Error("安装后台任务失败: %v", err)
+		wf.NewItem("安装后台任务失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+	} else {
+		wf.NewItem("后台任务已安装").Subtitle(fmt.Sprintf("每 %s 检测一次公网 IP%s", interval, profileSuffix(profile))).Valid(false).Icon(aw.IconInfo)
+	}
+	wf.SendFeedback()
+}
+
+// agentProfile 返回当前生效的 profile，后台任务按 profile 区分
+func agentProfile() string {
+	cfg, err := config.LoadPartial()
+	if err != nil {
+		log.Warn("读取配置失败，后台任务不区分 profile: %v", err)
+		return ""
+	}
+	return cfg.Profile
+}
+
+// profileSuffix 返回提示中的 profile 说明，默认 profile 不显示
+func profileSuffix(profile string) string {
+	if config.IsDefaultProfile(profile) {
+		return ""
+	}
+	return "（profile: " + profile + "）"
+}
+
+// checkAgentCredential 检查后台任务能否取得当前生效的密钥，loadErr 为查找密钥时的错误。
+// 后台任务不写入 SECRET_ID/SECRET_KEY、TENCENTCLOUD_* 和 SECRET_PASSPHRASE 环境变量，
+// 密钥只来自这些变量或只保存在加密文件中时，后台任务每次运行都会失败，拒绝安装
+func checkAgentCredential(cfg *config.Config, cred *config.Credential, loadErr error) error {
+	if !cfg.UsesCloudAPI() {
+		return nil
+	}
+	const hint = "请改用 keychain/secret-service 或 ~/.tencentcloud/credentials 保存密钥"
+	if loadErr != nil || cred == nil {
+		return fmt.Errorf("未找到可用的密钥，后台任务无法调用云 API: %v", loadErr)
+	}
+	switch {
+	case cred.Source == config.CredentialSourceVariables && (os.Getenv("SECRET_ID") != "" || os.Getenv("SECRET_KEY") != ""),
+		cred.Source == config.CredentialSourceTencentEnv:
+		return fmt.Errorf("密钥来自环境变量 %s，不会写入后台任务；%s", cred.Source, hint)
+	case cred.Source == cfg.SecretStoreSource() && cfg.SecretStoreName() == config.SecretStoreFile:
+		return errors.New("密钥保存在加密文件中，后台任务没有 SECRET_PASSPHRASE 无法读取；" + hint)
+	}
+	return nil
+}
+
+func installAgent(interval time.Duration, profile string) error {
+	cfg, err := config.LoadPartial()
+	if err != nil {
+		return err
+	}
+	cred, err := config.LoadCredential()
+	if err := checkAgentCredential(cfg, cred, err); err != nil {
+		return err
+	}
+	spec, err := newAgentSpec(interval, profile)
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	files, load, _, err := agentFiles(runtime.GOOS, home, spec)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file.Path, []byte(file.Content), 0o644); err != nil {
+			return fmt.Errorf("写入 %s 失败: %w", file.Path, err)
+		}
+		log.Info("已写入后台任务文件: %s", file.Path)
+	}
+	return runCommands(load)
+}
+
+// UninstallAgent 停止并删除当前 profile 的后台任务
+func UninstallAgent(wf *aw.Workflow) {
+	profile := agentProfile()
+	if err := uninstallAgent(profile); err != nil {
+		log.Error("卸载后台任务失败: %v", err)
+		wf.NewItem("卸载后台任务失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+	} else {
+		wf.NewItem("后台任务已卸载" + profileSuffix(profile)).Valid(false).Icon(aw.IconInfo)
+	}
+	wf.SendFeedback()
+}
+
+func uninstallAgent(profile string) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	files, _, unload, err := agentFiles(runtime.GOOS, home, agentSpec{Profile: profile})
+	if err != nil {
+		return err
+	}
+	if err := runCommands(unload); err != nil {
+		// 任务可能从未加载，继续删除文件
+		log.Warn("停止后台任务失败: %v", err)
+	}
+	for _, file := range files {
+		if err := os.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("删除 %s 失败: %w", file.Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package workflow
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+var updateGolden = flag.Bool("update", false, "重新生成 testdata 中的 golden 文件")
+
+// goldenAgentSpec 包含需要转义的字符，用于覆盖 plist 与 systemd 的转义逻辑
+var goldenAgentSpec = agentSpec{
+	Binary: "/Users/me/Alfred Workflows/alfred-frp-sg",
+	Args:   []string{"watch", "once"},
+	Env: map[string]string{
+		"FRPC_TOML_PATH":    "/Users/me/.frp/frpc.toml",
+		"SECURITY_GROUP_ID": "sg-aaaa,sg-bbbb",
+		"REGION":            "ap-guangzhou",
+		"LOG_PATH":          "/Users/me/.frp/alfred-frp.log",
+		"SSH_HOST":          `a&b "100%"`,
+	},
+	Interval: 5 * time.Minute,
+	LogPath:  "/Users/me/.frp/alfred-frp.log",
+}
+
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 golden 文件失败: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func TestRenderLaunchdPlist(t *testing.T) {
+	checkGolden(t, "agent.plist.golden", renderLaunchdPlist(goldenAgentSpec))
+}
+
+func TestRenderSystemdUnits(t *testing.T) {
+	checkGolden(t, "agent.service.golden", renderSystemdService(goldenAgentSpec))
+	checkGolden(t, "agent.timer.golden", renderSystemdTimer(goldenAgentSpec))
+}
+
+func TestAgentFiles(t *testing.T) {
+	files, load, unload, err := agentFiles("linux", "/home/me", goldenAgentSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Path != "/home/me/.config/systemd/user/alfred-frp-sg-watch.service" ||
+		files[1].Path != "/home/me/.config/systemd/user/alfred-frp-sg-watch.timer" {
+		t.Errorf("linux files = %+v", files)
+	}
+	if len(load) != 2 || len(unload) != 1 {
+		t.Errorf("linux load=%v unload=%v", load, unload)
+	}
+
+	files, _, _, err = agentFiles("darwin", "/Users/me", goldenAgentSpec)
+	if err != nil || len(files) != 1 || files[0].Path != "/Users/me/Library/LaunchAgents/com.alfred-frp-sg.watch.plist" {
+		t.Errorf("darwin files = %+v, %v", files, err)
+	}
+
+	// 非默认 profile 的任务使用各自的名称，可以与默认任务共存
+	work := goldenAgentSpec
+	work.Profile = "work"
+	files, load, _, _ = agentFiles("linux", "/home/me", work)
+	if files[0].Path != "/home/me/.config/systemd/user/alfred-frp-sg-watch-work.service" || load[1][4] != "alfred-frp-sg-watch-work.timer" ||
+		!strings.Contains(files[1].Content, "Unit=alfred-frp-sg-watch-work.service") {
+		t.Errorf("work profile files = %+v, load = %v", files, load)
+	}
+	files, _, _, _ = agentFiles("darwin", "/Users/me", work)
+	if files[0].Path != "/Users/me/Library/LaunchAgents/com.alfred-frp-sg.watch.work.plist" ||
+		!strings.Contains(files[0].Content, "<string>com.alfred-frp-sg.watch.work</string>") {
+		t.Errorf("work profile plist = %+v", files)
+	}
+	work.Profile = "default"
+	if work.label() != agentLabel || work.unitName() != agentUnitName {
+		t.Errorf("default profile should keep the original names: %s %s", work.label(), work.unitName())
+	}
+
+	if _, _, _, err := agentFiles("windows", "C:\\", goldenAgentSpec); err == nil {
+		t.Error("windows should be unsupported")
+	}
+}
+
+func TestCheckAgentCredential(t *testing.T) {
+	t.Setenv("SECRET_ID", "")
+	t.Setenv("SECRET_KEY", "")
+	fileStore := &config.Config{SecretStore: config.SecretStoreFile}
+	if err := checkAgentCredential(fileStore, &config.Credential{Source: fileStore.SecretStoreSource()}, nil); err == nil {
+		t.Error("credentials only in the encrypted file should be rejected")
+	}
+	if err := checkAgentCredential(fileStore, &config.Credential{Source: "/home/me/.tencentcloud/credentials [default]"}, nil); err != nil {
+		t.Errorf("credentials file should be accepted: %v", err)
+	}
+	if err := checkAgentCredential(&config.Config{}, nil, config.ErrCredentialNotFound); err == nil {
+		t.Error("missing credentials should be rejected")
+	}
+
+	// 环境变量中的密钥不会写入后台任务；配置文件 profile 中的 SECRET_ID/SECRET_KEY 后台任务仍可读取
+	cfg := &config.Config{}
+	if err := checkAgentCredential(cfg, &config.Credential{Source: config.CredentialSourceTencentEnv}, nil); err == nil {
+		t.Error("TENCENTCLOUD_* credentials should be rejected")
+	}
+	if err := checkAgentCredential(cfg, &config.Credential{Source: config.CredentialSourceVariables}, nil); err != nil {
+		t.Errorf("SECRET_ID/SECRET_KEY from the config file should be accepted: %v", err)
+	}
+	t.Setenv("SECRET_ID", "AKID")
+	t.Setenv("SECRET_KEY", "key")
+	if err := checkAgentCredential(cfg, &config.Credential{Source: config.CredentialSourceVariables}, nil); err == nil {
+		t.Error("SECRET_ID/SECRET_KEY workflow variables should be rejected")
+	}
+
+	hostFirewall := &config.Config{SecretStore: config.SecretStoreFile, Provider: config.ProviderNftables}
+	if err := checkAgentCredential(hostFirewall, nil, config.ErrCredentialNotFound); err != nil {
+		t.Errorf("host firewall needs no credentials: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// Backend 抽象规则的存放位置（VPC 安全组、轻量应用服务器防火墙等）。
+// 一个 Backend 可以管理多个规则组，open/close 会作用于所有规则组，list 会分别读取各规则组。
+type Backend interface {
+	// Groups 返回规则组 ID 列表，例如安全组 ID 或实例 ID
+	Groups() []string
+	// ListGroupRules 返回规则组中由 Workflow 创建的规则，以 proxy name 为 key
+	ListGroupRules(group string) (map[string]FetchedRuleInfo, error)
+	// OpenInGroup 删除同一服务相同协议和端口的旧规则，并放行 cidrBlock 访问
+	OpenInGroup(group, serviceName, protocol, port, cidrBlock, description string) error
+	// CloseInGroup 先创建拒绝规则再删除原 ACCEPT 规则，按 协议+端口+CIDR+备注 精确匹配，
+	// description 须为 AlfredFRP_ 备注；规则组中没有该规则时返回 errRuleNotInGroup
+	CloseInGroup(group, protocol, port, cidrBlock, description string) error
+}
+
+// errRuleNotInGroup 表示规则组中不存在待关闭的规则
+var errRuleNotInGroup = errors.New("安全组中不存在该规则")
+
+// newBackend 根据 PROVIDER 配置创建对应的后端
+func newBackend(wf *aw.Workflow, cfg *config.Config, cred *config.Credential) (Backend, error) {
+	switch cfg.Provider {
+	case "", config.ProviderTencent:
+		if err := resolveSecurityGroups(wf, cfg, cred); err != nil {
+			return nil, err
+		}
+		client, err := newVpcClient(cfg, cred)
+		if err != nil {
+			return nil, err
+		}
+		backend := &vpcBackend{client: client, groups: cfg.SecurityGroups()}
+		if cfg.UseAddressTemplate() {
+			backend.templateName = ownerTemplateName(ruleOwner(cfg))
+		}
+		if cfg.UseServiceTemplate() {
+			backend.serviceTemplate = &serviceTemplateState{name: ownerTemplateName(ruleOwner(cfg))}
+		}
+		return backend, nil
+	case config.ProviderLighthouse:
+		instances := cfg.InstanceIds()
+		if len(instances) == 0 {
+			return nil, errors.New("使用轻量应用服务器防火墙时必须配置 INSTANCE_ID")
+		}
+		return newLighthouseBackend(newLighthouseClient(cfg, cred), instances), nil
+	case config.ProviderAWS:
+		groups := cfg.SecurityGroups()
+		if len(groups) == 0 {
+			return nil, errors.New("使用 AWS 安全组时必须配置 SECURITY_GROUP_ID")
+		}
+		return newAWSBackend(newEC2Client(cfg.Endpoint, cfg.Region, cred.SecretId, cred.SecretKey), groups), nil
+	case config.ProviderAliyun:
+		groups := cfg.SecurityGroups()
+		if len(groups) == 0 {
+			return nil, errors.New("使用阿里云安全组时必须配置 SECURITY_GROUP_ID")
+		}
+		return newAliyunBackend(newECSClient(cfg.Endpoint, cfg.Region, cred.SecretId, cred.SecretKey), groups), nil
+	case config.ProviderNftables, config.ProviderIptables:
+		return newHostFirewallBackend(cfg)
+	default:
+		return nil, fmt.Errorf("不支持的 PROVIDER: %s", cfg.Provider)
+	}
+}
+
+// loadCredentials 读取云 API 密钥，配置了 ROLE_ARN 时换取临时密钥，失败时输出提示并返回 false；
+// 本机防火墙后端不需要密钥
+func loadCredentials(wf *aw.Workflow, cfg *config.Config) (*config.Credential, bool) {
+	if !cfg.UsesCloudAPI() {
+		return &config.Credential{}, true
+	}
+	cred, err := config.LoadCredential()
+	if err != nil {
+		log.Error("获取密钥失败: %v", err)
+		wf.NewItem("SecretId 或 SecretKey 未配置").Subtitle("请使用 'frp config setup_keys' 设置: " + err.Error()).Valid(false).Icon(aw.IconWarning)
+		wf.SendFeedback()
+		return nil, false
+	}
+	if cred, err = assumeRoleIfConfigured(wf, cfg, cred); err != nil {
+		log.Error("获取临时密钥失败: %v", err)
+		wf.NewItem("获取临时密钥失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+		wf.SendFeedback()
+		return nil, false
+	}
+	log.Info("使用来自 %s 的密钥", cred.Source)
+	return cred, true
+}
+
+// currentCredential 返回生效的密钥，找不到时返回空密钥，由调用 API 时报错
+func currentCredential(wf *aw.Workflow, cfg *config.Config) *config.Credential {
+	cred, err := config.LoadCredential()
+	if err == nil {
+		cred, err = assumeRoleIfConfigured(wf, cfg, cred)
+	}
+	if err != nil {
+		log.Warn("获取密钥失败: %v", err)
+		return &config.Credential{}
+	}
+	return cred
+}
+
+// vpcBackend 基于腾讯云 VPC 安全组的后端
+type vpcBackend struct {
+	client *vpc.Client
+	groups []string
+
+	// templateName 非空时启用地址模板模式：规则引用该 IP 地址模板，换 IP 只需更新模板
+	templateName string
+	templateId   string            // 本次运行中已同步的模板 ID
+	addresses    map[string]string // 地址模板 ID -> 模板中的地址，供展示使用
+
+	// serviceTemplate 非空时启用协议端口模板模式：一条 来源 × 模板 规则覆盖所有已开放的服务
+	serviceTemplate *serviceTemplateState
+	// otherServiceTemplates 共用安全组的其他属主的协议端口模板，以模板名称为 key，展示和 close --all 时使用
+	otherServiceTemplates map[string]*serviceTemplateState
+}
+
+// serviceTemplateOf 返回 owner 的协议端口模板状态。没有属主的旧规则不在任何模板中，使用本人的模板状态
+func (b *vpcBackend) serviceTemplateOf(owner string) *serviceTemplateState {
+	name := ownerTemplateName(owner)
+	if owner == "" || name == b.serviceTemplate.name {
+		return b.serviceTemplate
+	}
+	if state, ok := b.otherServiceTemplates[name]; ok {
+		return state
+	}
+	if b.otherServiceTemplates == nil {
+		b.otherServiceTemplates = make(map[string]*serviceTemplateState)
+	}
+	state := &serviceTemplateState{name: name}
+	b.otherServiceTemplates[name] = state
+	return state
+}
+
+func (b *vpcBackend) Groups() []string {
+	return b.groups
+}
+
+func (b *vpcBackend) ListGroupRules(group string) (map[string]FetchedRuleInfo, error) {
+	var rules map[string]FetchedRuleInfo
+	if b.serviceTemplate == nil {
+		var err error
+		if rules, err = getSecurityGroupRules(b.client, group); err != nil {
+			return nil, err
+		}
+	} else {
+		set, err := describeSecurityGroupPolicySet(b.client, group)
+		if err != nil {
+			return nil, sdkError("查询安全组规则", err)
+		}
+		rules = parseSecurityGroupRules(set, group)
+		states := []*serviceTemplateState{b.serviceTemplate}
+		for _, owner := range serviceTemplateOwners(set) {
+			if state := b.serviceTemplateOf(owner); state != b.serviceTemplate {
+				states = append(states, state)
+			}
+		}
+		for _, state := range states {
+			template, err := state.load(b.client)
+			if err != nil {
+				if state == b.serviceTemplate {
+					return nil, err
+				}
+				log.Warn("查询 %s 的协议端口模板失败，不展开其成员: %v", state.name, err)
+				continue
+			}
+			if template != nil {
+				// 模板中的成员视为已开放，覆盖同名服务遗留的逐服务规则
+				for proxyName, rule := range serviceTemplateRules(set, template, group) {
+					rules[proxyName] = rule
+				}
+			}
+		}
+	}
+	for proxyName, rule := range rules {
+		if !isAddressTemplateId(rule.CidrBlock) {
+			continue
+		}
+		rule.TemplateAddresses = b.templateAddresses(rule.CidrBlock)
+		rules[proxyName] = rule
+	}
+	return rules, nil
+}
+
+// templateAddresses 查询地址模板中的地址用于展示，查询失败时返回空字符串
+func (b *vpcBackend) templateAddresses(templateId string) string {
+	if addresses, ok := b.addresses[templateId]; ok {
+		return addresses
+	}
+	if b.addresses == nil {
+		b.addresses = make(map[string]string)
+	}
+	template, err := describeAddressTemplate(b.client, "address-template-id", templateId)
+	if err != nil || template == nil {
+		log.Warn("查询地址模板 %s 失败: %v", templateId, err)
+		b.addresses[templateId] = ""
+		return ""
+	}
+	b.addresses[templateId] = strings.Join(addressTemplateAddresses(template), ",")
+	return b.addresses[templateId]
+}
+
+// withoutTemplates 返回不使用地址模板和协议端口模板的后端。
+// 模板只描述本机的公网 IP，为他人或网段开放时直接写入 CIDR，避免改动模板影响已开放的其他服务。
+func withoutTemplates(backend Backend) Backend {
+	b, ok := backend.(*vpcBackend)
+	if !ok || (b.templateName == "" && b.serviceTemplate == nil) {
+		return backend
+	}
+	direct := *b
+	direct.templateName, direct.templateId, direct.serviceTemplate = "", "", nil
+	return &direct
+}
+
+func (b *vpcBackend) OpenInGroup(group, serviceName, protocol, port, cidrBlock, description string) error {
+	source := cidrBlock
+	if b.templateName != "" {
+		// 多个安全组共用同一个模板，每次运行只需同步一次
+		if b.templateId == "" {
+			id, err := ensureAddressTemplate(b.client, b.templateName, cidrBlock)
+			if err != nil {
+				return err
+			}
+			b.templateId = id
+		}
+		source = b.templateId
+	}
+	if b.serviceTemplate != nil {
+		return b.openWithServiceTemplate(group, serviceName, protocol, port, source, description)
+	}
+	return createSecurityGroupRuleInGroup(b.client, group, serviceName, protocol, port, source, description)
+}
+
+func (b *vpcBackend) CloseInGroup(group, protocol, port, cidrBlock, description string) error {
+	if b.serviceTemplate != nil {
+		return b.closeWithServiceTemplate(group, protocol, port, cidrBlock, description)
+	}
+	return closeRuleInGroup(b.client, group, protocol, port, cidrBlock, description)
+}
@@ -0,0 +1,369 @@
+package workflow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// ecsAPIVersion 阿里云 ECS API 版本
+const ecsAPIVersion = "2014-05-26"
+
+// 阿里云安全组规则优先级，1 为最高。
+// ACCEPT 与 DROP 都使用最高优先级：放行规则不会被低优先级的拒绝规则覆盖，
+// 而同优先级下 drop 优先于 accept，关闭后留下的拒绝规则能可靠生效。
+const (
+	aliyunAcceptPriority = "1"
+	aliyunDropPriority   = "1"
+)
+
+// aliyunBackend 基于阿里云 ECS 安全组的后端
+type aliyunBackend struct {
+	client *ecsClient
+	groups []string
+}
+
+func newAliyunBackend(client *ecsClient, groups []string) *aliyunBackend {
+	return &aliyunBackend{client: client, groups: groups}
+}
+
+func (b *aliyunBackend) Groups() []string {
+	return b.groups
+}
+
+func (b *aliyunBackend) ListGroupRules(groupId string) (map[string]FetchedRuleInfo, error) {
+	log.Info("开始查询阿里云安全组规则, 安全组ID: %s", groupId)
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	allRules := make(map[string]FetchedRuleInfo)
+	for i, rule := range permissions {
+		if !strings.HasPrefix(rule.Description, "AlfredFRP_") {
+			continue
+		}
+		proxyName := ruleKey(rule.Description)
+		allRules[proxyName] = FetchedRuleInfo{
+			PolicyDescription: rule.Description,
+			Protocol:          rule.Protocol,
+			Port:              rule.Port,
+			CidrBlock:         rule.CidrBlock,
+			PolicyIndex:       int64(i),
+			ModifyTime:        rule.CreateTime,
+			Action:            rule.Action,
+			LocalPort:         extractLocalPort(rule.Description),
+			SecurityGroupId:   groupId,
+		}
+	}
+	log.Info("解析完成，找到 %d 个符合条件的规则", len(allRules))
+	return allRules, nil
+}
+
+func (b *aliyunBackend) OpenInGroup(groupId, serviceName, protocol, port, cidrBlock, description string) error {
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return err
+	}
+
+	// 撤销同名服务、相同协议和端口的旧规则（无论 ACCEPT 还是 DROP）
+	for _, rule := range permissions {
+		if serviceName != "" && replacesRule(rule.Description, serviceName) &&
+			strings.EqualFold(rule.Protocol, protocol) && rule.Port == port {
+			log.Info("找到匹配的规则需要删除: %s, 动作: %s, CIDR: %s", rule.Description, rule.Action, rule.CidrBlock)
+			if err := b.client.RevokeIngress(groupId, rule); err != nil {
+				log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
+			}
+		}
+	}
+
+	return b.client.AuthorizeIngress(groupId, ecsIngressRule{
+		Protocol:    protocol,
+		Port:        port,
+		CidrBlock:   cidrBlock,
+		Action:      "ACCEPT",
+		Description: description,
+	})
+}
+
+func (b *aliyunBackend) CloseInGroup(groupId, protocol, port, cidrBlock, description string) error {
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return err
+	}
+	target := findECSRule(permissions, protocol, port, cidrBlock, "ACCEPT", description)
+	if target == nil {
+		return errRuleNotInGroup
+	}
+
+	// 1. 创建对应规则的DROP版本
+	err = b.client.AuthorizeIngress(groupId, ecsIngressRule{
+		Protocol:    protocol,
+		Port:        port,
+		CidrBlock:   cidrBlock,
+		Action:      "DROP",
+		Description: description,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 2. 按规则内容撤销原有的ACCEPT规则
+	return b.client.RevokeIngress(groupId, *target)
+}
+
+// findECSRule 按 协议+端口+CIDR+动作+备注 查找安全组规则
+func findECSRule(rules []ecsIngressRule, protocol, port, cidrBlock, action, description string) *ecsIngressRule {
+	for i := range rules {
+		rule := &rules[i]
+		if strings.EqualFold(rule.Protocol, protocol) && rule.Port == port && rule.CidrBlock == cidrBlock &&
+			rule.Action == action && rule.Description == description {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ecsIngressRule 一条阿里云安全组入方向规则，Action 使用腾讯云风格的 ACCEPT/DROP
+type ecsIngressRule struct {
+	Protocol    string
+	Port        string
+	CidrBlock   string
+	Action      string
+	Priority    string
+	Description string
+	CreateTime  string
+}
+
+// AliyunError 阿里云 API 返回的错误
+type AliyunError struct {
+	Code      string `json:"Code"`
+	Message   string `json:"Message"`
+	RequestId string `json:"RequestId"`
+}
+
+func (e *AliyunError) Error() string {
+	return fmt.Sprintf("阿里云API错误: Code=%s, Message=%s, RequestId=%s", e.Code, e.Message, e.RequestId)
+}
+
+// ecsClient 使用 RPC 风格签名调用阿里云 ECS，endpoint 可替换为本地兼容服务用于测试
+type ecsClient struct {
+	endpoint        string
+	region          string
+	accessKeyId     string
+	accessKeySecret string
+	httpClient      *http.Client
+	now             func() time.Time
+}
+
+func newECSClient(endpoint, region, accessKeyId, accessKeySecret string) *ecsClient {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://ecs.%s.aliyuncs.com/", region)
+	}
+	return &ecsClient{
+		endpoint:        endpoint,
+		region:          region,
+		accessKeyId:     accessKeyId,
+		accessKeySecret: accessKeySecret,
+		httpClient:      &http.Client{Timeout: 15 * time.Second},
+		now:             time.Now,
+	}
+}
+
+// DescribeIngress 返回安全组的入方向规则
+func (c *ecsClient) DescribeIngress(groupId string) ([]ecsIngressRule, error) {
+	var result struct {
+		Permissions struct {
+			Permission []struct {
+				IpProtocol   string `json:"IpProtocol"`
+				PortRange    string `json:"PortRange"`
+				SourceCidrIp string `json:"SourceCidrIp"`
+				Policy       string `json:"Policy"`
+				Priority     string `json:"Priority"`
+				Description  string `json:"Description"`
+				CreateTime   string `json:"CreateTime"`
+			} `json:"Permission"`
+		} `json:"Permissions"`
+	}
+	err := c.call("DescribeSecurityGroupAttribute", url.Values{
+		"SecurityGroupId": {groupId},
+		"Direction":       {"ingress"},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ecsIngressRule
+	for _, permission := range result.Permissions.Permission {
+		rules = append(rules, ecsIngressRule{
+			Protocol:    strings.ToUpper(permission.IpProtocol),
+			Port:        fromECSPortRange(permission.PortRange),
+			CidrBlock:   permission.SourceCidrIp,
+			Action:      fromECSPolicy(permission.Policy),
+			Priority:    permission.Priority,
+			Description: permission.Description,
+			CreateTime:  permission.CreateTime,
+		})
+	}
+	return rules, nil
+}
+
+func (c *ecsClient) AuthorizeIngress(groupId string, rule ecsIngressRule) error {
+	return c.call("AuthorizeSecurityGroup", ecsPermissionParams(groupId, rule), nil)
+}
+
+func (c *ecsClient) RevokeIngress(groupId string, rule ecsIngressRule) error {
+	return c.call("RevokeSecurityGroup", ecsPermissionParams(groupId, rule), nil)
+}
+
+func ecsPermissionParams(groupId string, rule ecsIngressRule) url.Values {
+	priority := rule.Priority
+	if priority == "" {
+		priority = aliyunAcceptPriority
+		if rule.Action == "DROP" {
+			priority = aliyunDropPriority
+		}
+	}
+	params := url.Values{
+		"SecurityGroupId": {groupId},
+		"IpProtocol":      {strings.ToUpper(rule.Protocol)},
+		"PortRange":       {toECSPortRange(rule.Protocol, rule.Port)},
+		"SourceCidrIp":    {rule.CidrBlock},
+		"Policy":          {toECSPolicy(rule.Action)},
+		"Priority":        {priority},
+	}
+	if rule.Description != "" {
+		params.Set("Description", rule.Description)
+	}
+	return params
+}
+
+// toECSPolicy 将 ACCEPT/DROP 转换为阿里云的 accept/drop
+func toECSPolicy(action string) string {
+	if action == "DROP" {
+		return "drop"
+	}
+	return "accept"
+}
+
+func fromECSPolicy(policy string) string {
+	if strings.EqualFold(policy, "drop") {
+		return "DROP"
+	}
+	return "ACCEPT"
+}
+
+// toECSPortRange 将 "8080" 或 "8000-8010" 转换为阿里云的 "8080/8080" 写法，ALL 协议使用 "-1/-1"
+func toECSPortRange(protocol, port string) string {
+	if strings.EqualFold(protocol, "ALL") || strings.EqualFold(port, "ALL") {
+		return "-1/-1"
+	}
+	from, to, found := strings.Cut(port, "-")
+	if !found {
+		to = from
+	}
+	return from + "/" + to
+}
+
+func fromECSPortRange(portRange string) string {
+	if portRange == "-1/-1" {
+		return "ALL"
+	}
+	from, to, found := strings.Cut(portRange, "/")
+	if !found || from == to {
+		return from
+	}
+	return from + "-" + to
+}
+
+// call 发送签名后的 RPC 请求，并将 JSON 响应解析到 result
+func (c *ecsClient) call(action string, params url.Values, result interface{}) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	params.Set("Action", action)
+	params.Set("Version", ecsAPIVersion)
+	params.Set("Format", "JSON")
+	params.Set("RegionId", c.region)
+	params.Set("AccessKeyId", c.accessKeyId)
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("SignatureNonce", hex.EncodeToString(nonce))
+	params.Set("Timestamp", c.now().UTC().Format("2006-01-02T15:04:05Z"))
+	params.Set("Signature", signAliyunRPC(http.MethodPost, params, c.accessKeySecret))
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("调用阿里云 %s 失败: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取阿里云 %s 响应失败: %w", action, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr AliyunError
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Code != "" {
+			return &apiErr
+		}
+		return fmt.Errorf("调用阿里云 %s 失败: HTTP %d: %s", action, resp.StatusCode, data)
+	}
+
+	log.Info("调用阿里云 %s 成功", action)
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("解析阿里云 %s 响应失败: %w", action, err)
+	}
+	return nil
+}
+
+// signAliyunRPC 按阿里云 RPC 风格（签名版本 1.0）计算 HMAC-SHA1 签名，params 中不应包含 Signature
+func signAliyunRPC(method string, params url.Values, accessKeySecret string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "Signature" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, aliyunPercentEncode(key)+"="+aliyunPercentEncode(params.Get(key)))
+	}
+	stringToSign := method + "&" + aliyunPercentEncode("/") + "&" + aliyunPercentEncode(strings.Join(pairs, "&"))
+
+	mac := hmac.New(sha1.New, []byte(accessKeySecret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// aliyunPercentEncode 按 RFC 3986 编码，空格编码为 %20
+func aliyunPercentEncode(s string) string {
+	encoded := url.QueryEscape(s)
+	encoded = strings.ReplaceAll(encoded, "+", "%20")
+	encoded = strings.ReplaceAll(encoded, "*", "%2A")
+	return strings.ReplaceAll(encoded, "%7E", "~")
+}
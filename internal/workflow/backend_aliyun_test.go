@@ -0,0 +1,152 @@
+package workflow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeECSServer 本地的阿里云 ECS 兼容服务，只实现安全组入方向规则相关的 RPC API
+type fakeECSServer struct {
+	mu    sync.Mutex
+	rules map[string][]fakeECSRule
+}
+
+type fakeECSRule struct {
+	IpProtocol   string
+	PortRange    string
+	SourceCidrIp string
+	Policy       string
+	Priority     string
+	Description  string
+}
+
+func (s *fakeECSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		writeECSError(w, http.StatusBadRequest, "InvalidParameter", err.Error())
+		return
+	}
+	if r.Form.Get("AccessKeyId") != "LTAITEST" || r.Form.Get("Signature") != signAliyunRPC(r.Method, r.Form, "secret") {
+		writeECSError(w, http.StatusBadRequest, "SignatureDoesNotMatch", "signature mismatch")
+		return
+	}
+
+	groupId := r.Form.Get("SecurityGroupId")
+	rule := fakeECSRule{
+		IpProtocol:   r.Form.Get("IpProtocol"),
+		PortRange:    r.Form.Get("PortRange"),
+		SourceCidrIp: r.Form.Get("SourceCidrIp"),
+		Policy:       r.Form.Get("Policy"),
+		Priority:     r.Form.Get("Priority"),
+		Description:  r.Form.Get("Description"),
+	}
+	sameRule := func(existing fakeECSRule) bool {
+		return existing.IpProtocol == rule.IpProtocol && existing.PortRange == rule.PortRange &&
+			existing.SourceCidrIp == rule.SourceCidrIp && existing.Policy == rule.Policy && existing.Priority == rule.Priority
+	}
+
+	switch r.Form.Get("Action") {
+	case "DescribeSecurityGroupAttribute":
+		resp := map[string]interface{}{
+			"SecurityGroupId": groupId,
+			"Permissions":     map[string]interface{}{"Permission": s.rules[groupId]},
+		}
+		json.NewEncoder(w).Encode(resp)
+	case "AuthorizeSecurityGroup":
+		for _, existing := range s.rules[groupId] {
+			if sameRule(existing) {
+				writeECSError(w, http.StatusBadRequest, "InvalidPermission.Duplicate", "the specified rule already exists")
+				return
+			}
+		}
+		s.rules[groupId] = append(s.rules[groupId], rule)
+		json.NewEncoder(w).Encode(map[string]string{"RequestId": "req-fake"})
+	case "RevokeSecurityGroup":
+		var kept []fakeECSRule
+		for _, existing := range s.rules[groupId] {
+			if !sameRule(existing) {
+				kept = append(kept, existing)
+			}
+		}
+		s.rules[groupId] = kept
+		json.NewEncoder(w).Encode(map[string]string{"RequestId": "req-fake"})
+	default:
+		writeECSError(w, http.StatusBadRequest, "InvalidAction.NotFound", r.Form.Get("Action"))
+	}
+}
+
+func writeECSError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(AliyunError{Code: code, Message: message, RequestId: "req-fake"})
+}
+
+// fakeAliyunServer 启动本地 ECS 模拟服务，预置一条非 Workflow 规则
+func fakeAliyunServer(t *testing.T) (*fakeECSServer, Backend) {
+	fake := &fakeECSServer{rules: map[string][]fakeECSRule{
+		"sg-1": {{IpProtocol: "TCP", PortRange: "22/22", SourceCidrIp: "0.0.0.0/0", Policy: "Accept", Priority: "1", Description: "ssh"}},
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, newAliyunBackend(newECSClient(server.URL+"/", "cn-hangzhou", "LTAITEST", "secret"), []string{"sg-1"})
+}
+
+// fakeAliyunBackend 供一致性测试使用，返回后端及安全组中的规则数
+func fakeAliyunBackend(t *testing.T) (Backend, func() int) {
+	fake, backend := fakeAliyunServer(t)
+	return backend, func() int { return len(fake.rules["sg-1"]) }
+}
+
+func TestAliyunDropRule(t *testing.T) {
+	fake, backend := fakeAliyunServer(t)
+	if err := createSecurityGroupRule(backend, "TCP", "8080", "198.51.100.8", "AlfredFRP_web_local8080"); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	if err := createDenyRuleAndDeleteOriginal(backend, "TCP", "8080", "198.51.100.8/32", "web", "8080"); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	// 拒绝规则使用小写的 drop 和较高的优先级，并按 ECS 的 起始/结束 格式写端口
+	last := fake.rules["sg-1"][len(fake.rules["sg-1"])-1]
+	if last.Policy != "drop" || last.Priority != aliyunDropPriority || last.PortRange != "8080/8080" {
+		t.Errorf("drop rule = %+v, want drop 8080/8080 with priority %s", last, aliyunDropPriority)
+	}
+}
+
+func TestSignAliyunRPC(t *testing.T) {
+	// 阿里云 RPC 签名文档中的 DescribeRegions 示例
+	params := url.Values{
+		"Action":           {"DescribeRegions"},
+		"Format":           {"XML"},
+		"Version":          {"2014-05-26"},
+		"AccessKeyId":      {"testid"},
+		"SignatureMethod":  {"HMAC-SHA1"},
+		"Timestamp":        {"2016-02-23T12:46:24Z"},
+		"SignatureVersion": {"1.0"},
+		"SignatureNonce":   {"3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf"},
+	}
+	want := "OLeaidS1JvxuMvnyHOwuJ+uX5qY="
+	if got := signAliyunRPC(http.MethodGet, params, "testsecret"); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+}
+
+func TestECSPortRange(t *testing.T) {
+	cases := []struct{ protocol, port, portRange string }{
+		{"TCP", "8080", "8080/8080"},
+		{"UDP", "8000-8010", "8000/8010"},
+		{"ALL", "ALL", "-1/-1"},
+	}
+	for _, c := range cases {
+		if got := toECSPortRange(c.protocol, c.port); got != c.portRange {
+			t.Errorf("toECSPortRange(%s, %s) = %s, want %s", c.protocol, c.port, got, c.portRange)
+		}
+		if got := fromECSPortRange(c.portRange); got != c.port {
+			t.Errorf("fromECSPortRange(%s) = %s, want %s", c.portRange, got, c.port)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package workflow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// ec2APIVersion EC2 Query API 版本
+const ec2APIVersion = "2016-11-15"
+
+// awsBackend 基于 AWS EC2 安全组的后端。
+// EC2 安全组只有放行规则，因此关闭服务时直接撤销（Revoke）规则，不会留下 DROP 规则。
+type awsBackend struct {
+	client *ec2Client
+	groups []string
+}
+
+func newAWSBackend(client *ec2Client, groups []string) *awsBackend {
+	return &awsBackend{client: client, groups: groups}
+}
+
+func (b *awsBackend) Groups() []string {
+	return b.groups
+}
+
+func (b *awsBackend) ListGroupRules(groupId string) (map[string]FetchedRuleInfo, error) {
+	log.Info("开始查询 EC2 安全组规则, 安全组ID: %s", groupId)
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return nil, err
+	}
+
+	allRules := make(map[string]FetchedRuleInfo)
+	for i, rule := range permissions {
+		if !strings.HasPrefix(rule.Description, "AlfredFRP_") {
+			continue
+		}
+		proxyName := ruleKey(rule.Description)
+		allRules[proxyName] = FetchedRuleInfo{
+			PolicyDescription: rule.Description,
+			Protocol:          strings.ToUpper(rule.Protocol),
+			Port:              rule.Port,
+			CidrBlock:         rule.CidrBlock,
+			PolicyIndex:       int64(i),
+			Action:            "ACCEPT",
+			LocalPort:         extractLocalPort(rule.Description),
+			SecurityGroupId:   groupId,
+		}
+	}
+	log.Info("解析完成，找到 %d 个符合条件的规则", len(allRules))
+	return allRules, nil
+}
+
+func (b *awsBackend) OpenInGroup(groupId, serviceName, protocol, port, cidrBlock, description string) error {
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return err
+	}
+
+	// 撤销同名服务、相同协议和端口的旧规则
+	for _, rule := range permissions {
+		if serviceName != "" && replacesRule(rule.Description, serviceName) &&
+			strings.EqualFold(rule.Protocol, protocol) && rule.Port == port {
+			log.Info("找到匹配的规则需要删除: %s, CIDR: %s", rule.Description, rule.CidrBlock)
+			if err := b.client.RevokeIngress(groupId, rule); err != nil {
+				log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
+			}
+		}
+	}
+
+	err = b.client.AuthorizeIngress(groupId, ec2IngressRule{
+		Protocol:    protocol,
+		Port:        port,
+		CidrBlock:   cidrBlock,
+		Description: description,
+	})
+	if isEC2Error(err, "InvalidPermission.Duplicate") {
+		log.Info("安全组 %s 中已存在相同规则", groupId)
+		return nil
+	}
+	return err
+}
+
+func (b *awsBackend) CloseInGroup(groupId, protocol, port, cidrBlock, description string) error {
+	permissions, err := b.client.DescribeIngress(groupId)
+	if err != nil {
+		return err
+	}
+	for _, rule := range permissions {
+		if strings.EqualFold(rule.Protocol, protocol) && rule.Port == port && rule.CidrBlock == cidrBlock && rule.Description == description {
+			return b.client.RevokeIngress(groupId, rule)
+		}
+	}
+	return errRuleNotInGroup
+}
+
+// ec2IngressRule 一条 EC2 入站规则（单个 CIDR）
+type ec2IngressRule struct {
+	Protocol    string
+	Port        string
+	CidrBlock   string
+	Description string
+}
+
+// EC2Error EC2 API 返回的错误
+type EC2Error struct {
+	Code      string
+	Message   string
+	RequestId string
+}
+
+func (e *EC2Error) Error() string {
+	return fmt.Sprintf("AWS API错误: Code=%s, Message=%s, RequestId=%s", e.Code, e.Message, e.RequestId)
+}
+
+func isEC2Error(err error, code string) bool {
+	var ec2Err *EC2Error
+	return errors.As(err, &ec2Err) && ec2Err.Code == code
+}
+
+// ec2Client 使用 Query API + SigV4 签名调用 EC2，endpoint 可替换为本地兼容服务用于测试
+type ec2Client struct {
+	endpoint        string
+	region          string
+	accessKeyId     string
+	secretAccessKey string
+	httpClient      *http.Client
+	now             func() time.Time
+}
+
+func newEC2Client(endpoint, region, accessKeyId, secretAccessKey string) *ec2Client {
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://ec2.%s.amazonaws.com/", region)
+	}
+	return &ec2Client{
+		endpoint:        endpoint,
+		region:          region,
+		accessKeyId:     accessKeyId,
+		secretAccessKey: secretAccessKey,
+		httpClient:      &http.Client{Timeout: 15 * time.Second},
+		now:             time.Now,
+	}
+}
+
+// DescribeIngress 返回安全组的入站规则，每个 CIDR 单独作为一条规则
+func (c *ec2Client) DescribeIngress(groupId string) ([]ec2IngressRule, error) {
+	var result struct {
+		Groups []struct {
+			GroupId     string `xml:"groupId"`
+			Permissions []struct {
+				IpProtocol string `xml:"ipProtocol"`
+				FromPort   string `xml:"fromPort"`
+				ToPort     string `xml:"toPort"`
+				IpRanges   []struct {
+					CidrIp      string `xml:"cidrIp"`
+					Description string `xml:"description"`
+				} `xml:"ipRanges>item"`
+			} `xml:"ipPermissions>item"`
+		} `xml:"securityGroupInfo>item"`
+	}
+	err := c.call("DescribeSecurityGroups", url.Values{"GroupId.1": {groupId}}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []ec2IngressRule
+	for _, group := range result.Groups {
+		for _, permission := range group.Permissions {
+			for _, ipRange := range permission.IpRanges {
+				rules = append(rules, ec2IngressRule{
+					Protocol:    fromEC2Protocol(permission.IpProtocol),
+					Port:        fromEC2PortRange(permission.FromPort, permission.ToPort),
+					CidrBlock:   ipRange.CidrIp,
+					Description: ipRange.Description,
+				})
+			}
+		}
+	}
+	return rules, nil
+}
+
+func (c *ec2Client) AuthorizeIngress(groupId string, rule ec2IngressRule) error {
+	params, err := ec2PermissionParams(groupId, rule, true)
+	if err != nil {
+		return err
+	}
+	return c.call("AuthorizeSecurityGroupIngress", params, nil)
+}
+
+func (c *ec2Client) RevokeIngress(groupId string, rule ec2IngressRule) error {
+	params, err := ec2PermissionParams(groupId, rule, false)
+	if err != nil {
+		return err
+	}
+	return c.call("RevokeSecurityGroupIngress", params, nil)
+}
+
+// ec2PermissionParams 生成 IpPermissions 参数；撤销规则时不需要携带描述
+func ec2PermissionParams(groupId string, rule ec2IngressRule, withDescription bool) (url.Values, error) {
+	params := url.Values{
+		"GroupId":                           {groupId},
+		"IpPermissions.1.IpProtocol":        {toEC2Protocol(rule.Protocol)},
+		"IpPermissions.1.IpRanges.1.CidrIp": {rule.CidrBlock},
+	}
+	if withDescription && rule.Description != "" {
+		params.Set("IpPermissions.1.IpRanges.1.Description", rule.Description)
+	}
+	if params.Get("IpPermissions.1.IpProtocol") != "-1" {
+		from, to, err := toEC2PortRange(rule.Port)
+		if err != nil {
+			return nil, err
+		}
+		params.Set("IpPermissions.1.FromPort", from)
+		params.Set("IpPermissions.1.ToPort", to)
+	}
+	return params, nil
+}
+
+// toEC2Protocol 将 TCP/UDP/ALL 转换为 EC2 的协议写法
+func toEC2Protocol(protocol string) string {
+	if strings.EqualFold(protocol, "ALL") {
+		return "-1"
+	}
+	return strings.ToLower(protocol)
+}
+
+func fromEC2Protocol(protocol string) string {
+	if protocol == "-1" {
+		return "ALL"
+	}
+	return strings.ToUpper(protocol)
+}
+
+// toEC2PortRange 将 "8080" 或 "8000-8010" 转换为 FromPort/ToPort
+func toEC2PortRange(port string) (string, string, error) {
+	from, to, found := strings.Cut(port, "-")
+	if !found {
+		to = from
+	}
+	for _, p := range []string{from, to} {
+		if _, err := strconv.Atoi(p); err != nil {
+			return "", "", fmt.Errorf("无效的端口: %s", port)
+		}
+	}
+	return from, to, nil
+}
+
+func fromEC2PortRange(from, to string) string {
+	if from == to || to == "" {
+		return from
+	}
+	return from + "-" + to
+}
+
+// call 发送签名后的 Query API 请求，并将 XML 响应解析到 result
+func (c *ec2Client) call(action string, params url.Values, result interface{}) error {
+	params.Set("Action", action)
+	params.Set("Version", ec2APIVersion)
+	body := params.Encode()
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWSRequestV4(req, []byte(body), c.accessKeyId, c.secretAccessKey, c.region, "ec2", c.now())
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("调用 EC2 %s 失败: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取 EC2 %s 响应失败: %w", action, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Errors []struct {
+				Code    string `xml:"Code"`
+				Message string `xml:"Message"`
+			} `xml:"Errors>Error"`
+			RequestId string `xml:"RequestID"`
+		}
+		if xml.Unmarshal(data, &errResp) == nil && len(errResp.Errors) > 0 {
+			return &EC2Error{Code: errResp.Errors[0].Code, Message: errResp.Errors[0].Message, RequestId: errResp.RequestId}
+		}
+		return fmt.Errorf("调用 EC2 %s 失败: HTTP %d: %s", action, resp.StatusCode, data)
+	}
+
+	log.Info("调用 EC2 %s 成功", action)
+	if result == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("解析 EC2 %s 响应失败: %w", action, err)
+	}
+	return nil
+}
+
+// signAWSRequestV4 按 AWS Signature Version 4 为请求添加 X-Amz-Date 与 Authorization 头
+func signAWSRequestV4(req *http.Request, body []byte, accessKeyId, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// 参与签名的请求头：host 以及全部 x-amz-* 和 content-type
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalQuery := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyId, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,124 @@
+package workflow
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEC2Server 本地的 EC2 兼容服务，只实现安全组入站规则相关的 Query API
+type fakeEC2Server struct {
+	mu    sync.Mutex
+	rules map[string][]fakeEC2Rule
+}
+
+type fakeEC2Rule struct {
+	protocol, fromPort, toPort, cidr, description string
+}
+
+func (s *fakeEC2Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/") || r.Header.Get("X-Amz-Date") == "" {
+		writeEC2Error(w, http.StatusUnauthorized, "AuthFailure", "missing signature")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeEC2Error(w, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+
+	switch r.Form.Get("Action") {
+	case "DescribeSecurityGroups":
+		groupId := r.Form.Get("GroupId.1")
+		var b strings.Builder
+		fmt.Fprintf(&b, "<DescribeSecurityGroupsResponse><securityGroupInfo><item><groupId>%s</groupId><ipPermissions>", groupId)
+		for _, rule := range s.rules[groupId] {
+			fmt.Fprintf(&b, "<item><ipProtocol>%s</ipProtocol><fromPort>%s</fromPort><toPort>%s</toPort><ipRanges><item><cidrIp>%s</cidrIp>",
+				rule.protocol, rule.fromPort, rule.toPort, rule.cidr)
+			if rule.description != "" {
+				b.WriteString("<description>")
+				xml.EscapeText(&b, []byte(rule.description))
+				b.WriteString("</description>")
+			}
+			b.WriteString("</item></ipRanges></item>")
+		}
+		b.WriteString("</ipPermissions></item></securityGroupInfo></DescribeSecurityGroupsResponse>")
+		fmt.Fprint(w, b.String())
+	case "AuthorizeSecurityGroupIngress":
+		groupId := r.Form.Get("GroupId")
+		rule := fakeEC2RuleFromForm(r)
+		for _, existing := range s.rules[groupId] {
+			if existing.protocol == rule.protocol && existing.fromPort == rule.fromPort && existing.cidr == rule.cidr {
+				writeEC2Error(w, http.StatusBadRequest, "InvalidPermission.Duplicate", "the specified rule already exists")
+				return
+			}
+		}
+		s.rules[groupId] = append(s.rules[groupId], rule)
+		fmt.Fprint(w, "<AuthorizeSecurityGroupIngressResponse><return>true</return></AuthorizeSecurityGroupIngressResponse>")
+	case "RevokeSecurityGroupIngress":
+		groupId := r.Form.Get("GroupId")
+		rule := fakeEC2RuleFromForm(r)
+		var kept []fakeEC2Rule
+		found := false
+		for _, existing := range s.rules[groupId] {
+			if existing.protocol == rule.protocol && existing.fromPort == rule.fromPort && existing.toPort == rule.toPort && existing.cidr == rule.cidr {
+				found = true
+				continue
+			}
+			kept = append(kept, existing)
+		}
+		if !found {
+			writeEC2Error(w, http.StatusBadRequest, "InvalidPermission.NotFound", "rule not found")
+			return
+		}
+		s.rules[groupId] = kept
+		fmt.Fprint(w, "<RevokeSecurityGroupIngressResponse><return>true</return></RevokeSecurityGroupIngressResponse>")
+	default:
+		writeEC2Error(w, http.StatusBadRequest, "InvalidAction", r.Form.Get("Action"))
+	}
+}
+
+func fakeEC2RuleFromForm(r *http.Request) fakeEC2Rule {
+	return fakeEC2Rule{
+		protocol:    r.Form.Get("IpPermissions.1.IpProtocol"),
+		fromPort:    r.Form.Get("IpPermissions.1.FromPort"),
+		toPort:      r.Form.Get("IpPermissions.1.ToPort"),
+		cidr:        r.Form.Get("IpPermissions.1.IpRanges.1.CidrIp"),
+		description: r.Form.Get("IpPermissions.1.IpRanges.1.Description"),
+	}
+}
+
+func writeEC2Error(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Response><Errors><Error><Code>%s</Code><Message>%s</Message></Error></Errors><RequestID>req-fake</RequestID></Response>", code, message)
+}
+
+// fakeAWSBackend 返回连接到本地 EC2 模拟服务、预置一条非 Workflow 规则的后端，供一致性测试使用
+func fakeAWSBackend(t *testing.T) (Backend, func() int) {
+	fake := &fakeEC2Server{rules: map[string][]fakeEC2Rule{
+		"sg-1": {{protocol: "tcp", fromPort: "22", toPort: "22", cidr: "0.0.0.0/0", description: "ssh"}},
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	backend := newAWSBackend(newEC2Client(server.URL+"/", "us-east-1", "AKIDTEST", "secret"), []string{"sg-1"})
+	return backend, func() int { return len(fake.rules["sg-1"]) }
+}
+
+func TestSignAWSRequestV4(t *testing.T) {
+	// AWS SigV4 测试套件中的 get-vanilla 用例
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signAWSRequestV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
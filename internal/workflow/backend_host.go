@@ -0,0 +1,445 @@
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/BurntSushi/toml"
+)
+
+// 本机防火墙中 Workflow 专用的表、链名称，配置了 NFT_INPUT_CHAIN 时 nftTable 也用作主机表中的链名
+const (
+	nftTable      = "alfred_frp"
+	nftChain      = "input"
+	iptablesChain = "ALFRED-FRP"
+)
+
+// commandRunner 在 frps 主机上执行命令，测试中可替换为假的执行器
+type commandRunner interface {
+	Run(args ...string) (string, error)
+}
+
+// sshRunner 通过系统 ssh 命令在远端执行，依赖 ~/.ssh/config 或 ssh-agent 完成免密登录
+type sshRunner struct {
+	target string // user@host
+	port   string
+	sudo   bool
+}
+
+func newSSHRunner(target, port string) *sshRunner {
+	user, _, found := strings.Cut(target, "@")
+	return &sshRunner{target: target, port: port, sudo: found && user != "root"}
+}
+
+func (r *sshRunner) Run(args ...string) (string, error) {
+	quoted := make([]string, 0, len(args)+2)
+	if r.sudo {
+		quoted = append(quoted, "sudo", "-n")
+	}
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	sshArgs := []string{"-o", "BatchMode=yes", "-o", "ConnectTimeout=10"}
+	if r.port != "" {
+		sshArgs = append(sshArgs, "-p", r.port)
+	}
+	sshArgs = append(sshArgs, r.target, strings.Join(quoted, " "))
+
+	log.Debug("ssh %s: %s", r.target, strings.Join(quoted, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("ssh", sshArgs...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.String(), fmt.Errorf("%s 执行失败: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// shellQuote 用单引号包裹参数，供远端 shell 原样传递
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@=", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// hostRule 本机防火墙中的一条规则，Comment 对应云安全组规则的备注
+type hostRule struct {
+	Protocol  string
+	Port      string
+	CidrBlock string
+	Action    string
+	Comment   string
+	handle    string // nftables 规则句柄，删除时使用
+}
+
+// hostFirewall 对 nftables/iptables 中 Workflow 专用链的操作
+type hostFirewall interface {
+	// ensure 创建专用的表和链，已存在时不做修改
+	ensure() error
+	// list 返回专用链中的规则，链不存在时返回空列表
+	list() ([]hostRule, error)
+	add(rule hostRule) error
+	remove(rule hostRule) error
+}
+
+// hostBackend 基于 frps 主机本机防火墙的后端，规则组即 frps 主机
+type hostBackend struct {
+	firewall hostFirewall
+	host     string
+}
+
+func newHostBackend(firewall hostFirewall, host string) *hostBackend {
+	return &hostBackend{firewall: firewall, host: host}
+}
+
+func (b *hostBackend) Groups() []string {
+	return []string{b.host}
+}
+
+func (b *hostBackend) ListGroupRules(host string) (map[string]FetchedRuleInfo, error) {
+	log.Info("开始查询主机防火墙规则, 主机: %s", host)
+	rules, err := b.firewall.list()
+	if err != nil {
+		return nil, err
+	}
+
+	allRules := make(map[string]FetchedRuleInfo)
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule.Comment, "AlfredFRP_") {
+			continue
+		}
+		proxyName := ruleKey(rule.Comment)
+		allRules[proxyName] = FetchedRuleInfo{
+			PolicyDescription: rule.Comment,
+			Protocol:          rule.Protocol,
+			Port:              rule.Port,
+			CidrBlock:         rule.CidrBlock,
+			PolicyIndex:       int64(i),
+			Action:            rule.Action,
+			LocalPort:         extractLocalPort(rule.Comment),
+			SecurityGroupId:   host,
+		}
+	}
+	log.Info("解析完成，找到 %d 个符合条件的规则", len(allRules))
+	return allRules, nil
+}
+
+func (b *hostBackend) OpenInGroup(host, serviceName, protocol, port, cidrBlock, description string) error {
+	if err := b.firewall.ensure(); err != nil {
+		return err
+	}
+	rules, err := b.firewall.list()
+	if err != nil {
+		return err
+	}
+
+	// 删除同名服务、相同协议和端口的旧规则（无论 ACCEPT 还是 DROP）
+	for _, rule := range rules {
+		if serviceName != "" && replacesRule(rule.Comment, serviceName) &&
+			strings.EqualFold(rule.Protocol, protocol) && rule.Port == port {
+			log.Info("找到匹配的规则需要删除: %s, 动作: %s, CIDR: %s", rule.Comment, rule.Action, rule.CidrBlock)
+			if err := b.firewall.remove(rule); err != nil {
+				log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
+			}
+		}
+	}
+
+	return b.firewall.add(hostRule{
+		Protocol:  protocol,
+		Port:      port,
+		CidrBlock: cidrBlock,
+		Action:    "ACCEPT",
+		Comment:   description,
+	})
+}
+
+func (b *hostBackend) CloseInGroup(host, protocol, port, cidrBlock, description string) error {
+	rules, err := b.firewall.list()
+	if err != nil {
+		return err
+	}
+	var target *hostRule
+	for i := range rules {
+		rule := &rules[i]
+		if strings.EqualFold(rule.Protocol, protocol) && rule.Port == port && rule.CidrBlock == cidrBlock &&
+			rule.Action == "ACCEPT" && rule.Comment == description {
+			target = rule
+			break
+		}
+	}
+	if target == nil {
+		return errRuleNotInGroup
+	}
+
+	// 1. 创建对应规则的DROP版本
+	err = b.firewall.add(hostRule{
+		Protocol:  protocol,
+		Port:      port,
+		CidrBlock: cidrBlock,
+		Action:    "DROP",
+		Comment:   description,
+	})
+	if err != nil {
+		return err
+	}
+
+	// 2. 删除原有的ACCEPT规则
+	return b.firewall.remove(*target)
+}
+
+// newHostFirewallBackend 根据 PROVIDER 创建 nftables 或 iptables 后端，SSH_HOST 未配置时使用 root@serverAddr
+func newHostFirewallBackend(cfg *config.Config) (Backend, error) {
+	target := cfg.SSHHost
+	if target == "" {
+		var frpcConf SimpleFrpcConfig
+		if _, err := toml.DecodeFile(cfg.FrpcTomlPath, &frpcConf); err != nil {
+			return nil, fmt.Errorf("frpc.toml 解析失败: %w", err)
+		}
+		if frpcConf.ServerAddr == "" {
+			return nil, errors.New("未配置 SSH_HOST，且 frpc.toml 中没有 serverAddr")
+		}
+		target = "root@" + frpcConf.ServerAddr
+	}
+
+	runner := newSSHRunner(target, cfg.SSHPort)
+	host := target[strings.Index(target, "@")+1:]
+	if cfg.Provider == config.ProviderIptables {
+		return newHostBackend(&iptablesFirewall{runner: runner}, host), nil
+	}
+	firewall, err := newNftablesFirewall(runner, cfg.NftInputChain)
+	if err != nil {
+		return nil, err
+	}
+	return newHostBackend(firewall, host), nil
+}
+
+// nftablesFirewall 管理 nftables 中 Workflow 专用链的规则，规则备注写入 comment。
+//
+// 未配置 NFT_INPUT_CHAIN 时使用独立的 inet alfred_frp 表及其 input 基础链。nftables 中每个基础链都会独立判定，
+// 这里的 accept 不会跳过主机自身 input 链（如 inet filter input）中的 drop，因此只适用于 input 默认放行的主机。
+// 配置 NFT_INPUT_CHAIN（如 inet filter input）后，在该链所在的表中创建普通链 alfred_frp，并在该链首部跳转过来，
+// 这里的 accept 即为该链的最终判定，默认拒绝的主机也能放行。
+type nftablesFirewall struct {
+	runner commandRunner
+	family string
+	table  string
+	chain  string
+	// hook 为空时 chain 是独立的基础链，否则是从主机 input 链 hook 跳转过来的普通链
+	hook string
+}
+
+// newNftablesFirewall 根据 NFT_INPUT_CHAIN（格式: 协议族 表 链）创建 nftables 防火墙，为空时使用独立的表
+func newNftablesFirewall(runner commandRunner, inputChain string) (*nftablesFirewall, error) {
+	if inputChain == "" {
+		return &nftablesFirewall{runner: runner, family: "inet", table: nftTable, chain: nftChain}, nil
+	}
+	fields := strings.Fields(inputChain)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("NFT_INPUT_CHAIN 格式应为 协议族 表 链，如 inet filter input: %s", inputChain)
+	}
+	return &nftablesFirewall{runner: runner, family: fields[0], table: fields[1], chain: nftTable, hook: fields[2]}, nil
+}
+
+func (f *nftablesFirewall) ensure() error {
+	if f.hook == "" {
+		if _, err := f.runner.Run("nft", "add", "table", f.family, f.table); err != nil {
+			return err
+		}
+		_, err := f.runner.Run("nft", "add", "chain", f.family, f.table, f.chain,
+			"{ type filter hook input priority -10 ; policy accept ; }")
+		return err
+	}
+
+	// 主机的表和 input 链由主机自身的防火墙配置维护，这里只创建普通链并在 input 链首部跳转
+	if _, err := f.runner.Run("nft", "add", "chain", f.family, f.table, f.chain); err != nil {
+		return err
+	}
+	output, err := f.runner.Run("nft", "-a", "list", "chain", f.family, f.table, f.hook)
+	if err != nil {
+		return fmt.Errorf("读取 %s %s %s 链失败: %w", f.family, f.table, f.hook, err)
+	}
+	if strings.Contains(output, "jump "+f.chain) {
+		return nil
+	}
+	log.Info("在 %s %s %s 链首部跳转到 %s", f.family, f.table, f.hook, f.chain)
+	_, err = f.runner.Run("nft", "insert", "rule", f.family, f.table, f.hook, "jump", f.chain)
+	return err
+}
+
+func (f *nftablesFirewall) list() ([]hostRule, error) {
+	output, err := f.runner.Run("nft", "-a", "list", "chain", f.family, f.table, f.chain)
+	if err != nil {
+		if strings.Contains(err.Error(), "No such file or directory") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseNftRules(output), nil
+}
+
+func (f *nftablesFirewall) add(rule hostRule) error {
+	args := []string{"nft", "add", "rule", f.family, f.table, f.chain}
+	family := "ip"
+	if strings.Contains(rule.CidrBlock, ":") {
+		family = "ip6"
+	}
+	args = append(args, family, "saddr", rule.CidrBlock)
+	if !strings.EqualFold(rule.Protocol, "ALL") {
+		args = append(args, strings.ToLower(rule.Protocol), "dport", rule.Port)
+	}
+	args = append(args, strings.ToLower(rule.Action), "comment", `"`+rule.Comment+`"`)
+	_, err := f.runner.Run(args...)
+	return err
+}
+
+func (f *nftablesFirewall) remove(rule hostRule) error {
+	if rule.handle == "" {
+		return fmt.Errorf("规则缺少 handle: %s", rule.Comment)
+	}
+	_, err := f.runner.Run("nft", "delete", "rule", f.family, f.table, f.chain, "handle", rule.handle)
+	return err
+}
+
+// parseNftRules 解析 nft -a list chain 的输出，只保留带 comment 和 handle 的规则
+func parseNftRules(output string) []hostRule {
+	var rules []hostRule
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		body, handle, found := strings.Cut(line, "# handle ")
+		if !found || !strings.Contains(body, "comment ") {
+			continue
+		}
+		body, comment, _ := strings.Cut(body, "comment ")
+		rule := hostRule{
+			Protocol: "ALL",
+			Port:     "ALL",
+			Comment:  strings.Trim(strings.TrimSpace(comment), `"`),
+			handle:   strings.TrimSpace(handle),
+		}
+
+		fields := strings.Fields(body)
+		for i, field := range fields {
+			switch {
+			case field == "saddr" && i > 0 && i+1 < len(fields):
+				rule.CidrBlock = fields[i+1]
+				if !strings.Contains(rule.CidrBlock, "/") {
+					if fields[i-1] == "ip6" {
+						rule.CidrBlock += "/128"
+					} else {
+						rule.CidrBlock += "/32"
+					}
+				}
+			case field == "dport" && i > 0 && i+1 < len(fields):
+				rule.Protocol = strings.ToUpper(fields[i-1])
+				rule.Port = fields[i+1]
+			case field == "accept":
+				rule.Action = "ACCEPT"
+			case field == "drop":
+				rule.Action = "DROP"
+			}
+		}
+		if rule.Action != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// iptablesFirewall 在 ALFRED-FRP 链中管理规则，并从 INPUT 链首跳转到该链；规则备注写入 comment 模块
+type iptablesFirewall struct {
+	runner commandRunner
+}
+
+func (f *iptablesFirewall) ensure() error {
+	if _, err := f.runner.Run("iptables", "-N", iptablesChain); err != nil && !strings.Contains(err.Error(), "already exists") {
+		return err
+	}
+	if _, err := f.runner.Run("iptables", "-C", "INPUT", "-j", iptablesChain); err == nil {
+		return nil
+	}
+	_, err := f.runner.Run("iptables", "-I", "INPUT", "1", "-j", iptablesChain)
+	return err
+}
+
+func (f *iptablesFirewall) list() ([]hostRule, error) {
+	output, err := f.runner.Run("iptables", "-S", iptablesChain)
+	if err != nil {
+		if strings.Contains(err.Error(), "No chain/target/match") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseIptablesRules(output), nil
+}
+
+func (f *iptablesFirewall) add(rule hostRule) error {
+	args, err := iptablesRuleSpec(rule)
+	if err != nil {
+		return err
+	}
+	_, err = f.runner.Run(append([]string{"iptables", "-A", iptablesChain}, args...)...)
+	return err
+}
+
+func (f *iptablesFirewall) remove(rule hostRule) error {
+	args, err := iptablesRuleSpec(rule)
+	if err != nil {
+		return err
+	}
+	_, err = f.runner.Run(append([]string{"iptables", "-D", iptablesChain}, args...)...)
+	return err
+}
+
+// iptablesRuleSpec 生成与 iptables -S 输出一致的规则参数，iptables 后端只支持 IPv4
+func iptablesRuleSpec(rule hostRule) ([]string, error) {
+	if strings.Contains(rule.CidrBlock, ":") {
+		return nil, fmt.Errorf("iptables 后端不支持 IPv6 地址: %s", rule.CidrBlock)
+	}
+	args := []string{"-s", rule.CidrBlock}
+	if !strings.EqualFold(rule.Protocol, "ALL") {
+		protocol := strings.ToLower(rule.Protocol)
+		args = append(args, "-p", protocol, "-m", protocol, "--dport", strings.ReplaceAll(rule.Port, "-", ":"))
+	}
+	return append(args, "-m", "comment", "--comment", rule.Comment, "-j", rule.Action), nil
+}
+
+// parseIptablesRules 解析 iptables -S 的输出，只保留带 comment 的规则
+func parseIptablesRules(output string) []hostRule {
+	var rules []hostRule
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		rule := hostRule{Protocol: "ALL", Port: "ALL"}
+		for i := 2; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "-s":
+				rule.CidrBlock = fields[i+1]
+			case "-p":
+				rule.Protocol = strings.ToUpper(fields[i+1])
+			case "--dport":
+				rule.Port = strings.ReplaceAll(fields[i+1], ":", "-")
+			case "--comment":
+				rule.Comment = strings.Trim(fields[i+1], `"`)
+			case "-j":
+				rule.Action = fields[i+1]
+			}
+		}
+		if rule.Comment != "" && (rule.Action == "ACCEPT" || rule.Action == "DROP") {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
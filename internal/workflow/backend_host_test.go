@@ -0,0 +1,181 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fakeRunner 模拟 frps 主机上的 nft/iptables，只维护 Workflow 专用链中的规则
+type fakeRunner struct {
+	commands []string
+	rules    []string // nft 为规则表达式，iptables 为 -S 输出中的规则行
+	handles  []int
+	next     int
+	jumped   bool
+}
+
+func (r *fakeRunner) Run(args ...string) (string, error) {
+	r.commands = append(r.commands, strings.Join(args, " "))
+	switch args[0] {
+	case "nft":
+		return r.nft(args[1:])
+	case "iptables":
+		return r.iptables(args[1:])
+	}
+	return "", fmt.Errorf("%s: command not found", args[0])
+}
+
+func (r *fakeRunner) nft(args []string) (string, error) {
+	switch {
+	case args[0] == "add" && args[1] == "rule":
+		r.next++
+		r.rules = append(r.rules, strings.Join(args[5:], " "))
+		r.handles = append(r.handles, r.next)
+	case args[0] == "delete" && args[1] == "rule":
+		for i, handle := range r.handles {
+			if fmt.Sprint(handle) == args[6] {
+				r.rules = append(r.rules[:i], r.rules[i+1:]...)
+				r.handles = append(r.handles[:i], r.handles[i+1:]...)
+				return "", nil
+			}
+		}
+		return "", errors.New("Error: Could not process rule: No such file or directory")
+	case args[0] == "insert" && args[1] == "rule":
+		r.jumped = true
+	case args[0] == "-a" && args[1] == "list" && args[4] != nftTable && args[5] != nftTable:
+		// 主机自身的 input 链
+		if r.jumped {
+			return "table inet filter {\n\tchain input { # handle 1\n\t\tjump alfred_frp # handle 9\n\t}\n}\n", nil
+		}
+		return "table inet filter {\n\tchain input { # handle 1\n\t}\n}\n", nil
+	case args[0] == "-a" && args[1] == "list":
+		var b strings.Builder
+		b.WriteString("table inet alfred_frp {\n\tchain input { # handle 1\n")
+		for i, rule := range r.rules {
+			fmt.Fprintf(&b, "\t\t%s # handle %d\n", rule, r.handles[i])
+		}
+		b.WriteString("\t}\n}\n")
+		return b.String(), nil
+	}
+	return "", nil
+}
+
+func (r *fakeRunner) iptables(args []string) (string, error) {
+	switch args[0] {
+	case "-N":
+		return "", nil
+	case "-C":
+		if !r.jumped {
+			return "", errors.New("iptables: Bad rule (does a matching rule exist in that chain?).")
+		}
+	case "-I":
+		r.jumped = true
+	case "-A":
+		r.rules = append(r.rules, strings.Join(args, " "))
+	case "-D":
+		line := "-A " + strings.Join(args[1:], " ")
+		for i, rule := range r.rules {
+			if rule == line {
+				r.rules = append(r.rules[:i], r.rules[i+1:]...)
+				return "", nil
+			}
+		}
+		return "", errors.New("iptables: Bad rule (does a matching rule exist in that chain?).")
+	case "-S":
+		return "-N ALFRED-FRP\n" + strings.Join(r.rules, "\n") + "\n", nil
+	}
+	return "", nil
+}
+
+// fakeNftablesBackend 与 fakeIptablesBackend 供一致性测试使用，返回后端及 Workflow 专用链中的规则数
+func fakeNftablesBackend(t *testing.T) (Backend, func() int) {
+	runner := &fakeRunner{}
+	firewall, err := newNftablesFirewall(runner, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newHostBackend(firewall, "203.0.113.1"), func() int { return len(runner.rules) }
+}
+
+func fakeIptablesBackend(t *testing.T) (Backend, func() int) {
+	runner := &fakeRunner{}
+	return newHostBackend(&iptablesFirewall{runner: runner}, "203.0.113.1"), func() int { return len(runner.rules) }
+}
+
+func TestNftablesInputChain(t *testing.T) {
+	runner := &fakeRunner{}
+	firewall, err := newNftablesFirewall(runner, "inet filter input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := newHostBackend(firewall, "203.0.113.1")
+	for _, ip := range []string{"198.51.100.7", "198.51.100.8"} {
+		if err := createSecurityGroupRule(backend, "TCP", "8080", ip, "AlfredFRP_web_local8080"); err != nil {
+			t.Fatalf("open failed: %v", err)
+		}
+	}
+	jumps := 0
+	for _, command := range runner.commands {
+		if command == "nft insert rule inet filter input jump alfred_frp" {
+			jumps++
+		}
+		if strings.HasPrefix(command, "nft add table") || strings.Contains(command, "hook input") {
+			t.Errorf("should not create a separate base chain: %s", command)
+		}
+	}
+	if jumps != 1 {
+		t.Errorf("jump inserted %d times, want 1: %v", jumps, runner.commands)
+	}
+	rules, _ := getAllSecurityGroupRules(backend)
+	if got := rules["web"]; got.CidrBlock != "198.51.100.8/32" || len(runner.rules) != 1 {
+		t.Errorf("web rule = %+v, chain = %v", got, runner.rules)
+	}
+
+	for _, bad := range []string{"inet filter", "inet filter input extra"} {
+		if _, err := newNftablesFirewall(runner, bad); err == nil {
+			t.Errorf("newNftablesFirewall(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseNftRules(t *testing.T) {
+	output := `table inet alfred_frp {
+	chain input { # handle 1
+		type filter hook input priority -10; policy accept;
+		ip saddr 198.51.100.7 tcp dport 8080 accept comment "AlfredFRP_web_local8080" # handle 4
+		ip6 saddr 2001:db8::/64 udp dport 7000-7010 drop comment "AlfredFRP_game_local7000" # handle 5
+		ip saddr 10.0.0.0/8 accept # handle 6
+	}
+}`
+	rules := parseNftRules(output)
+	want := []hostRule{
+		{Protocol: "TCP", Port: "8080", CidrBlock: "198.51.100.7/32", Action: "ACCEPT", Comment: "AlfredFRP_web_local8080", handle: "4"},
+		{Protocol: "UDP", Port: "7000-7010", CidrBlock: "2001:db8::/64", Action: "DROP", Comment: "AlfredFRP_game_local7000", handle: "5"},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("parsed %d rules, want %d: %+v", len(rules), len(want), rules)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"iptables":                  "iptables",
+		"198.51.100.7/32":           "198.51.100.7/32",
+		`"AlfredFRP_web_local8080"`: `'"AlfredFRP_web_local8080"'`,
+		"{ type filter ; }":         "'{ type filter ; }'",
+		"it's":                      `'it'\''s'`,
+		"":                          "''",
+	}
+	for in, want := range cases {
+		if got := shellQuote(in); got != want {
+			t.Errorf("shellQuote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+// LighthouseFirewallRule 轻量应用服务器防火墙规则
+type LighthouseFirewallRule struct {
+	Protocol                string `json:"Protocol"`
+	Port                    string `json:"Port,omitempty"`
+	CidrBlock               string `json:"CidrBlock,omitempty"`
+	Action                  string `json:"Action,omitempty"`
+	FirewallRuleDescription string `json:"FirewallRuleDescription,omitempty"`
+}
+
+// lighthouseAPI 轻量应用服务器防火墙接口，测试时可替换为假实现。
+// version 为防火墙版本号，写入时传入读取到的版本号用于冲突检测，传 0 表示不检测。
+type lighthouseAPI interface {
+	DescribeFirewallRules(instanceId string) (rules []LighthouseFirewallRule, version uint64, err error)
+	CreateFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error
+	DeleteFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error
+}
+
+// lighthouseBackend 基于轻量应用服务器防火墙的后端，每个实例视为一个规则组
+type lighthouseBackend struct {
+	api       lighthouseAPI
+	instances []string
+}
+
+func newLighthouseBackend(api lighthouseAPI, instances []string) *lighthouseBackend {
+	return &lighthouseBackend{api: api, instances: instances}
+}
+
+func (b *lighthouseBackend) Groups() []string {
+	return b.instances
+}
+
+func (b *lighthouseBackend) ListGroupRules(instanceId string) (map[string]FetchedRuleInfo, error) {
+	log.Info("开始查询轻量应用服务器防火墙规则, 实例ID: %s", instanceId)
+	rules, _, err := b.api.DescribeFirewallRules(instanceId)
+	if err != nil {
+		return nil, err
+	}
+
+	allRules := make(map[string]FetchedRuleInfo)
+	for i, rule := range rules {
+		if !strings.HasPrefix(rule.FirewallRuleDescription, "AlfredFRP_") {
+			continue
+		}
+		proxyName := ruleKey(rule.FirewallRuleDescription)
+		allRules[proxyName] = FetchedRuleInfo{
+			PolicyDescription: rule.FirewallRuleDescription,
+			Protocol:          strings.ToUpper(rule.Protocol),
+			Port:              rule.Port,
+			CidrBlock:         rule.CidrBlock,
+			PolicyIndex:       int64(i),
+			Action:            rule.Action,
+			LocalPort:         extractLocalPort(rule.FirewallRuleDescription),
+			SecurityGroupId:   instanceId,
+		}
+	}
+	log.Info("解析完成，找到 %d 个符合条件的规则", len(allRules))
+	return allRules, nil
+}
+
+func (b *lighthouseBackend) OpenInGroup(instanceId, serviceName, protocol, port, cidrBlock, description string) error {
+	return b.update(instanceId, func(rules []LighthouseFirewallRule, version uint64) error {
+		// 寻找同名服务、相同协议和端口的旧规则（无论 ACCEPT 还是 DROP）
+		var staleRules []LighthouseFirewallRule
+		for _, rule := range rules {
+			if serviceName != "" && replacesRule(rule.FirewallRuleDescription, serviceName) &&
+				strings.EqualFold(rule.Protocol, protocol) && rule.Port == port {
+				log.Info("找到匹配的规则需要删除: %s, 动作: %s, CIDR: %s", rule.FirewallRuleDescription, rule.Action, rule.CidrBlock)
+				staleRules = append(staleRules, rule)
+			}
+		}
+
+		if len(staleRules) > 0 {
+			if err := b.api.DeleteFirewallRules(instanceId, version, staleRules); err != nil {
+				if isLighthouseVersionMismatch(err) {
+					return err
+				}
+				log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
+			} else {
+				// 删除后重新读取防火墙版本号，不假设每次写入只加 1
+				_, current, err := b.api.DescribeFirewallRules(instanceId)
+				if err != nil {
+					return err
+				}
+				version = current
+			}
+		}
+
+		return b.api.CreateFirewallRules(instanceId, version, []LighthouseFirewallRule{{
+			Protocol:                protocol,
+			Port:                    port,
+			CidrBlock:               cidrBlock,
+			Action:                  "ACCEPT",
+			FirewallRuleDescription: description,
+		}})
+	})
+}
+
+func (b *lighthouseBackend) CloseInGroup(instanceId, protocol, port, cidrBlock, description string) error {
+	// 1. 创建对应规则的DROP版本
+	err := b.update(instanceId, func(rules []LighthouseFirewallRule, version uint64) error {
+		if findLighthouseRule(rules, protocol, port, cidrBlock, "ACCEPT", description) == nil {
+			return errRuleNotInGroup
+		}
+		return b.api.CreateFirewallRules(instanceId, version, []LighthouseFirewallRule{{
+			Protocol:                protocol,
+			Port:                    port,
+			CidrBlock:               cidrBlock,
+			Action:                  "DROP",
+			FirewallRuleDescription: description,
+		}})
+	})
+	if err != nil {
+		return err
+	}
+
+	// 2. 按规则内容删除原有的ACCEPT规则
+	return b.update(instanceId, func(rules []LighthouseFirewallRule, version uint64) error {
+		target := findLighthouseRule(rules, protocol, port, cidrBlock, "ACCEPT", description)
+		if target == nil {
+			return fmt.Errorf("未找到待删除的原规则: %s, 协议: %s, 端口: %s, IP: %s", description, protocol, port, cidrBlock)
+		}
+		return b.api.DeleteFirewallRules(instanceId, version, []LighthouseFirewallRule{*target})
+	})
+}
+
+// update 以乐观锁方式修改防火墙规则，防火墙版本号冲突时重新读取后重试
+func (b *lighthouseBackend) update(instanceId string, mutate func(rules []LighthouseFirewallRule, version uint64) error) error {
+	for attempt := 1; ; attempt++ {
+		rules, version, err := b.api.DescribeFirewallRules(instanceId)
+		if err != nil {
+			return err
+		}
+		log.Debug("读取实例 %s 防火墙规则，版本号: %d，第 %d 次尝试", instanceId, version, attempt)
+
+		err = mutate(rules, version)
+		if err == nil || !isLighthouseVersionMismatch(err) || attempt >= maxVersionRetries {
+			return err
+		}
+		log.Warn("实例 %s 的防火墙版本号 %d 已过期，重新读取后重试: %v", instanceId, version, err)
+	}
+}
+
+// findLighthouseRule 按 协议+端口+CIDR+动作+备注 查找防火墙规则
+func findLighthouseRule(rules []LighthouseFirewallRule, protocol, port, cidrBlock, action, description string) *LighthouseFirewallRule {
+	for i := range rules {
+		rule := &rules[i]
+		if strings.EqualFold(rule.Protocol, protocol) && rule.Port == port && rule.CidrBlock == cidrBlock &&
+			rule.Action == action && rule.FirewallRuleDescription == description {
+			return rule
+		}
+	}
+	return nil
+}
+
+// isLighthouseVersionMismatch 判断是否为防火墙版本号冲突错误
+func isLighthouseVersionMismatch(err error) bool {
+	var sdkErr *tcErrors.TencentCloudSDKError
+	return errors.As(err, &sdkErr) && strings.HasSuffix(sdkErr.GetCode(), "FirewallVersionMismatch")
+}
+
+// lighthouseClient 通过通用请求调用轻量应用服务器 API
+type lighthouseClient struct {
+	client *common.Client
+}
+
+func newLighthouseClient(cfg *config.Config, cred *config.Credential) *lighthouseClient {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "lighthouse.tencentcloudapi.com"
+	return &lighthouseClient{client: common.NewCommonClient(tencentCredential(cred), cfg.Region, cpf)}
+}
+
+// lighthouseDescribeLimit DescribeFirewallRules 单页最大条数
+const lighthouseDescribeLimit = 100
+
+func (c *lighthouseClient) DescribeFirewallRules(instanceId string) ([]LighthouseFirewallRule, uint64, error) {
+	var (
+		rules   []LighthouseFirewallRule
+		version uint64
+	)
+	for offset := 0; ; offset += lighthouseDescribeLimit {
+		var result struct {
+			TotalCount      int                      `json:"TotalCount"`
+			FirewallRuleSet []LighthouseFirewallRule `json:"FirewallRuleSet"`
+			FirewallVersion uint64                   `json:"FirewallVersion"`
+		}
+		err := c.call("DescribeFirewallRules", map[string]interface{}{
+			"InstanceId": instanceId,
+			"Offset":     offset,
+			"Limit":      lighthouseDescribeLimit,
+		}, &result)
+		if err != nil {
+			return nil, 0, err
+		}
+		rules = append(rules, result.FirewallRuleSet...)
+		version = result.FirewallVersion
+		if len(result.FirewallRuleSet) == 0 || len(rules) >= result.TotalCount {
+			return rules, version, nil
+		}
+	}
+}
+
+func (c *lighthouseClient) CreateFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error {
+	return c.call("CreateFirewallRules", firewallRulesParams(instanceId, version, rules), nil)
+}
+
+func (c *lighthouseClient) DeleteFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error {
+	return c.call("DeleteFirewallRules", firewallRulesParams(instanceId, version, rules), nil)
+}
+
+func firewallRulesParams(instanceId string, version uint64, rules []LighthouseFirewallRule) map[string]interface{} {
+	params := map[string]interface{}{
+		"InstanceId":    instanceId,
+		"FirewallRules": rules,
+	}
+	if version > 0 {
+		params["FirewallVersion"] = version
+	}
+	return params
+}
+
+// call 调用 Lighthouse API，并将响应中 Response 字段解析到 result
+func (c *lighthouseClient) call(action string, params map[string]interface{}, result interface{}) error {
+	request := tchttp.NewCommonRequest("lighthouse", "2020-03-24", action)
+	if err := request.SetActionParameters(params); err != nil {
+		return err
+	}
+	response := tchttp.NewCommonResponse()
+	if err := c.client.Send(request, response); err != nil {
+		return err
+	}
+	log.Info("调用 Lighthouse %s 成功", action)
+	if result == nil {
+		return nil
+	}
+	var body struct {
+		Response json.RawMessage `json:"Response"`
+	}
+	if err := json.Unmarshal(response.GetBody(), &body); err != nil {
+		return fmt.Errorf("解析 %s 响应失败: %w", action, err)
+	}
+	return json.Unmarshal(body.Response, result)
+}
@@ -0,0 +1,116 @@
+package workflow
+
+import (
+	"testing"
+
+	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// fakeVersionStep 模拟的防火墙每次写入后版本号的增量，大于 1 以发现假设版本号只加 1 的实现
+const fakeVersionStep = 3
+
+// fakeLighthouseAPI 内存中的轻量应用服务器防火墙，按版本号模拟并发冲突
+type fakeLighthouseAPI struct {
+	rules    map[string][]LighthouseFirewallRule
+	versions map[string]uint64
+	// conflicts 为大于 0 时，下一次写入前模拟其他人修改了防火墙
+	conflicts int
+	// mismatches 记录因版本号不一致被拒绝的写入次数
+	mismatches int
+}
+
+func newFakeLighthouseAPI() *fakeLighthouseAPI {
+	return &fakeLighthouseAPI{
+		rules:    map[string][]LighthouseFirewallRule{},
+		versions: map[string]uint64{},
+	}
+}
+
+func (f *fakeLighthouseAPI) DescribeFirewallRules(instanceId string) ([]LighthouseFirewallRule, uint64, error) {
+	rules := append([]LighthouseFirewallRule(nil), f.rules[instanceId]...)
+	return rules, f.versions[instanceId] + 1, nil
+}
+
+func (f *fakeLighthouseAPI) checkVersion(instanceId string, version uint64) error {
+	if f.conflicts > 0 {
+		f.conflicts--
+		f.versions[instanceId] += fakeVersionStep
+	}
+	if version != 0 && version != f.versions[instanceId]+1 {
+		f.mismatches++
+		return tcErrors.NewTencentCloudSDKError("UnsupportedOperation.FirewallVersionMismatch", "version mismatch", "req-fake")
+	}
+	return nil
+}
+
+func (f *fakeLighthouseAPI) CreateFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error {
+	if err := f.checkVersion(instanceId, version); err != nil {
+		return err
+	}
+	f.rules[instanceId] = append(f.rules[instanceId], rules...)
+	f.versions[instanceId] += fakeVersionStep
+	return nil
+}
+
+func (f *fakeLighthouseAPI) DeleteFirewallRules(instanceId string, version uint64, rules []LighthouseFirewallRule) error {
+	if err := f.checkVersion(instanceId, version); err != nil {
+		return err
+	}
+	var kept []LighthouseFirewallRule
+	for _, existing := range f.rules[instanceId] {
+		deleted := false
+		for _, rule := range rules {
+			if existing == rule {
+				deleted = true
+				break
+			}
+		}
+		if !deleted {
+			kept = append(kept, existing)
+		}
+	}
+	f.rules[instanceId] = kept
+	f.versions[instanceId] += fakeVersionStep
+	return nil
+}
+
+// fakeLighthouseBackend 返回预置一条非 Workflow 规则的 Lighthouse 后端及防火墙中的规则数，供一致性测试使用
+func fakeLighthouseBackend(t *testing.T) (Backend, func() int) {
+	api := newFakeLighthouseAPI()
+	api.rules["lhins-1"] = []LighthouseFirewallRule{
+		{Protocol: "TCP", Port: "22", CidrBlock: "0.0.0.0/0", Action: "ACCEPT", FirewallRuleDescription: "ssh"},
+	}
+	return newLighthouseBackend(api, []string{"lhins-1"}), func() int { return len(api.rules["lhins-1"]) }
+}
+
+func TestLighthouseBackendRetriesOnVersionConflict(t *testing.T) {
+	api := newFakeLighthouseAPI()
+	api.conflicts = 1
+	backend := newLighthouseBackend(api, []string{"lhins-1"})
+
+	if err := createSecurityGroupRule(backend, "TCP", "8080", "198.51.100.7", "AlfredFRP_web_local8080"); err != nil {
+		t.Fatalf("open should succeed after retry: %v", err)
+	}
+	if len(api.rules["lhins-1"]) != 1 {
+		t.Errorf("firewall has %d rules, want 1", len(api.rules["lhins-1"]))
+	}
+
+	api.conflicts = maxVersionRetries
+	if err := createSecurityGroupRule(backend, "TCP", "9090", "198.51.100.7", "AlfredFRP_api_local9090"); err == nil {
+		t.Error("open should fail when every attempt conflicts")
+	}
+}
+
+func TestLighthouseBackendReplaceUsesFreshVersion(t *testing.T) {
+	api := newFakeLighthouseAPI()
+	backend := newLighthouseBackend(api, []string{"lhins-1"})
+	for _, ip := range []string{"198.51.100.7", "198.51.100.8"} {
+		if err := createSecurityGroupRule(backend, "TCP", "8080", ip, "AlfredFRP_web_local8080"); err != nil {
+			t.Fatalf("open %s failed: %v", ip, err)
+		}
+	}
+	// 删除旧规则后应使用重新读取的版本号创建新规则，不应触发版本冲突
+	if api.mismatches != 0 {
+		t.Errorf("replacing a rule hit %d version mismatches", api.mismatches)
+	}
+}
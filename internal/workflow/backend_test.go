@@ -0,0 +1,74 @@
+package workflow
+
+import "testing"
+
+// backendCase 一致性测试中的一个后端，setup 返回预置规则的后端及规则组中的规则总数
+type backendCase struct {
+	name  string
+	group string
+	setup func(t *testing.T) (Backend, func() int)
+	// revokeOnClose 为 true 时关闭直接撤销规则（不支持拒绝规则的后端），否则留下 DROP 规则
+	revokeOnClose bool
+}
+
+var backendCases = []backendCase{
+	{name: "lighthouse", group: "lhins-1", setup: fakeLighthouseBackend},
+	{name: "aws", group: "sg-1", setup: fakeAWSBackend, revokeOnClose: true},
+	{name: "aliyun", group: "sg-1", setup: fakeAliyunBackend},
+	{name: "nftables", group: "203.0.113.1", setup: fakeNftablesBackend},
+	{name: "iptables", group: "203.0.113.1", setup: fakeIptablesBackend},
+}
+
+// TestBackendConformance 各后端的 开放 -> 重新开放 -> 列出 -> 关闭 行为应一致
+func TestBackendConformance(t *testing.T) {
+	for _, tt := range backendCases {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, count := tt.setup(t)
+			baseline := count()
+			description := "AlfredFRP_web_local8080"
+
+			if err := createSecurityGroupRule(backend, "TCP", "8080", "198.51.100.7", description); err != nil {
+				t.Fatalf("open failed: %v", err)
+			}
+			// 再次开放时应替换旧规则，而不是追加
+			if err := createSecurityGroupRule(backend, "TCP", "8080", "198.51.100.8", description); err != nil {
+				t.Fatalf("reopen failed: %v", err)
+			}
+			rules, err := getAllSecurityGroupRules(backend)
+			if err != nil {
+				t.Fatalf("list failed: %v", err)
+			}
+			got := rules["web"]
+			if got.Action != "ACCEPT" || got.CidrBlock != "198.51.100.8/32" || got.Protocol != "TCP" || got.Port != "8080" ||
+				got.LocalPort != "8080" || got.SecurityGroupId != tt.group {
+				t.Errorf("web rule = %+v, want ACCEPT TCP 8080 for 198.51.100.8/32 in %s", got, tt.group)
+			}
+			if len(rules) != 1 {
+				t.Errorf("rules = %+v, rules without the AlfredFRP_ tag must not be listed", rules)
+			}
+			if n := count(); n != baseline+1 {
+				t.Errorf("group has %d rules after reopen, want %d", n, baseline+1)
+			}
+
+			if err := createDenyRuleAndDeleteOriginal(backend, "TCP", "8080", "198.51.100.8/32", "web", "8080"); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+			rules, _ = getAllSecurityGroupRules(backend)
+			want := baseline + 1
+			if tt.revokeOnClose {
+				want = baseline
+				if _, ok := rules["web"]; ok {
+					t.Errorf("web rule should be revoked after close: %+v", rules["web"])
+				}
+			} else if got := rules["web"]; got.Action != "DROP" || got.SecurityGroupId != tt.group {
+				t.Errorf("web rule after close = %+v, want DROP in %s", got, tt.group)
+			}
+			if n := count(); n != want {
+				t.Errorf("group has %d rules after close, want %d; unrelated rules must be kept", n, want)
+			}
+			if err := createDenyRuleAndDeleteOriginal(backend, "TCP", "8080", "198.51.100.8/32", "web", "8080"); err == nil {
+				t.Error("closing a rule that is no longer open should fail")
+			}
+		})
+	}
+}
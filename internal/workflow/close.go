@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -9,13 +10,12 @@ import (
 
 	aw "github.com/deanishe/awgo"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
 )
 
-// CloseCommand 显示可关闭的端口规则列表
-func CloseCommand(wf *aw.Workflow) {
+// CloseCommand 显示可关闭的端口规则列表，默认只列出本人的规则，带 --all 时列出所有人的规则
+func CloseCommand(wf *aw.Workflow, args []string) {
+	_, all := SplitAllFlag(args)
 	cfg, err := config.Load()
 	if err != nil {
 		log.Error("配置文件读取失败: %v", err)
@@ -23,12 +23,6 @@ func CloseCommand(wf *aw.Workflow) {
 		return
 	}
 
-	if cfg.SecurityGroupId == "" {
-		log.Error("安全组 ID 未配置")
-		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置").Valid(false).Icon(aw.IconWarning)
-		wf.SendFeedback()
-		return
-	}
 	if cfg.Region == "" {
 		log.Error("腾讯云区域未配置")
 		wf.NewItem("腾讯云区域未配置").Subtitle("请使用 'frp config set_region' 设置").Valid(false).Icon(aw.IconWarning)
@@ -36,39 +30,34 @@ func CloseCommand(wf *aw.Workflow) {
 		return
 	}
 
-	secretID, err := config.GetSecretId()
-	if err != nil {
-		log.Error("获取 SecretId 失败: %v", err)
-		wf.NewItem("获取 SecretId 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	cred, ok := loadCredentials(wf, cfg)
+	if !ok {
 		return
 	}
-	secretKey, err := config.GetSecretKey()
-	if err != nil {
-		log.Error("获取 SecretKey 失败: %v", err)
-		wf.NewItem("获取 SecretKey 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	if !checkRegion(wf, cfg, cred) {
 		return
 	}
-	if secretID == "" || secretKey == "" {
-		log.Error("SecretId 或 SecretKey 未配置")
-		wf.NewItem("SecretId 或 SecretKey 未配置").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconWarning)
+	backend, err := newBackend(wf, cfg, cred)
+	if err != nil {
+		log.Error("安全组 ID 未配置: %v", err)
+		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置或配置 INSTANCE_ID: " + err.Error()).Valid(false).Icon(aw.IconWarning)
 		wf.SendFeedback()
 		return
 	}
 
 	// openedRules、allRules 都以 proxy name 作为 key
-	allRules, err := getAllSecurityGroupRules(cfg, secretID, secretKey)
+	allRules, err := getAllSecurityGroupRules(backend)
 	if err != nil {
 		log.Error("获取所有安全组规则失败: %v", err)
 		wf.NewItem("获取所有安全组规则失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
+	owner := ruleOwner(cfg)
 	openedRules := make(map[string]FetchedRuleInfo)
-	for proxyName, v := range allRules {
-		if v.Action == "ACCEPT" {
-			openedRules[proxyName] = v
+	for key, v := range allRules {
+		if v.Action == "ACCEPT" && (all || extractOwner(v.PolicyDescription) == owner) {
+			openedRules[key] = v
 		}
 	}
 
@@ -77,13 +66,23 @@ func CloseCommand(wf *aw.Workflow) {
 
 	if len(openedRules) == 0 {
 		log.Info("未找到由 Workflow 创建的规则")
-		wf.NewItem("未找到任何已开放的规则").Subtitle("没有可关闭的规则").Valid(false).Icon(aw.IconInfo)
+		subtitle := "没有可关闭的规则"
+		if !all {
+			subtitle = "没有属主为 " + owner + " 的规则，使用 close --all 查看所有人的规则"
+		}
+		wf.NewItem("未找到任何已开放的规则").Subtitle(subtitle).Valid(false).Icon(aw.IconInfo)
 		wf.SendFeedback()
 		return
 	}
 
 	// 显示可以关闭的规则列表
 	hasValidRules := false
+	// 来源在地址簿中时显示名称
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		log.Warn("%v", err)
+	}
+
 	for proxyName, rule := range openedRules {
 		protocol := rule.Protocol
 		port := rule.Port
@@ -94,12 +93,28 @@ func CloseCommand(wf *aw.Workflow) {
 		// 检查这个规则在云端是否已经是拒绝状态
 		icon := IconOpen // 默认已开放
 		hasValidRules = true
-		title := fmt.Sprintf("%s [%s]", proxyName, protocol)
-		subtitle := fmt.Sprintf("远程端口:%s  本地端口:%s | IP: %s", port, localPort, rule.CidrBlock)
+		// 开放给其他来源的授权各占一行，标题中带上授权来源
+		title := fmt.Sprintf("%s [%s]", ruleTitle(proxyName), protocol)
+		subtitle := fmt.Sprintf("远程端口:%s  本地端口:%s | IP: %s", port, localPort, book.label(rule))
+		target := fmt.Sprintf("%s|%s|%s|%s|%s", ruleKey(rule.PolicyDescription), protocol, port, rule.CidrBlock, localPort)
+		arg := "close " + target
+		if extractGrant(rule.PolicyDescription) != "" {
+			icon = IconGrant
+		}
+		if all {
+			arg = "close --all " + target
+			if keyOwner := extractOwner(rule.PolicyDescription); keyOwner != owner {
+				icon = IconOthers
+				if keyOwner == "" {
+					keyOwner = "未标记"
+				}
+				title = fmt.Sprintf("%s@%s [%s]", ruleTitle(proxyName), keyOwner, protocol)
+			}
+		}
 
 		item := wf.NewItem(icon+" "+title).
 			Subtitle(subtitle).
-			Arg(fmt.Sprintf("close %s|%s|%s|%s|%d|%s", proxyName, protocol, port, rule.CidrBlock, rule.PolicyIndex, localPort)).
+			Arg(arg).
 			Valid(true).
 			Var("action", "close")
 
@@ -122,20 +137,21 @@ func CloseCommand(wf *aw.Workflow) {
 	wf.SendFeedback()
 }
 
-// ClosePort 关闭指定的端口
+// ClosePort 关闭指定的端口，服务名为 服务名@属主，关闭其他人的规则需带 --all
 func ClosePort(wf *aw.Workflow, args []string) {
-	// 检查参数格式，需要接收服务名称|协议|远程端口|CIDR|PolicyIndex
+	args, all := SplitAllFlag(args)
+	// 检查参数格式，需要接收服务名称|协议|远程端口|CIDR|本地端口
 	if len(args) < 1 {
-		log.Error("缺少参数，期望格式: 服务名|协议|远程端口|CIDR|PolicyIndex")
-		wf.NewItem("参数错误").Subtitle("缺少参数，期望格式: 服务名|协议|远程端口|CIDR|PolicyIndex").Icon(aw.IconError)
+		log.Error("缺少参数，期望格式: 服务名|协议|远程端口|CIDR|本地端口")
+		wf.NewItem("参数错误").Subtitle("缺少参数，期望格式: 服务名|协议|远程端口|CIDR|本地端口").Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
 
 	parts := strings.Split(args[0], "|")
 	if len(parts) < 5 {
-		log.Error("参数格式错误，期望格式: 服务名|协议|远程端口|CIDR|PolicyIndex，实际: %s", args[0])
-		wf.NewItem("参数格式错误").Subtitle("期望格式: 服务名|协议|远程端口|CIDR|PolicyIndex").Icon(aw.IconError)
+		log.Error("参数格式错误，期望格式: 服务名|协议|远程端口|CIDR|本地端口，实际: %s", args[0])
+		wf.NewItem("参数格式错误").Subtitle("期望格式: 服务名|协议|远程端口|CIDR|本地端口").Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
@@ -144,16 +160,25 @@ func ClosePort(wf *aw.Workflow, args []string) {
 	protocol := parts[1]
 	remotePort := parts[2]
 	cidrBlock := parts[3]
-	policyIndexStr := parts[4]
+	localPort := parts[4]
 
-	// 获取本地端口，如果参数中提供则直接使用
-	localPort := "0"
+	// 兼容旧格式 服务名|协议|远程端口|CIDR|PolicyIndex|本地端口，PolicyIndex 会随规则变更而漂移，直接忽略
 	if len(parts) >= 6 {
+		log.Warn("忽略参数中的 PolicyIndex: %s，改为按规则内容删除", parts[4])
 		localPort = parts[5]
 	}
 
-	log.Info("关闭端口，服务名: %s, 协议: %s, 远程端口: %s, CIDR: %s, PolicyIndex: %s, 本地端口: %s",
-		serviceName, protocol, remotePort, cidrBlock, policyIndexStr, localPort)
+	// 规则备注由服务名和本地端口还原，按备注精确匹配，因此只会删除 Workflow 创建的 AlfredFRP_ 规则；
+	// 无法还原出同一规则标识的参数直接拒绝
+	if err := checkCloseTarget(serviceName, localPort); err != nil {
+		log.Error("参数格式错误: %v", err)
+		wf.NewItem("参数格式错误").Subtitle(err.Error()).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
+
+	log.Info("关闭端口，服务名: %s, 协议: %s, 远程端口: %s, CIDR: %s, 本地端口: %s",
+		serviceName, protocol, remotePort, cidrBlock, localPort)
 
 	cfg, err := config.Load()
 	if err != nil {
@@ -162,11 +187,26 @@ func ClosePort(wf *aw.Workflow, args []string) {
 		return
 	}
 
-	secretID, _ := config.GetSecretId()
-	secretKey, _ := config.GetSecretKey()
+	if _, owner := splitRuleKey(serviceName); owner != ruleOwner(cfg) && !all {
+		if owner == "" {
+			owner = "未标记属主的旧规则"
+		}
+		log.Error("规则 %s 不属于 %s，拒绝关闭", serviceName, ruleOwner(cfg))
+		wf.NewItem("不能关闭其他人的规则").Subtitle(fmt.Sprintf("规则属于 %s，如确需关闭请使用 close --all", owner)).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
+
+	backend, err := newBackend(wf, cfg, currentCredential(wf, cfg))
+	if err != nil {
+		log.Error("获取安全组失败: %v", err)
+		wf.NewItem("获取安全组失败").Subtitle(err.Error()).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
 
 	// 使用"创建拒绝规则-删除原规则"的方式关闭端口
-	err = createDenyRuleAndDeleteOriginal(cfg, secretID, secretKey, protocol, remotePort, cidrBlock, serviceName, policyIndexStr, localPort)
+	err = createDenyRuleAndDeleteOriginal(backend, protocol, remotePort, cidrBlock, serviceName, localPort)
 	if err != nil {
 		log.Error("关闭端口失败: %v", err)
 		wf.NewItem("关闭端口失败").Subtitle(err.Error()).Icon(aw.IconError)
@@ -175,89 +215,117 @@ func ClosePort(wf *aw.Workflow, args []string) {
 	}
 
 	// 操作成功
-	wf.NewItem(fmt.Sprintf("已成功关闭服务: %s", serviceName)).
+	wf.NewItem(fmt.Sprintf("已成功关闭服务: %s", ruleTitle(serviceName))).
 		Subtitle(fmt.Sprintf("协议: %s, 远程端口: %s, IP: %s", protocol, remotePort, cidrBlock)).
 		Icon(&aw.Icon{Value: "/System/Library/CoreServices/CoreTypes.bundle/Contents/Resources/ToolbarDeleteIcon.icns"})
 	wf.SendFeedback()
 }
 
-// createDenyRuleAndDeleteOriginal 先创建拒绝规则，再删除原规则
-func createDenyRuleAndDeleteOriginal(cfg *config.Config, secretID, secretKey, protocol, port, cidrBlock, serviceName, policyIndexStr, localPort string) error {
-	log.Info("开始创建拒绝规则并删除原规则, 协议: %s, 端口: %s, IP: %s", protocol, port, cidrBlock)
-
-	// 创建腾讯云VPC客户端
-	cred := common.NewCredential(secretID, secretKey)
-	cpf := profile.NewClientProfile()
-	cpf.HttpProfile.Endpoint = "vpc.tencentcloudapi.com"
-	client, err := vpc.NewClient(cred, cfg.Region, cpf)
-	if err != nil {
-		return fmt.Errorf("创建腾讯云VPC客户端失败: %w", err)
+// checkCloseTarget 检查参数中的规则标识和本地端口能否还原为同一条 AlfredFRP_ 规则备注
+func checkCloseTarget(key, localPort string) error {
+	description := keyDescription(key, localPort)
+	if key == "" || ruleKey(description) != key || extractLocalPort(description) != localPort {
+		return fmt.Errorf("无法由服务名 %q 和本地端口 %q 还原规则备注", key, localPort)
 	}
+	return nil
+}
 
-	// 1. 创建对应规则的DROP版本
-	description := fmt.Sprintf("AlfredFRP_%s_local%s", serviceName, localPort)
-	log.Info("正在创建拒绝规则，保持原备注格式: %s", description)
-
-	createRequest := vpc.NewCreateSecurityGroupPoliciesRequest()
-	createRequest.SecurityGroupId = common.StringPtr(cfg.SecurityGroupId)
-
-	// 创建拒绝规则
-	createPolicySet := &vpc.SecurityGroupPolicySet{
-		Ingress: []*vpc.SecurityGroupPolicy{
-			{
-				Protocol:          common.StringPtr(protocol),
-				Port:              common.StringPtr(port),
-				CidrBlock:         common.StringPtr(cidrBlock),
-				Action:            common.StringPtr("DROP"),
-				PolicyDescription: common.StringPtr(description),
-			},
-		},
-	}
-	createRequest.SecurityGroupPolicySet = createPolicySet
+// createDenyRuleAndDeleteOriginal 先创建拒绝规则，再删除原规则，key 为 服务名@属主#授权来源（旧规则为服务名）
+func createDenyRuleAndDeleteOriginal(backend Backend, protocol, port, cidrBlock, key, localPort string) error {
+	log.Info("开始创建拒绝规则并删除原规则, 协议: %s, 端口: %s, IP: %s", protocol, port, cidrBlock)
 
-	// 发送API请求创建拒绝规则
-	createResponse, err := client.CreateSecurityGroupPolicies(createRequest)
-	if err != nil {
-		if sdkErr, ok := err.(*tcErrors.TencentCloudSDKError); ok {
-			return fmt.Errorf("腾讯云API错误(创建拒绝规则): Code=%s, Message=%s, RequestId=%s", sdkErr.GetCode(), sdkErr.GetMessage(), sdkErr.GetRequestId())
+	description := keyDescription(key, localPort)
+
+	// 在每个安全组中分别关闭，未包含该规则的安全组直接跳过
+	closed := 0
+	var failures []string
+	for _, group := range backend.Groups() {
+		err := backend.CloseInGroup(group, protocol, port, cidrBlock, description)
+		if errors.Is(err, errRuleNotInGroup) {
+			log.Info("安全组 %s 中没有规则 %s，跳过", group, description)
+			continue
 		}
-		return fmt.Errorf("调用腾讯云API创建拒绝规则失败: %w", err)
+		if err != nil {
+			log.Error("安全组 %s 关闭规则失败: %v", group, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", group, err))
+			continue
+		}
+		closed++
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
 	}
+	if closed == 0 {
+		return fmt.Errorf("未找到待删除的原规则: %s, 协议: %s, 端口: %s, IP: %s", description, protocol, port, cidrBlock)
+	}
+	return nil
+}
 
-	log.Info("创建拒绝规则成功，响应: %s", createResponse.ToJsonString())
+// closeRuleInGroup 在单个安全组中先创建拒绝规则，再删除原规则
+func closeRuleInGroup(client *vpc.Client, securityGroupId, protocol, port, cidrBlock, description string) error {
+	// 1. 创建对应规则的DROP版本
+	log.Info("正在安全组 %s 中创建拒绝规则，保持原备注格式: %s", securityGroupId, description)
 
-	// 2. 删除原有的ACCEPT规则
-	log.Info("正在删除原有规则, PolicyIndex: %s", policyIndexStr)
+	err := updateSecurityGroupPolicies(client, securityGroupId, func(set *vpc.SecurityGroupPolicySet) error {
+		if findPolicyByContent(set, protocol, port, cidrBlock, "ACCEPT", description) == nil {
+			return errRuleNotInGroup
+		}
 
-	deleteRequest := vpc.NewDeleteSecurityGroupPoliciesRequest()
-	deleteRequest.SecurityGroupId = common.StringPtr(cfg.SecurityGroupId)
+		createRequest := vpc.NewCreateSecurityGroupPoliciesRequest()
+		createRequest.SecurityGroupId = common.StringPtr(securityGroupId)
+		dropPolicy := &vpc.SecurityGroupPolicy{
+			Protocol:          common.StringPtr(protocol),
+			Port:              common.StringPtr(port),
+			Action:            common.StringPtr("DROP"),
+			PolicyDescription: common.StringPtr(description),
+		}
+		setPolicySource(dropPolicy, cidrBlock)
+		createRequest.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+			Version: set.Version,
+			Ingress: []*vpc.SecurityGroupPolicy{dropPolicy},
+		}
 
-	// 将 policyIndexStr 转为 int64
-	var policyIndex int64
-	_, err = fmt.Sscanf(policyIndexStr, "%d", &policyIndex)
+		createResponse, err := client.CreateSecurityGroupPolicies(createRequest)
+		if err != nil {
+			return err
+		}
+		log.Info("创建拒绝规则成功，响应: %s", createResponse.ToJsonString())
+		return nil
+	})
+	if errors.Is(err, errRuleNotInGroup) {
+		return err
+	}
 	if err != nil {
-		return fmt.Errorf("无效的PolicyIndex: %s, 错误: %w", policyIndexStr, err)
+		return sdkError("创建拒绝规则", err)
 	}
 
-	// 设置要删除的规则ID
-	deletePolicySet := &vpc.SecurityGroupPolicySet{
-		Ingress: []*vpc.SecurityGroupPolicy{
-			{
-				PolicyIndex: common.Int64Ptr(policyIndex),
-			},
-		},
-	}
-	deleteRequest.SecurityGroupPolicySet = deletePolicySet
+	// 2. 删除原有的ACCEPT规则
+	// 按 协议+端口+CIDR+动作+备注 匹配删除，不依赖会随规则变更而漂移的 PolicyIndex
+	log.Info("正在删除原有规则: %s", description)
 
-	// 发送API请求删除原规则
-	deleteResponse, err := client.DeleteSecurityGroupPolicies(deleteRequest)
-	if err != nil {
-		if sdkErr, ok := err.(*tcErrors.TencentCloudSDKError); ok {
-			return fmt.Errorf("腾讯云API错误(删除原规则): Code=%s, Message=%s, RequestId=%s", sdkErr.GetCode(), sdkErr.GetMessage(), sdkErr.GetRequestId())
+	err = updateSecurityGroupPolicies(client, securityGroupId, func(set *vpc.SecurityGroupPolicySet) error {
+		target := findPolicyByContent(set, protocol, port, cidrBlock, "ACCEPT", description)
+		if target == nil {
+			return fmt.Errorf("未找到待删除的原规则: %s, 协议: %s, 端口: %s, IP: %s", description, protocol, port, cidrBlock)
+		}
+		log.Info("定位到原规则, 当前 PolicyIndex: %d, Version: %s", *target.PolicyIndex, stringValue(set.Version))
+
+		deleteRequest := vpc.NewDeleteSecurityGroupPoliciesRequest()
+		deleteRequest.SecurityGroupId = common.StringPtr(securityGroupId)
+		deleteRequest.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+			Version: set.Version,
+			Ingress: []*vpc.SecurityGroupPolicy{policyMatchSpec(target)},
 		}
-		return fmt.Errorf("调用腾讯云API删除原规则失败: %w", err)
-	}
 
-	log.Info("删除原规则成功，响应: %s", deleteResponse.ToJsonString())
+		deleteResponse, err := client.DeleteSecurityGroupPolicies(deleteRequest)
+		if err != nil {
+			return err
+		}
+		log.Info("删除原规则成功，响应: %s", deleteResponse.ToJsonString())
+		return nil
+	})
+	if err != nil {
+		return sdkError("删除原规则", err)
+	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -14,7 +15,9 @@ import (
 )
 
 type SimpleFrpcConfig struct {
-	Proxies []Proxy `toml:"proxies"`
+	ServerAddr string  `toml:"serverAddr"`
+	User       string  `toml:"user"`
+	Proxies    []Proxy `toml:"proxies"`
 }
 
 type Proxy struct {
@@ -38,24 +41,247 @@ type FetchedRuleInfo struct {
 	ModifyTime        string
 	Action            string
 	LocalPort         string
+	SecurityGroupId   string
+	// TemplateAddresses 规则引用 IP 地址模板时，模板中的地址（CidrBlock 此时为模板 ID）
+	TemplateAddresses string
 }
 
-// getAllSecurityGroupRules 获取所有安全组规则（无论Accept还是Drop）
-func getAllSecurityGroupRules(cfg *config.Config, secretID, secretKey string) (map[string]FetchedRuleInfo, error) {
-	cred := common.NewCredential(secretID, secretKey)
+// ruleSource 返回规则来源的展示文本，引用地址模板时附带模板中的地址
+func ruleSource(rule FetchedRuleInfo) string {
+	if rule.TemplateAddresses != "" {
+		return fmt.Sprintf("%s(%s)", rule.CidrBlock, rule.TemplateAddresses)
+	}
+	return rule.CidrBlock
+}
+
+// maxVersionRetries 安全组 Version 冲突时读-改-写的最大尝试次数
+const maxVersionRetries = 3
+
+// tencentCredential 转换为腾讯云 SDK 的密钥，临时密钥需要同时携带 Token
+func tencentCredential(cred *config.Credential) *common.Credential {
+	return common.NewTokenCredential(cred.SecretId, cred.SecretKey, cred.Token)
+}
+
+// newVpcClient 创建腾讯云 VPC 客户端
+func newVpcClient(cfg *config.Config, cred *config.Credential) (*vpc.Client, error) {
 	cpf := profile.NewClientProfile()
 	cpf.HttpProfile.Endpoint = "vpc.tencentcloudapi.com"
-	client, err := vpc.NewClient(cred, cfg.Region, cpf)
+	client, err := vpc.NewClient(tencentCredential(cred), cfg.Region, cpf)
 	if err != nil {
-		log.Error("创建腾讯云 VPC 客户端失败: %v", err)
-		return nil, fmt.Errorf("创建腾讯云 VPC 客户端失败: %w", err)
+		return nil, fmt.Errorf("创建腾讯云VPC客户端失败: %w", err)
 	}
+	return client, nil
+}
 
-	log.Info("开始查询所有安全组规则, 安全组ID: %s", cfg.SecurityGroupId)
+// describeSecurityGroupPolicySet 查询安全组的全部规则，返回结果中包含当前的 Version
+func describeSecurityGroupPolicySet(client *vpc.Client, securityGroupId string) (*vpc.SecurityGroupPolicySet, error) {
 	request := vpc.NewDescribeSecurityGroupPoliciesRequest()
-	request.SecurityGroupId = common.StringPtr(cfg.SecurityGroupId)
+	request.SecurityGroupId = common.StringPtr(securityGroupId)
 
 	response, err := client.DescribeSecurityGroupPolicies(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.Response == nil || response.Response.SecurityGroupPolicySet == nil {
+		return &vpc.SecurityGroupPolicySet{}, nil
+	}
+	return response.Response.SecurityGroupPolicySet, nil
+}
+
+// updateSecurityGroupPolicies 以乐观锁方式修改安全组规则。
+// 每次尝试都会重新读取规则及 Version，由 mutate 基于这份快照发起写请求并带上 Version；
+// 若写入时 Version 已被他人更新，则重新读取后重试，避免基于过期的快照修改规则。
+func updateSecurityGroupPolicies(client *vpc.Client, securityGroupId string, mutate func(set *vpc.SecurityGroupPolicySet) error) error {
+	return retryPolicyUpdate(securityGroupId, func() (*vpc.SecurityGroupPolicySet, error) {
+		return describeSecurityGroupPolicySet(client, securityGroupId)
+	}, mutate)
+}
+
+// retryPolicyUpdate 执行 读取 -> mutate 的循环，mutate 返回 Version 冲突时重新读取，最多尝试 maxVersionRetries 次
+func retryPolicyUpdate(securityGroupId string, describe func() (*vpc.SecurityGroupPolicySet, error), mutate func(set *vpc.SecurityGroupPolicySet) error) error {
+	for attempt := 1; ; attempt++ {
+		set, err := describe()
+		if err != nil {
+			return err
+		}
+		version := ""
+		if set.Version != nil {
+			version = *set.Version
+		}
+		log.Debug("读取安全组 %s 规则，Version: %s，第 %d 次尝试", securityGroupId, version, attempt)
+
+		err = mutate(set)
+		if err == nil || !isVersionMismatch(err) || attempt >= maxVersionRetries {
+			return err
+		}
+		log.Warn("安全组 %s 的 Version %s 已过期，重新读取后重试: %v", securityGroupId, version, err)
+	}
+}
+
+// isVersionMismatch 判断是否为安全组 Version 冲突错误
+func isVersionMismatch(err error) bool {
+	var sdkErr *tcErrors.TencentCloudSDKError
+	return errors.As(err, &sdkErr) && sdkErr.GetCode() == vpc.UNSUPPORTEDOPERATION_VERSIONMISMATCH
+}
+
+// currentPolicyVersion 写入后重新读取安全组的 Version，供同一次操作中的下一个写请求使用，
+// 不假设每次写入 Version 只加 1
+func currentPolicyVersion(client *vpc.Client, securityGroupId string) (*string, error) {
+	set, err := describeSecurityGroupPolicySet(client, securityGroupId)
+	if err != nil {
+		return nil, err
+	}
+	return set.Version, nil
+}
+
+// sdkError 将腾讯云 API 错误转换为便于展示的错误信息
+func sdkError(action string, err error) error {
+	var sdkErr *tcErrors.TencentCloudSDKError
+	if errors.As(err, &sdkErr) {
+		return fmt.Errorf("腾讯云API错误(%s): Code=%s, Message=%s, RequestId=%s", action, sdkErr.GetCode(), sdkErr.GetMessage(), sdkErr.GetRequestId())
+	}
+	return fmt.Errorf("调用腾讯云API%s失败: %w", action, err)
+}
+
+// stringValue 返回字符串指针的值，nil 时返回空字符串
+func stringValue(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// isAddressTemplateId 判断规则来源是否为 IP 地址模板（ipm-）或地址模板集合（ipmg-）
+func isAddressTemplateId(source string) bool {
+	return strings.HasPrefix(source, "ipm-") || strings.HasPrefix(source, "ipmg-")
+}
+
+// policySource 返回规则的来源：CIDR，或引用的地址模板 ID
+func policySource(policy *vpc.SecurityGroupPolicy) string {
+	if policy.AddressTemplate != nil {
+		if id := stringValue(policy.AddressTemplate.AddressId); id != "" {
+			return id
+		}
+		if id := stringValue(policy.AddressTemplate.AddressGroupId); id != "" {
+			return id
+		}
+	}
+	return stringValue(policy.CidrBlock)
+}
+
+// setPolicySource 按来源类型设置规则的 CidrBlock 或 AddressTemplate
+func setPolicySource(policy *vpc.SecurityGroupPolicy, source string) {
+	switch {
+	case strings.HasPrefix(source, "ipmg-"):
+		policy.AddressTemplate = &vpc.AddressTemplateSpecification{AddressGroupId: common.StringPtr(source)}
+	case strings.HasPrefix(source, "ipm-"):
+		policy.AddressTemplate = &vpc.AddressTemplateSpecification{AddressId: common.StringPtr(source)}
+	default:
+		policy.CidrBlock = common.StringPtr(source)
+	}
+}
+
+// findPolicyByContent 在规则快照中按 协议+端口+来源+动作+备注 查找入站规则，来源为 CIDR 或地址模板 ID
+func findPolicyByContent(set *vpc.SecurityGroupPolicySet, protocol, port, cidrBlock, action, description string) *vpc.SecurityGroupPolicy {
+	for _, policy := range set.Ingress {
+		if policy.PolicyIndex != nil &&
+			strings.EqualFold(stringValue(policy.Protocol), protocol) &&
+			stringValue(policy.Port) == port &&
+			policySource(policy) == cidrBlock &&
+			stringValue(policy.Action) == action &&
+			stringValue(policy.PolicyDescription) == description {
+			return policy
+		}
+	}
+	return nil
+}
+
+// policyMatchSpec 生成按规则内容匹配删除所需的规则描述（不含 PolicyIndex）
+func policyMatchSpec(policy *vpc.SecurityGroupPolicy) *vpc.SecurityGroupPolicy {
+	return &vpc.SecurityGroupPolicy{
+		Protocol:          policy.Protocol,
+		Port:              policy.Port,
+		CidrBlock:         policy.CidrBlock,
+		ServiceTemplate:   policy.ServiceTemplate,
+		AddressTemplate:   policy.AddressTemplate,
+		Action:            policy.Action,
+		PolicyDescription: policy.PolicyDescription,
+	}
+}
+
+// getAllSecurityGroupRules 获取所有安全组规则（无论Accept还是Drop），多个安全组的规则合并后返回
+func getAllSecurityGroupRules(backend Backend) (map[string]FetchedRuleInfo, error) {
+	rulesByGroup, err := getSecurityGroupRulesByGroup(backend)
+	if err != nil {
+		return nil, err
+	}
+	return mergeGroupRules(backend.Groups(), rulesByGroup), nil
+}
+
+// getSecurityGroupRulesByGroup 分别获取每个安全组的规则，key 为安全组 ID
+func getSecurityGroupRulesByGroup(backend Backend) (map[string]map[string]FetchedRuleInfo, error) {
+	rulesByGroup := make(map[string]map[string]FetchedRuleInfo)
+	for _, group := range backend.Groups() {
+		rules, err := backend.ListGroupRules(group)
+		if err != nil {
+			return nil, fmt.Errorf("安全组 %s: %w", group, err)
+		}
+		rulesByGroup[group] = rules
+	}
+	return rulesByGroup, nil
+}
+
+// mergeGroupRules 合并多个安全组的规则：任一安全组中存在 ACCEPT 规则即取该规则，否则取 DROP 规则
+func mergeGroupRules(securityGroupIds []string, rulesByGroup map[string]map[string]FetchedRuleInfo) map[string]FetchedRuleInfo {
+	merged := make(map[string]FetchedRuleInfo)
+	for _, securityGroupId := range securityGroupIds {
+		for proxyName, rule := range rulesByGroup[securityGroupId] {
+			if existing, ok := merged[proxyName]; !ok || (existing.Action != "ACCEPT" && rule.Action == "ACCEPT") {
+				merged[proxyName] = rule
+			}
+		}
+	}
+	return merged
+}
+
+// isOpenInAllGroups 判断服务是否在所有安全组中均已开放
+func isOpenInAllGroups(securityGroupIds []string, rulesByGroup map[string]map[string]FetchedRuleInfo, proxyName string) bool {
+	for _, securityGroupId := range securityGroupIds {
+		if rule, ok := rulesByGroup[securityGroupId][proxyName]; !ok || rule.Action != "ACCEPT" {
+			return false
+		}
+	}
+	return len(securityGroupIds) > 0
+}
+
+// describeGroupStates 汇总服务在各安全组中的状态，consistent 表示各安全组状态是否一致
+func describeGroupStates(securityGroupIds []string, rulesByGroup map[string]map[string]FetchedRuleInfo, proxyName string) (summary string, consistent bool) {
+	var states []string
+	consistent = true
+	first := ""
+	for i, securityGroupId := range securityGroupIds {
+		state := "未开放"
+		if rule, ok := rulesByGroup[securityGroupId][proxyName]; ok {
+			if rule.Action == "ACCEPT" {
+				state = "已开放"
+			} else if rule.Action == "DROP" {
+				state = "已拒绝"
+			}
+		}
+		if i == 0 {
+			first = state
+		} else if state != first {
+			consistent = false
+		}
+		states = append(states, fmt.Sprintf("%s %s", securityGroupId, state))
+	}
+	return strings.Join(states, ", "), consistent
+}
+
+// getSecurityGroupRules 获取单个安全组中由 Workflow 创建的规则，以 proxy name 为 key
+func getSecurityGroupRules(client *vpc.Client, securityGroupId string) (map[string]FetchedRuleInfo, error) {
+	log.Info("开始查询所有安全组规则, 安全组ID: %s", securityGroupId)
+	policySet, err := describeSecurityGroupPolicySet(client, securityGroupId)
 	if err != nil {
 		if sdkErr, ok := err.(*tcErrors.TencentCloudSDKError); ok {
 			log.Error("腾讯云 API 错误: Code=%s, Message=%s, RequestId=%s", sdkErr.GetCode(), sdkErr.GetMessage(), sdkErr.GetRequestId())
@@ -64,15 +290,20 @@ func getAllSecurityGroupRules(cfg *config.Config, secretID, secretKey string) (m
 		log.Error("调用腾讯云 API 失败: %v", err)
 		return nil, fmt.Errorf("调用腾讯云 API 失败: %w", err)
 	}
+	return parseSecurityGroupRules(policySet, securityGroupId), nil
+}
 
+// parseSecurityGroupRules 从规则快照中解析由 Workflow 创建的逐服务规则，以 proxy name 为 key
+func parseSecurityGroupRules(policySet *vpc.SecurityGroupPolicySet, securityGroupId string) map[string]FetchedRuleInfo {
 	allRules := make(map[string]FetchedRuleInfo)
 
-	if response.Response != nil && response.Response.SecurityGroupPolicySet != nil {
+	if policySet.Ingress != nil {
 		log.Info("成功获取安全组规则，开始解析所有规则")
-		for _, policy := range response.Response.SecurityGroupPolicySet.Ingress {
+		for _, policy := range policySet.Ingress {
 			if policy.PolicyDescription != nil && strings.HasPrefix(*policy.PolicyDescription, "AlfredFRP_") {
-				if policy.Protocol != nil && policy.Port != nil && policy.CidrBlock != nil && policy.Action != nil {
-					proxyName := extractServiceName(*policy.PolicyDescription)
+				source := policySource(policy)
+				if policy.Protocol != nil && policy.Port != nil && source != "" && policy.Action != nil {
+					proxyName := ruleKey(*policy.PolicyDescription)
 					localPort := extractLocalPort(*policy.PolicyDescription)
 
 					var policyIndex int64 = -1
@@ -96,17 +327,18 @@ func getAllSecurityGroupRules(cfg *config.Config, secretID, secretKey string) (m
 					}
 
 					log.Debug("找到规则: %s, 协议: %s, 端口: %s, CIDR: %s, 动作: %s, 描述: %s, 索引: %d, 修改时间: %s, 本地端口: %s",
-						proxyName, *policy.Protocol, *policy.Port, *policy.CidrBlock, action, desc, policyIndex, mtime, localPort)
+						proxyName, *policy.Protocol, *policy.Port, source, action, desc, policyIndex, mtime, localPort)
 
 					allRules[proxyName] = FetchedRuleInfo{
 						PolicyDescription: desc,
 						Protocol:          strings.ToUpper(*policy.Protocol),
 						Port:              *policy.Port,
-						CidrBlock:         *policy.CidrBlock,
+						CidrBlock:         source,
 						PolicyIndex:       policyIndex,
 						ModifyTime:        mtime,
 						Action:            action,
 						LocalPort:         localPort,
+						SecurityGroupId:   securityGroupId,
 					}
 				}
 			}
@@ -115,7 +347,7 @@ func getAllSecurityGroupRules(cfg *config.Config, secretID, secretKey string) (m
 	} else {
 		log.Warn("API响应为空或没有安全组策略集")
 	}
-	return allRules, nil
+	return allRules
 }
 
 // 从策略描述中提取服务名称
@@ -125,7 +357,8 @@ func extractServiceName(description string) string {
 		return "未知服务"
 	}
 
-	// 移除AlfredFRP_前缀
+	// 移除AlfredFRP_前缀和 #授权来源
+	description, _ = splitGrant(description)
 	nameWithPort := strings.TrimPrefix(description, "AlfredFRP_")
 
 	// 查找_local分隔符
@@ -145,10 +378,12 @@ func extractServiceName(description string) string {
 // 从策略描述中提取本地端口
 func extractLocalPort(description string) string {
 	// 预期格式: AlfredFRP_服务名_local端口
+	description, _ = splitGrant(description)
 	idx := strings.LastIndex(description, "_local")
 	if idx == -1 {
 		return "未知" // 如果没有local部分，返回未知
 	}
 
-	return description[idx+6:] // +6是为了跳过"_local"
+	port, _, _ := strings.Cut(description[idx+6:], "@") // +6是为了跳过"_local"，去掉 @属主
+	return port
 }
@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+func TestMergeGroupRules(t *testing.T) {
+	groups := []string{"sg-a", "sg-b"}
+	rulesByGroup := map[string]map[string]FetchedRuleInfo{
+		"sg-a": {
+			"ssh": {Action: "DROP", SecurityGroupId: "sg-a"},
+			"web": {Action: "ACCEPT", SecurityGroupId: "sg-a"},
+		},
+		"sg-b": {
+			"ssh": {Action: "ACCEPT", SecurityGroupId: "sg-b"},
+			"web": {Action: "ACCEPT", SecurityGroupId: "sg-b"},
+		},
+	}
+
+	merged := mergeGroupRules(groups, rulesByGroup)
+	if got := merged["ssh"]; got.Action != "ACCEPT" || got.SecurityGroupId != "sg-b" {
+		t.Errorf("ssh merged rule = %+v, want ACCEPT from sg-b", got)
+	}
+	if got := merged["web"]; got.SecurityGroupId != "sg-a" {
+		t.Errorf("web merged rule = %+v, want rule from sg-a", got)
+	}
+
+	if isOpenInAllGroups(groups, rulesByGroup, "ssh") {
+		t.Error("ssh should not be open in all groups")
+	}
+	if !isOpenInAllGroups(groups, rulesByGroup, "web") {
+		t.Error("web should be open in all groups")
+	}
+}
+
+func TestDescribeGroupStates(t *testing.T) {
+	groups := []string{"sg-a", "sg-b"}
+	rulesByGroup := map[string]map[string]FetchedRuleInfo{
+		"sg-a": {"ssh": {Action: "ACCEPT"}},
+		"sg-b": {},
+	}
+
+	summary, consistent := describeGroupStates(groups, rulesByGroup, "ssh")
+	if consistent {
+		t.Error("ssh should be reported as inconsistent")
+	}
+	if want := "sg-a 已开放, sg-b 未开放"; summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+
+	if _, consistent := describeGroupStates(groups, rulesByGroup, "web"); !consistent {
+		t.Error("web is absent everywhere and should be consistent")
+	}
+}
+
+func TestPolicySource(t *testing.T) {
+	cases := []string{"198.51.100.7/32", "ipm-2uw6ujo6", "ipmg-2uw6ujo6"}
+	for _, source := range cases {
+		policy := &vpc.SecurityGroupPolicy{}
+		setPolicySource(policy, source)
+		if got := policySource(policy); got != source {
+			t.Errorf("policySource after setPolicySource(%s) = %s", source, got)
+		}
+		if isAddressTemplateId(source) != (policy.CidrBlock == nil) {
+			t.Errorf("setPolicySource(%s) set CidrBlock=%v, AddressTemplate=%v", source, policy.CidrBlock, policy.AddressTemplate)
+		}
+	}
+}
+
+func TestFindPolicyByAddressTemplate(t *testing.T) {
+	index := int64(0)
+	templatePolicy := &vpc.SecurityGroupPolicy{
+		PolicyIndex:       &index,
+		Protocol:          common.StringPtr("tcp"),
+		Port:              common.StringPtr("8080"),
+		AddressTemplate:   &vpc.AddressTemplateSpecification{AddressId: common.StringPtr("ipm-abc")},
+		Action:            common.StringPtr("ACCEPT"),
+		PolicyDescription: common.StringPtr("AlfredFRP_web_local8080"),
+	}
+	set := &vpc.SecurityGroupPolicySet{Ingress: []*vpc.SecurityGroupPolicy{templatePolicy}}
+
+	if got := findPolicyByContent(set, "TCP", "8080", "ipm-abc", "ACCEPT", "AlfredFRP_web_local8080"); got != templatePolicy {
+		t.Errorf("findPolicyByContent by template = %v, want template policy", got)
+	}
+	if got := findPolicyByContent(set, "TCP", "8080", "198.51.100.7/32", "ACCEPT", "AlfredFRP_web_local8080"); got != nil {
+		t.Errorf("findPolicyByContent by CIDR = %v, want nil", got)
+	}
+	if spec := policyMatchSpec(templatePolicy); spec.AddressTemplate == nil || spec.PolicyIndex != nil {
+		t.Errorf("policyMatchSpec = %+v, want AddressTemplate without PolicyIndex", spec)
+	}
+
+	rule := FetchedRuleInfo{CidrBlock: "ipm-abc", TemplateAddresses: "198.51.100.7/32"}
+	if got := ruleSource(rule); got != "ipm-abc(198.51.100.7/32)" {
+		t.Errorf("ruleSource = %s", got)
+	}
+}
+
+func TestRetryPolicyUpdate(t *testing.T) {
+	mismatch := tcErrors.NewTencentCloudSDKError(vpc.UNSUPPORTEDOPERATION_VERSIONMISMATCH, "", "")
+	// 每次读取时 Version 都已被他人修改，且增量不固定为 1
+	run := func(failures int, failErr error) (versions []string, err error) {
+		reads := 0
+		describe := func() (*vpc.SecurityGroupPolicySet, error) {
+			reads++
+			return &vpc.SecurityGroupPolicySet{Version: common.StringPtr(strconv.Itoa(reads * 3))}, nil
+		}
+		err = retryPolicyUpdate("sg-test", describe, func(set *vpc.SecurityGroupPolicySet) error {
+			versions = append(versions, *set.Version)
+			if len(versions) <= failures {
+				return failErr
+			}
+			return nil
+		})
+		return versions, err
+	}
+
+	versions, err := run(2, mismatch)
+	if err != nil || len(versions) != 3 || versions[2] != "9" {
+		t.Errorf("retry after mismatch: versions=%v err=%v, want a fresh read for each of 3 attempts", versions, err)
+	}
+	if versions, err = run(maxVersionRetries, mismatch); !isVersionMismatch(err) || len(versions) != maxVersionRetries {
+		t.Errorf("exhausted retries: versions=%v err=%v", versions, err)
+	}
+	other := errors.New("quota exceeded")
+	if versions, err = run(1, other); err != other || len(versions) != 1 {
+		t.Errorf("non-mismatch error should not be retried: versions=%v err=%v", versions, err)
+	}
+}
@@ -20,88 +20,97 @@ func ConfigCommand(wf *aw.Workflow, args []string) {
 		return
 	}
 
-	// 只保留 setup_secretid 和 setup_secretkey 逻辑
 	sub := args[1]
 	switch sub {
 	case "setup_secretid":
 		setupSecretId(wf, args)
 	case "setup_secretkey":
 		setupSecretKey(wf, args)
+	case "complete":
+		// 输入配置值时的候选项，由 Alfred 的输入步骤调用: config complete <action> <当前输入>
+		if len(args) > 2 {
+			completeSetting(wf, args)
+		} else {
+			showConfigHelp(wf)
+		}
+	case "setup_keys":
+		setupKeys(wf, args)
+	case "address_add":
+		cfg, _ := loadSetupConfig()
+		addAddressBookEntry(wf, args, minCIDRPrefix(cfg))
+	case "address_remove":
+		removeAddressBookEntry(wf, args)
+	case "profile":
+		// config profile <name> 等同于 profile use <name>
+		if len(args) > 2 {
+			useProfile(wf, args[2])
+		} else {
+			showProfiles(wf)
+		}
 	default:
+		if s, ok := settingByAction(sub); ok {
+			applySetting(wf, s, args)
+			return
+		}
 		showConfigHelp(wf)
 	}
 }
 
 func showConfigHelp(wf *aw.Workflow) {
-	cfg, _ := config.Load()
-
-	// SecretId
-	id, _ := config.GetSecretId()
-	idShow := "未设置"
-	idTitle := "设置 SecretId"
-	if id != "" {
-		idShow = maskSecret(id)
-		idTitle = "修改 SecretId"
-	}
-	wf.NewItem(idTitle).
-		Subtitle(idShow).
-		Valid(true).
-		Arg("setup_secretid")
+	cfg, err := loadSetupConfig()
+	if err != nil {
+		wf.NewItem("读取配置失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+	}
 
-	// SecretKey
-	key, _ := config.GetSecretKey()
-	keyShow := "未设置"
-	keyTitle := "设置 SecretKey"
-	if key != "" {
-		keyShow = maskSecret(key)
-		keyTitle = "修改 SecretKey"
+	// 当前 profile
+	profile := "未使用配置文件，仅读取环境变量"
+	if cfg.Profile != "" {
+		profile = cfg.Profile + "（回车后输入 profile 名称切换）"
 	}
-	wf.NewItem(keyTitle).
-		Subtitle(keyShow).
+	wf.NewItem("🗂 Profile").
+		Subtitle(profile).
 		Valid(true).
-		Arg("setup_secretkey")
+		Arg("profile")
 
-	// frpc.toml 路径
-	tomlPath := "未设置"
-	if cfg.FrpcTomlPath != "" {
-		tomlPath = cfg.FrpcTomlPath
-	}
-	wf.NewItem("🔒 frpc.toml 路径").
-		Subtitle(tomlPath).
-		Valid(false)
+	// 密钥链：依次展示各来源，标出生效的来源
+	credentialFound := showCredentialSources(wf)
 
-	// 安全组ID
-	sgid := "未设置"
-	if cfg.SecurityGroupId != "" {
-		sgid = cfg.SecurityGroupId
-	}
-	wf.NewItem("🔒 安全组 ID").
-		Subtitle(sgid).
-		Valid(false)
+	// 写入密钥存储的 SecretId/SecretKey
+	wf.NewItem("设置 SecretId").
+		Subtitle("保存到密钥存储，优先级低于上面的其他来源").
+		Valid(true).
+		Arg("setup_secretid")
+	wf.NewItem("设置 SecretKey").
+		Subtitle("保存到密钥存储，优先级低于上面的其他来源").
+		Valid(true).
+		Arg("setup_secretkey")
 
-	// 区域
-	region := "未设置"
-	if cfg.Region != "" {
-		region = cfg.Region
+	// 各配置项，回车后输入新值写入当前 profile
+	showSettings(wf, cfg)
+
+	// 未直接配置安全组时，展示从 CVM 实例反查并缓存的结果
+	if (cfg.Provider == "" || cfg.Provider == config.ProviderTencent) && cfg.SecurityGroupId == "" {
+		source := cfg.InstanceId
+		if source == "" {
+			source = "frpc.toml serverAddr"
+		}
+		lookupShow := "尚未查询，执行 list/open/close 时自动反查"
+		if cached, err := cachedInstanceSecurityGroups(wf, cfg); err == nil {
+			lookupShow = fmt.Sprintf("%s(%s) → %s", cached.InstanceId, cached.InstanceName, strings.Join(cached.SecurityGroupIds, ", "))
+		}
+		wf.NewItem("🔍 CVM 实例安全组: " + source).
+			Subtitle(lookupShow).
+			Valid(false)
 	}
-	wf.NewItem("🔒 腾讯云 API 区域").
-		Subtitle(region).
-		Valid(false)
 
-	// 日志路径
-	logPath := "未设置"
-	if cfg.LogPath != "" {
-		logPath = cfg.LogPath
-	}
-	wf.NewItem("🔒 日志路径").
-		Subtitle(logPath).
-		Valid(false)
+	// 地址簿
+	showAddressBook(wf)
 
 	// 提示
-	if id == "" || key == "" {
+	if !credentialFound && cfg.UsesCloudAPI() {
 		wf.NewItem("请先设置 SecretId 和 SecretKey，否则无法正常使用。").Valid(false)
 	}
-	wf.NewItem("如需修改 region、frpc.toml 路径等参数，请在 Alfred 的 Workflow 配置面板中设置。").Valid(false)
+	wf.NewItem("Alfred 的 Workflow 变量优先于此处的设置，使用此处设置时请清空对应变量。").Valid(false)
 
 	wf.SendFeedback()
 }
@@ -138,9 +147,81 @@ func setupSecretKey(wf *aw.Workflow, args []string) {
 	wf.SendFeedback()
 }
 
+// showCredentialSources 按优先级展示密钥链中的各来源，返回是否找到可用的密钥
+func showCredentialSources(wf *aw.Workflow) bool {
+	lookups, err := config.InspectCredentials()
+	if err != nil {
+		wf.NewItem("🔑 读取密钥失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+		return false
+	}
+	found := false
+	for _, lookup := range lookups {
+		switch {
+		case lookup.Err != nil:
+			wf.NewItem("🔑 " + lookup.Source).
+				Subtitle(IconMismatch + " " + lookup.Err.Error()).
+				Valid(false)
+		case lookup.Credential == nil:
+			wf.NewItem("🔑 " + lookup.Source).
+				Subtitle("未设置").
+				Valid(false)
+		case !found:
+			found = true
+			wf.NewItem(IconOpen + " 🔑 " + lookup.Source).
+				Subtitle("生效中: " + maskSecret(lookup.Credential.SecretId) + " | 来源: " + lookup.Credential.Source).
+				Valid(false)
+		default:
+			wf.NewItem("🔑 " + lookup.Source).
+				Subtitle(maskSecret(lookup.Credential.SecretId) + "（被优先级更高的来源覆盖）").
+				Valid(false)
+		}
+	}
+	return found
+}
+
+// setupKeys 一次输入 SecretId 和 SecretKey，以空格分隔
+func setupKeys(wf *aw.Workflow, args []string) {
+	if len(args) < 4 {
+		wf.NewItem("请输入 SecretId SecretKey（以空格分隔）后回车").Valid(false)
+		wf.SendFeedback()
+		return
+	}
+	err := config.SaveSecretId(args[2])
+	if err == nil {
+		err = config.SaveSecretKey(args[3])
+	}
+	if err != nil {
+		wf.NewItem("保存密钥失败").Subtitle(err.Error()).Valid(false)
+	} else {
+		wf.NewItem("SecretId 和 SecretKey 保存成功").Valid(false)
+	}
+	wf.SendFeedback()
+}
+
 func maskSecret(s string) string {
 	if len(s) <= 8 {
 		return s
 	}
 	return s[:4] + strings.Repeat("*", 4) + s[len(s)-4:]
 }
+
+// providerDescription 返回规则后端及其作用对象的说明
+func providerDescription(cfg *config.Config) string {
+	switch cfg.Provider {
+	case "", config.ProviderTencent:
+		return config.ProviderTencent + "（VPC 安全组）"
+	case config.ProviderLighthouse:
+		return config.ProviderLighthouse + "（轻量应用服务器防火墙，实例: " + cfg.InstanceId + "）"
+	case config.ProviderAWS:
+		return config.ProviderAWS + "（EC2 安全组）"
+	case config.ProviderAliyun:
+		return config.ProviderAliyun + "（阿里云 ECS 安全组）"
+	case config.ProviderNftables, config.ProviderIptables:
+		sshHost := cfg.SSHHost
+		if sshHost == "" {
+			sshHost = "root@serverAddr"
+		}
+		return cfg.Provider + "（frps 主机防火墙，SSH: " + sshHost + "）"
+	}
+	return cfg.Provider
+}
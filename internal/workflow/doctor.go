@@ -0,0 +1,342 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/BurntSushi/toml"
+	aw "github.com/deanishe/awgo"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// checkStatus 诊断项的结果
+type checkStatus int
+
+const (
+	checkPass checkStatus = iota
+	checkWarn
+	checkFail
+	checkSkip // 前置检查未通过，无法进行
+)
+
+// icon 返回诊断结果的图标
+func (s checkStatus) icon() string {
+	switch s {
+	case checkPass:
+		return IconOpen
+	case checkWarn:
+		return IconMismatch
+	case checkFail:
+		return "❌"
+	}
+	return "⏭"
+}
+
+// doctorCheck 一项诊断的结果
+type doctorCheck struct {
+	name   string
+	status checkStatus
+	detail string
+}
+
+// doctorState 在各诊断项之间传递的结果，前一项失败时后续依赖它的诊断项会跳过
+type doctorState struct {
+	wf      *aw.Workflow
+	cfg     *config.Config
+	proxies int
+	cred    *config.Credential
+	backend Backend
+	// policySets 腾讯云后端各安全组的规则，用于计算规则配额
+	policySets map[string]*vpc.SecurityGroupPolicySet
+}
+
+// doctorStep 按顺序执行的诊断项
+type doctorStep struct {
+	name string
+	run  func(st *doctorState) (checkStatus, string)
+}
+
+var doctorSteps = []doctorStep{
+	{"配置", checkConfig},
+	{"frpc.toml", checkFrpcToml},
+	{"密钥", checkCredential},
+	{"安全组 API", checkGroupAccess},
+	{"公网 IP", checkPublicIP},
+	{"日志文件", checkLogFile},
+	{"规则配额", checkRuleQuota},
+}
+
+// DoctorCommand 依次诊断配置、frpc.toml、密钥、云 API 权限、公网 IP、日志文件和规则配额。
+// 在 Alfred 中以列表展示，在终端中运行或带 --text 参数时输出文本清单
+func DoctorCommand(wf *aw.Workflow, args []string) {
+	checks := runDoctor(wf)
+	if wantsTextOutput(args) {
+		fmt.Print(formatDoctorChecks(checks))
+		return
+	}
+	wf.NewItem(doctorSummary(checks)).
+		Subtitle("在终端运行 alfred-frp-sg doctor 可输出文本清单").
+		Valid(false).
+		Icon(aw.IconInfo)
+	for _, check := range checks {
+		wf.NewItem(check.status.icon() + " " + check.name).
+			Subtitle(check.detail).
+			Valid(false)
+	}
+	wf.SendFeedback()
+}
+
+// wantsTextOutput 在终端中运行（没有 Alfred 设置的 alfred_version）或带 --text 参数时输出文本而非 Alfred 结果
+func wantsTextOutput(args []string) bool {
+	return os.Getenv("alfred_version") == "" || (len(args) > 0 && args[0] == "--text")
+}
+
+// runDoctor 按顺序执行所有诊断项
+func runDoctor(wf *aw.Workflow) []doctorCheck {
+	st := &doctorState{wf: wf}
+	var checks []doctorCheck
+	for _, step := range doctorSteps {
+		status, detail := step.run(st)
+		log.Info("诊断 %s: %s %s", step.name, status.icon(), detail)
+		checks = append(checks, doctorCheck{name: step.name, status: status, detail: detail})
+	}
+	return checks
+}
+
+// doctorSummary 汇总各诊断结果的数量
+func doctorSummary(checks []doctorCheck) string {
+	counts := make(map[checkStatus]int)
+	for _, check := range checks {
+		counts[check.status]++
+	}
+	if counts[checkFail] == 0 && counts[checkWarn] == 0 {
+		return fmt.Sprintf("%s 全部 %d 项检查通过", IconOpen, counts[checkPass])
+	}
+	return fmt.Sprintf("%d 项通过，%d 项警告，%d 项失败，%d 项跳过",
+		counts[checkPass], counts[checkWarn], counts[checkFail], counts[checkSkip])
+}
+
+// formatDoctorChecks 以文本清单展示诊断结果
+func formatDoctorChecks(checks []doctorCheck) string {
+	var b strings.Builder
+	for _, check := range checks {
+		fmt.Fprintf(&b, "%s %s: %s\n", check.status.icon(), check.name, check.detail)
+	}
+	b.WriteString(doctorSummary(checks) + "\n")
+	return b.String()
+}
+
+// checkConfig 检查配置文件与环境变量合并后的必填项，以及所选后端需要的配置
+func checkConfig(st *doctorState) (checkStatus, string) {
+	cfg, err := config.LoadPartial()
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	st.cfg = cfg
+	if cfg.Provider != "" {
+		if _, err := normalizeProvider(nil, nil, cfg.Provider); err != nil {
+			return checkFail, err.Error()
+		}
+	}
+	missing := missingSettings(cfg)
+	if len(missing) > 0 {
+		return checkFail, "缺少 " + strings.Join(missing, "、") + "，请在 fc 中设置"
+	}
+	profile := cfg.Profile
+	if profile == "" {
+		profile = "未使用配置文件"
+	}
+	detail := "profile: " + profile + "，后端: " + providerDescription(cfg)
+	if usesTencentRegions(cfg.Provider) {
+		// 不调用 API，只与缓存或内置的地域列表比较，新开放的地域可能不在内置列表中
+		regions, _ := knownRegions(st.wf, &config.Credential{})
+		if err := validateRegion(cfg.Provider, cfg.Region, regions); err != nil {
+			return checkWarn, detail + "，" + err.Error()
+		}
+	}
+	return checkPass, detail
+}
+
+// missingSettings 返回未设置的必填项，包括所选后端需要的配置
+func missingSettings(cfg *config.Config) []string {
+	var missing []string
+	for _, key := range []string{"FRPC_TOML_PATH", "REGION", "LOG_PATH"} {
+		if value, _ := cfg.Value(key); value == "" {
+			missing = append(missing, key)
+		}
+	}
+	switch cfg.Provider {
+	case config.ProviderLighthouse:
+		if cfg.InstanceId == "" {
+			missing = append(missing, "INSTANCE_ID")
+		}
+	case config.ProviderAWS, config.ProviderAliyun:
+		if cfg.SecurityGroupId == "" {
+			missing = append(missing, "SECURITY_GROUP_ID")
+		}
+	}
+	return missing
+}
+
+// checkFrpcToml 检查 frpc.toml 可读取、可解析，并统计 proxy 数量
+func checkFrpcToml(st *doctorState) (checkStatus, string) {
+	if st.cfg == nil || st.cfg.FrpcTomlPath == "" {
+		return checkSkip, "未配置 FRPC_TOML_PATH"
+	}
+	data, err := os.ReadFile(st.cfg.FrpcTomlPath)
+	if err != nil {
+		return checkFail, "无法读取: " + err.Error()
+	}
+	var frpcConf SimpleFrpcConfig
+	if _, err := toml.Decode(string(data), &frpcConf); err != nil {
+		return checkFail, "解析失败: " + err.Error()
+	}
+	st.proxies = len(frpcConf.Proxies)
+	if st.proxies == 0 {
+		return checkWarn, st.cfg.FrpcTomlPath + " 中没有定义 proxy"
+	}
+	return checkPass, fmt.Sprintf("%s，共 %d 个 proxy，serverAddr: %s", st.cfg.FrpcTomlPath, st.proxies, frpcConf.ServerAddr)
+}
+
+// checkCredential 检查密钥链中能否找到密钥，配置了 ROLE_ARN 时同时检查能否扮演角色
+func checkCredential(st *doctorState) (checkStatus, string) {
+	if st.cfg == nil {
+		return checkSkip, "配置读取失败"
+	}
+	if !st.cfg.UsesCloudAPI() {
+		st.cred = &config.Credential{}
+		return checkSkip, "本机防火墙后端不使用云 API 密钥"
+	}
+	base, err := config.LoadCredential()
+	if err != nil {
+		return checkFail, err.Error() + "，请在 fc 中设置 SecretId/SecretKey"
+	}
+	cred, err := assumeRoleIfConfigured(st.wf, st.cfg, base)
+	if err != nil {
+		return checkFail, "基础密钥来自 " + base.Source + "，" + err.Error()
+	}
+	st.cred = cred
+	return checkPass, "来源: " + cred.Source + "，SecretId: " + maskSecret(base.SecretId)
+}
+
+// checkGroupAccess 检查能否读取每个规则组，腾讯云后端即 DescribeSecurityGroupPolicies 的连通性与权限
+func checkGroupAccess(st *doctorState) (checkStatus, string) {
+	if st.cfg == nil || st.cred == nil {
+		return checkSkip, "缺少配置或密钥"
+	}
+	if missing := missingSettings(st.cfg); len(missing) > 0 {
+		return checkSkip, "缺少 " + strings.Join(missing, "、")
+	}
+	backend, err := newBackend(st.wf, st.cfg, st.cred)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	st.backend = backend
+
+	var summaries []string
+	if b, ok := backend.(*vpcBackend); ok {
+		st.policySets = make(map[string]*vpc.SecurityGroupPolicySet)
+		for _, group := range b.groups {
+			set, err := describeSecurityGroupPolicySet(b.client, group)
+			if err != nil {
+				return checkFail, group + ": " + sdkError("DescribeSecurityGroupPolicies", err).Error()
+			}
+			st.policySets[group] = set
+			summaries = append(summaries, fmt.Sprintf("%s（%d 条入站规则）", group, len(set.Ingress)))
+		}
+	} else {
+		for _, group := range backend.Groups() {
+			rules, err := backend.ListGroupRules(group)
+			if err != nil {
+				return checkFail, group + ": " + err.Error()
+			}
+			summaries = append(summaries, fmt.Sprintf("%s（%d 条 Workflow 规则）", group, len(rules)))
+		}
+	}
+	return checkPass, "可读取 " + strings.Join(summaries, "、")
+}
+
+// checkPublicIP 检查能否按 IP_RESOLVERS 获取公网 IP，各来源不一致时给出警告
+func checkPublicIP(st *doctorState) (checkStatus, string) {
+	cfg := st.cfg
+	if cfg == nil {
+		cfg = &config.Config{}
+	}
+	consensus, err := lookupPublicIP(cfg)
+	if err != nil {
+		return checkFail, err.Error()
+	}
+	if conflicts := consensus.conflicts(); conflicts != "" {
+		return checkWarn, consensus.IP + "，" + conflicts
+	}
+	return checkPass, fmt.Sprintf("%s（%d 个来源一致）", consensus.IP, len(consensus.Votes[consensus.IP]))
+}
+
+// checkLogFile 检查日志文件可写入
+func checkLogFile(st *doctorState) (checkStatus, string) {
+	if st.cfg == nil || st.cfg.LogPath == "" {
+		return checkFail, "未配置 LOG_PATH"
+	}
+	file, err := os.OpenFile(st.cfg.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return checkFail, "无法写入: " + err.Error()
+	}
+	file.Close()
+	return checkPass, st.cfg.LogPath
+}
+
+// checkRuleQuota 比较各安全组的入站规则数与配额，剩余条数不足以开放全部 proxy 时给出警告
+func checkRuleQuota(st *doctorState) (checkStatus, string) {
+	b, ok := st.backend.(*vpcBackend)
+	if !ok || st.policySets == nil {
+		if st.backend != nil {
+			return checkSkip, "仅 tencent 后端支持查询规则配额"
+		}
+		return checkSkip, "未能读取安全组"
+	}
+	limit, err := describeSecurityGroupPolicyLimit(b.client)
+	if err != nil {
+		return checkWarn, err.Error()
+	}
+	// 协议端口模板模式下一个来源只占用一条规则
+	needed := st.proxies
+	if b.serviceTemplate != nil {
+		needed = 1
+	}
+
+	status := checkPass
+	var summaries []string
+	for _, group := range b.groups {
+		used := len(st.policySets[group].Ingress)
+		free := limit - used
+		summaries = append(summaries, fmt.Sprintf("%s 已用 %d/%d，剩余 %d", group, used, limit, free))
+		switch {
+		case free <= 0:
+			status = checkFail
+		case free < needed && status == checkPass:
+			status = checkWarn
+		}
+	}
+	detail := strings.Join(summaries, "；")
+	if status != checkPass {
+		detail += fmt.Sprintf("，开放全部服务需要 %d 条", needed)
+	}
+	return status, detail
+}
+
+// describeSecurityGroupPolicyLimit 查询单个安全组的最大规则数
+func describeSecurityGroupPolicyLimit(client *vpc.Client) (int, error) {
+	response, err := client.DescribeSecurityGroupLimits(vpc.NewDescribeSecurityGroupLimitsRequest())
+	if err != nil {
+		return 0, sdkError("查询安全组配额", err)
+	}
+	if response.Response == nil || response.Response.SecurityGroupLimitSet == nil ||
+		response.Response.SecurityGroupLimitSet.SecurityGroupPolicyLimit == nil {
+		return 0, fmt.Errorf("DescribeSecurityGroupLimits 未返回规则配额")
+	}
+	return int(*response.Response.SecurityGroupLimitSet.SecurityGroupPolicyLimit), nil
+}
@@ -0,0 +1,70 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+func TestCheckConfigAndFrpcToml(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	tomlPath := filepath.Join(dir, "frpc.toml")
+	os.WriteFile(tomlPath, []byte("serverAddr = \"1.2.3.4\"\n[[proxies]]\nname = \"ssh\"\n[[proxies]]\nname = \"web\"\n"), 0o600)
+	for _, key := range []string{"PROFILE", "FRPC_TOML_PATH", "REGION", "LOG_PATH", "PROVIDER", "INSTANCE_ID"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("FRPC_TOML_PATH", tomlPath)
+	t.Setenv("PROVIDER", config.ProviderLighthouse)
+
+	st := &doctorState{wf: newTestWorkflow(t)}
+	status, detail := checkConfig(st)
+	if status != checkFail || !strings.Contains(detail, "REGION") || !strings.Contains(detail, "INSTANCE_ID") {
+		t.Errorf("checkConfig = %v %q, want failure listing REGION and INSTANCE_ID", status, detail)
+	}
+	if status, detail = checkFrpcToml(st); status != checkPass || st.proxies != 2 {
+		t.Errorf("checkFrpcToml = %v %q, proxies = %d", status, detail, st.proxies)
+	}
+	if status, _ = checkGroupAccess(st); status != checkSkip {
+		t.Errorf("checkGroupAccess without credentials = %v, want skip", status)
+	}
+	if status, _ = checkLogFile(st); status != checkFail {
+		t.Errorf("checkLogFile without LOG_PATH = %v, want fail", status)
+	}
+
+	os.WriteFile(tomlPath, []byte("serverAddr = \n"), 0o600)
+	if status, _ = checkFrpcToml(st); status != checkFail {
+		t.Errorf("invalid frpc.toml = %v, want fail", status)
+	}
+}
+
+func TestCheckRuleQuotaSkip(t *testing.T) {
+	if status, _ := checkRuleQuota(&doctorState{}); status != checkSkip {
+		t.Errorf("without backend = %v, want skip", status)
+	}
+	st := &doctorState{backend: &hostBackend{}, policySets: map[string]*vpc.SecurityGroupPolicySet{}}
+	if status, detail := checkRuleQuota(st); status != checkSkip || !strings.Contains(detail, "tencent") {
+		t.Errorf("non-tencent backend = %v %q, want skip", status, detail)
+	}
+}
+
+func TestFormatDoctorChecks(t *testing.T) {
+	checks := []doctorCheck{
+		{"配置", checkPass, "ok"},
+		{"密钥", checkFail, "未找到"},
+		{"安全组 API", checkSkip, "缺少密钥"},
+	}
+	got := formatDoctorChecks(checks)
+	for _, want := range []string{IconOpen + " 配置: ok", "❌ 密钥: 未找到", "1 项通过，0 项警告，1 项失败，1 项跳过"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%s", want, got)
+		}
+	}
+	if got := doctorSummary(checks[:1]); !strings.Contains(got, "全部 1 项检查通过") {
+		t.Errorf("doctorSummary = %q", got)
+	}
+}
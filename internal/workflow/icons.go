@@ -1,7 +1,10 @@
 package workflow
 
 const (
-	IconOpen    = "✅"
-	IconDrop    = "️🚫"
-	IconUnknown = "❓"
+	IconOpen     = "✅"
+	IconDrop     = "️🚫"
+	IconUnknown  = "❓"
+	IconMismatch = "⚠️"
+	IconOthers   = "👥"
+	IconGrant    = "🔗"
 )
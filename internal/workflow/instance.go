@@ -0,0 +1,231 @@
+package workflow
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/BurntSushi/toml"
+	aw "github.com/deanishe/awgo"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// instanceCacheMaxAge 实例所绑定安全组的缓存有效期
+const instanceCacheMaxAge = 24 * time.Hour
+
+// InstanceSecurityGroups 记录一次从 CVM 实例反查安全组的结果，会缓存到 Workflow 缓存目录
+type InstanceSecurityGroups struct {
+	Source           string   `json:"source"`
+	InstanceId       string   `json:"instance_id"`
+	InstanceName     string   `json:"instance_name"`
+	SecurityGroupIds []string `json:"security_group_ids"`
+}
+
+// instanceLookup 描述如何在 DescribeInstances 中定位实例
+type instanceLookup struct {
+	source string // 原始输入：实例 ID、实例名称或 frps 服务器地址
+	filter string // DescribeInstances 的过滤条件名
+	value  string
+}
+
+// resolveSecurityGroups 在未配置 SECURITY_GROUP_ID 时，根据 INSTANCE_ID 或 frpc.toml 中的 serverAddr
+// 反查 CVM 实例绑定的安全组，并写回 cfg.SecurityGroupId
+func resolveSecurityGroups(wf *aw.Workflow, cfg *config.Config, cred *config.Credential) error {
+	if cfg.SecurityGroupId != "" {
+		return nil
+	}
+
+	lookup, err := newInstanceLookup(cfg)
+	if err != nil {
+		return err
+	}
+
+	var result InstanceSecurityGroups
+	reload := func() (interface{}, error) {
+		return lookupInstanceSecurityGroups(cfg, cred, lookup)
+	}
+	if err := wf.Cache.LoadOrStoreJSON(instanceCacheName(cfg, lookup.source), instanceCacheMaxAge, reload, &result); err != nil {
+		return err
+	}
+	if len(result.SecurityGroupIds) == 0 {
+		return fmt.Errorf("实例 %s 未绑定任何安全组", result.InstanceId)
+	}
+
+	log.Info("根据 %s 反查到实例 %s(%s) 的安全组: %v", lookup.source, result.InstanceId, result.InstanceName, result.SecurityGroupIds)
+	cfg.SecurityGroupId = strings.Join(result.SecurityGroupIds, ",")
+	return nil
+}
+
+// cachedInstanceSecurityGroups 读取已缓存的反查结果，不发起任何 API 请求，也不解析 frps 服务器域名
+func cachedInstanceSecurityGroups(wf *aw.Workflow, cfg *config.Config) (*InstanceSecurityGroups, error) {
+	source, err := instanceSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var result InstanceSecurityGroups
+	if err := wf.Cache.LoadJSON(instanceCacheName(cfg, source), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// instanceCacheName 返回反查结果的缓存文件名。同一实例名称或地址在不同 profile、地域或账号下
+// 可能对应不同的实例，缓存按 profile、地域和密钥引用分开
+func instanceCacheName(cfg *config.Config, source string) string {
+	scope := strings.Join([]string{cfg.Profile, cfg.Region, cfg.Credentials, cfg.SecretId, cfg.RoleArn, source}, "\x00")
+	return fmt.Sprintf("instance-sg-%x.json", sha1.Sum([]byte(scope)))
+}
+
+// instanceSource 返回用于定位实例的原始输入：INSTANCE_ID，未设置时为 frpc.toml 中的 serverAddr（不做解析）
+func instanceSource(cfg *config.Config) (string, error) {
+	if cfg.InstanceId != "" {
+		return cfg.InstanceId, nil
+	}
+	if cfg.FrpcTomlPath == "" {
+		return "", errors.New("未配置 SECURITY_GROUP_ID 或 INSTANCE_ID")
+	}
+	var frpcConf SimpleFrpcConfig
+	if _, err := toml.DecodeFile(cfg.FrpcTomlPath, &frpcConf); err != nil {
+		return "", fmt.Errorf("frpc.toml 解析失败: %w", err)
+	}
+	if frpcConf.ServerAddr == "" {
+		return "", errors.New("未配置 SECURITY_GROUP_ID 或 INSTANCE_ID，且 frpc.toml 中没有 serverAddr")
+	}
+	return frpcConf.ServerAddr, nil
+}
+
+// newInstanceLookup 根据配置决定如何定位 CVM 实例：
+// INSTANCE_ID 为 ins- 开头时按实例 ID 查询，为 IP 时按公网 IP 查询，否则按实例名称查询；
+// 未设置 INSTANCE_ID 时使用 frpc.toml 中的 serverAddr（域名会先解析为 IP）
+func newInstanceLookup(cfg *config.Config) (*instanceLookup, error) {
+	source, err := instanceSource(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.InstanceId != "" {
+		switch {
+		case strings.HasPrefix(source, "ins-"):
+			return &instanceLookup{source: source, filter: "instance-id", value: source}, nil
+		case net.ParseIP(source) != nil:
+			return &instanceLookup{source: source, filter: "public-ip-address", value: source}, nil
+		default:
+			return &instanceLookup{source: source, filter: "instance-name", value: source}, nil
+		}
+	}
+
+	ip := source
+	if net.ParseIP(ip) == nil {
+		addrs, err := net.LookupIP(source)
+		if err != nil {
+			return nil, fmt.Errorf("解析 frps 服务器地址 %s 失败: %w", source, err)
+		}
+		ip = ""
+		for _, addr := range addrs {
+			if v4 := addr.To4(); v4 != nil {
+				ip = v4.String()
+				break
+			}
+		}
+		if ip == "" {
+			return nil, fmt.Errorf("frps 服务器地址 %s 没有 IPv4 地址", source)
+		}
+	}
+	return &instanceLookup{source: source, filter: "public-ip-address", value: ip}, nil
+}
+
+// lookupInstanceSecurityGroups 通过 DescribeInstances 定位实例，再通过 DescribeNetworkInterfaces
+// 汇总实例各弹性网卡绑定的安全组；查不到网卡时退回使用实例上的安全组
+func lookupInstanceSecurityGroups(cfg *config.Config, cred *config.Credential, lookup *instanceLookup) (*InstanceSecurityGroups, error) {
+	log.Info("开始反查 CVM 实例安全组, 过滤条件: %s=%s", lookup.filter, lookup.value)
+
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	cvmClient := common.NewCommonClient(tencentCredential(cred), cfg.Region, cpf)
+
+	request := tchttp.NewCommonRequest("cvm", "2017-03-12", "DescribeInstances")
+	err := request.SetActionParameters(map[string]interface{}{
+		"Filters": []map[string]interface{}{
+			{"Name": lookup.filter, "Values": []string{lookup.value}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	response := tchttp.NewCommonResponse()
+	if err := cvmClient.Send(request, response); err != nil {
+		return nil, sdkError("查询实例", err)
+	}
+
+	var body struct {
+		Response struct {
+			InstanceSet []struct {
+				InstanceId       string   `json:"InstanceId"`
+				InstanceName     string   `json:"InstanceName"`
+				SecurityGroupIds []string `json:"SecurityGroupIds"`
+			} `json:"InstanceSet"`
+		} `json:"Response"`
+	}
+	if err := json.Unmarshal(response.GetBody(), &body); err != nil {
+		return nil, fmt.Errorf("解析 DescribeInstances 响应失败: %w", err)
+	}
+	instances := body.Response.InstanceSet
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("未找到实例: %s=%s", lookup.filter, lookup.value)
+	}
+	if len(instances) > 1 {
+		return nil, fmt.Errorf("找到 %d 个匹配 %s=%s 的实例，请改用实例 ID", len(instances), lookup.filter, lookup.value)
+	}
+	instance := instances[0]
+
+	result := &InstanceSecurityGroups{
+		Source:       lookup.source,
+		InstanceId:   instance.InstanceId,
+		InstanceName: instance.InstanceName,
+	}
+
+	vpcClient, err := newVpcClient(cfg, cred)
+	if err != nil {
+		return nil, err
+	}
+	eniRequest := vpc.NewDescribeNetworkInterfacesRequest()
+	eniRequest.Filters = []*vpc.Filter{
+		{Name: common.StringPtr("attachment.instance-id"), Values: common.StringPtrs([]string{instance.InstanceId})},
+	}
+	eniResponse, err := vpcClient.DescribeNetworkInterfaces(eniRequest)
+	if err != nil {
+		return nil, sdkError("查询弹性网卡", err)
+	}
+
+	seen := make(map[string]bool)
+	addGroup := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			result.SecurityGroupIds = append(result.SecurityGroupIds, id)
+		}
+	}
+	if eniResponse.Response != nil {
+		for _, eni := range eniResponse.Response.NetworkInterfaceSet {
+			for _, id := range eni.GroupSet {
+				addGroup(stringValue(id))
+			}
+		}
+	}
+	if len(result.SecurityGroupIds) == 0 {
+		for _, id := range instance.SecurityGroupIds {
+			addGroup(id)
+		}
+	}
+
+	log.Info("实例 %s 绑定的安全组: %v", instance.InstanceId, result.SecurityGroupIds)
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+func TestInstanceCacheName(t *testing.T) {
+	base := config.Config{Profile: "home", Region: "ap-guangzhou"}
+	name := instanceCacheName(&base, "frps.example.com")
+	for _, changed := range []config.Config{
+		{Profile: "work", Region: "ap-guangzhou"},
+		{Profile: "home", Region: "ap-shanghai"},
+		{Profile: "home", Region: "ap-guangzhou", Credentials: "other"},
+		{Profile: "home", Region: "ap-guangzhou", RoleArn: "qcs::cam::uin/1:roleName/a"},
+	} {
+		if instanceCacheName(&changed, "frps.example.com") == name {
+			t.Errorf("%+v should not share the cache with %+v", changed, base)
+		}
+	}
+	if instanceCacheName(&base, "frps.example.com") != name {
+		t.Error("cache name should be stable")
+	}
+}
+
+func TestNewInstanceLookup(t *testing.T) {
+	for _, tt := range []struct {
+		instanceId, filter string
+	}{
+		{"ins-abcd1234", "instance-id"},
+		{"203.0.113.5", "public-ip-address"},
+		{"frps-prod", "instance-name"},
+	} {
+		lookup, err := newInstanceLookup(&config.Config{InstanceId: tt.instanceId})
+		if err != nil || lookup.filter != tt.filter || lookup.value != tt.instanceId || lookup.source != tt.instanceId {
+			t.Errorf("newInstanceLookup(%s) = %+v, %v, want filter %s", tt.instanceId, lookup, err, tt.filter)
+		}
+	}
+
+	dir := t.TempDir()
+	tomlPath := filepath.Join(dir, "frpc.toml")
+	os.WriteFile(tomlPath, []byte("serverAddr = \"198.51.100.20\"\n"), 0o600)
+	lookup, err := newInstanceLookup(&config.Config{FrpcTomlPath: tomlPath})
+	if err != nil || lookup.filter != "public-ip-address" || lookup.value != "198.51.100.20" {
+		t.Errorf("serverAddr lookup = %+v, %v", lookup, err)
+	}
+
+	// 缓存按未解析的 serverAddr 命名，读取缓存时不需要解析域名
+	os.WriteFile(tomlPath, []byte("serverAddr = \"frps.invalid\"\n"), 0o600)
+	if source, err := instanceSource(&config.Config{FrpcTomlPath: tomlPath}); err != nil || source != "frps.invalid" {
+		t.Errorf("instanceSource = %q, %v", source, err)
+	}
+
+	if _, err := newInstanceLookup(&config.Config{}); err == nil {
+		t.Error("lookup without INSTANCE_ID or frpc.toml should fail")
+	}
+}
@@ -0,0 +1,450 @@
+package workflow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// defaultIPResolvers 未配置 IP_RESOLVERS 时使用的公网 IP 来源，按优先级排列
+var defaultIPResolvers = []string{
+	"https://api.ipify.org",
+	"https://ifconfig.me/ip",
+	"https://icanhazip.com",
+	"https://ipinfo.io/ip",
+	"dns:myip.opendns.com@resolver1.opendns.com",
+	"dns-txt:o-o.myaddr.l.google.com@ns1.google.com",
+	"stun:stun.l.google.com:19302",
+}
+
+// defaultIPTimeout 获取公网 IP 的默认总超时
+const defaultIPTimeout = 5 * time.Second
+
+// IPResolver 获取本机公网 IP 的一种方式
+type IPResolver interface {
+	// Name 返回用于日志和提示的名称
+	Name() string
+	// Resolve 返回公网 IP，需遵守 ctx 的截止时间
+	Resolve(ctx context.Context) (string, error)
+}
+
+// newIPResolver 根据配置项创建 IPResolver，支持的写法：
+//   - https://api.ipify.org：HTTP 回显服务，响应体为 IP
+//   - dns:myip.opendns.com@resolver1.opendns.com：向指定 DNS 服务器查询 A 记录
+//   - dns-txt:o-o.myaddr.l.google.com@ns1.google.com：向指定 DNS 服务器查询 TXT 记录
+//   - stun:stun.l.google.com:19302：STUN Binding 请求
+//   - static:203.0.113.7：固定 IP
+func newIPResolver(spec string) (IPResolver, error) {
+	scheme, rest, _ := strings.Cut(spec, ":")
+	switch scheme {
+	case "http", "https":
+		return &httpEchoResolver{url: spec}, nil
+	case "dns", "dns-txt":
+		name, server, found := strings.Cut(rest, "@")
+		if !found || name == "" || server == "" {
+			return nil, fmt.Errorf("DNS 解析器格式应为 %s:<域名>@<DNS 服务器>: %s", scheme, spec)
+		}
+		return &dnsResolver{name: name, server: withDefaultPort(server, "53"), txt: scheme == "dns-txt"}, nil
+	case "stun":
+		if rest == "" {
+			return nil, fmt.Errorf("STUN 解析器缺少服务器地址: %s", spec)
+		}
+		return &stunResolver{server: withDefaultPort(rest, "3478")}, nil
+	case "static":
+		if ip := net.ParseIP(rest); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("无效的固定 IPv4 地址: %s", spec)
+		}
+		return staticResolver(rest), nil
+	}
+	return nil, fmt.Errorf("不支持的公网 IP 来源: %s", spec)
+}
+
+// withDefaultPort 在地址未带端口时补上默认端口
+func withDefaultPort(address, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	return net.JoinHostPort(address, port)
+}
+
+// newIPResolvers 按 IP_RESOLVERS 中的顺序创建解析器，未配置时使用默认列表
+func newIPResolvers(cfg *config.Config) ([]IPResolver, error) {
+	specs := cfg.IPResolverList()
+	if len(specs) == 0 {
+		specs = defaultIPResolvers
+	}
+	var resolvers []IPResolver
+	for _, spec := range specs {
+		resolver, err := newIPResolver(spec)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	return resolvers, nil
+}
+
+// ipTimeout 返回获取公网 IP 的总超时
+func ipTimeout(cfg *config.Config) time.Duration {
+	if cfg.IPTimeout != "" {
+		if timeout, err := time.ParseDuration(cfg.IPTimeout); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Warn("无效的 IP_TIMEOUT: %s，使用默认值 %s", cfg.IPTimeout, defaultIPTimeout)
+	}
+	return defaultIPTimeout
+}
+
+// defaultIPQuorum 未配置 IP_QUORUM 时采用公网 IP 所需的一致来源数
+const defaultIPQuorum = 2
+
+// ipQuorum 返回采用某个公网 IP 所需的一致来源数。
+// 未配置时为 defaultIPQuorum，但不超过来源数量，只配置了一个来源（如 static:）时不做一致性校验
+func ipQuorum(cfg *config.Config, resolvers int) int {
+	if cfg.IPQuorum != "" {
+		if quorum, err := strconv.Atoi(cfg.IPQuorum); err == nil && quorum > 0 {
+			return quorum
+		}
+		log.Warn("无效的 IP_QUORUM: %s，使用默认值 %d", cfg.IPQuorum, defaultIPQuorum)
+	}
+	return max(1, min(defaultIPQuorum, resolvers))
+}
+
+// ipResult 单个解析器的结果
+type ipResult struct {
+	index int
+	ip    string
+	err   error
+}
+
+// ipConsensus 公网 IP 的查询结果，Votes 记录每个 IP 由哪些来源返回
+type ipConsensus struct {
+	IP     string
+	Quorum int
+	Votes  map[string][]string
+}
+
+// majority 返回得票最多的 IP，票数需达到 quorum，且在尚未返回的 pending 个来源全部投给其他 IP 时仍多于其他任何 IP
+func (c ipConsensus) majority(quorum, pending int) (string, bool) {
+	best, bestVotes, runnerUp := "", 0, 0
+	for ip, names := range c.Votes {
+		switch {
+		case len(names) > bestVotes:
+			best, bestVotes, runnerUp = ip, len(names), bestVotes
+		case len(names) > runnerUp:
+			runnerUp = len(names)
+		}
+	}
+	return best, bestVotes >= quorum && bestVotes > runnerUp+pending
+}
+
+// conflicts 返回各来源结果不一致时的说明，一致时返回空字符串
+func (c ipConsensus) conflicts() string {
+	if len(c.Votes) <= 1 {
+		return ""
+	}
+	ips := make([]string, 0, len(c.Votes))
+	for ip := range c.Votes {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	parts := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		parts = append(parts, fmt.Sprintf("%s (%s)", ip, strings.Join(c.Votes[ip], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// resolvePublicIP 并发调用所有解析器。
+// quorum 为 1 时按配置顺序取结果：排在前面的解析器都失败后才采用后面的结果，超时后采用已返回的最靠前的结果；
+// quorum 大于 1 时，得票最多且至少 quorum 个来源返回的 IP 才采用，否则返回错误，防止单个回显服务被劫持时开放错误的 IP；
+// 其余来源的结果已无法改变多数时提前返回，不必等待无法访问的来源超时。
+func resolvePublicIP(ctx context.Context, resolvers []IPResolver, timeout time.Duration, quorum int) (ipConsensus, error) {
+	consensus := ipConsensus{Quorum: quorum, Votes: make(map[string][]string)}
+	if len(resolvers) == 0 {
+		return consensus, errors.New("未配置任何公网 IP 来源")
+	}
+	if quorum > len(resolvers) {
+		return consensus, fmt.Errorf("IP_QUORUM (%d) 大于公网 IP 来源数量 (%d)", quorum, len(resolvers))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	results := make(chan ipResult, len(resolvers))
+	for i, resolver := range resolvers {
+		go func(i int, resolver IPResolver) {
+			ip, err := resolver.Resolve(ctx)
+			if err == nil && net.ParseIP(ip) == nil {
+				err = fmt.Errorf("获取到无效的IP地址: %s", ip)
+			} else if err == nil && net.ParseIP(ip).To4() == nil {
+				// 安全组规则只支持 IPv4，双栈网络下返回的 IPv6 地址不参与统计
+				err = fmt.Errorf("获取到的不是 IPv4 地址: %s", ip)
+			}
+			results <- ipResult{index: i, ip: ip, err: err}
+		}(i, resolver)
+	}
+
+	done := make([]*ipResult, len(resolvers))
+	var failures []string
+collect:
+	for received := 0; received < len(resolvers); {
+		select {
+		case result := <-results:
+			received++
+			done[result.index] = &result
+			name := resolvers[result.index].Name()
+			if result.err != nil {
+				log.Warn("从 %s 获取IP失败: %v", name, result.err)
+				failures = append(failures, fmt.Sprintf("%s: %v", name, result.err))
+				break
+			}
+			consensus.Votes[result.ip] = append(consensus.Votes[result.ip], name)
+		case <-ctx.Done():
+			failures = append(failures, fmt.Sprintf("等待超过 %s", timeout))
+			received = len(resolvers)
+		}
+		if quorum > 1 {
+			// 先凑够票数的来源不代表没有分歧，剩余来源仍可能推翻结果时继续等待
+			if _, ok := consensus.majority(quorum, len(resolvers)-received); ok {
+				break collect
+			}
+			continue
+		}
+
+		// 按顺序检查：遇到未返回的解析器则继续等待，遇到成功的则采用
+		for i, result := range done {
+			if result == nil {
+				if ctx.Err() == nil {
+					break
+				}
+				continue
+			}
+			if result.err == nil {
+				log.Info("成功从 %s 获取公网IP: %s", resolvers[i].Name(), result.ip)
+				consensus.IP = result.ip
+				return consensus, nil
+			}
+		}
+	}
+	if quorum > 1 {
+		if ip, ok := consensus.majority(quorum, 0); ok {
+			consensus.IP = ip
+			if conflicts := consensus.conflicts(); conflicts != "" {
+				log.Warn("各来源结果不一致，采用多数来源返回的公网IP %s: %s", ip, conflicts)
+			} else {
+				log.Info("%d 个来源一致返回公网IP: %s", len(consensus.Votes[ip]), ip)
+			}
+			return consensus, nil
+		}
+		if conflicts := consensus.conflicts(); conflicts != "" {
+			failures = append([]string{"各来源结果不一致: " + conflicts}, failures...)
+		}
+		return consensus, fmt.Errorf("没有至少 %d 个来源返回一致且占多数的公网IP: %s", quorum, strings.Join(failures, "; "))
+	}
+	return consensus, fmt.Errorf("无法获取公网IP: %s", strings.Join(failures, "; "))
+}
+
+// ipv4HTTPClient 只通过 IPv4 连接回显服务，双栈网络下回显服务才会返回 IPv4 地址
+var ipv4HTTPClient = &http.Client{Transport: &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, strings.Replace(network, "tcp", "tcp4", 1), addr)
+	},
+}}
+
+// httpEchoResolver 通过 HTTP 回显服务获取公网 IP
+type httpEchoResolver struct {
+	url string
+}
+
+func (r *httpEchoResolver) Name() string {
+	return r.url
+}
+
+func (r *httpEchoResolver) Resolve(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := ipv4HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HTTP 状态码非正常: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// dnsResolver 向指定 DNS 服务器查询特殊域名获取公网 IP（如 OpenDNS 的 myip.opendns.com）
+type dnsResolver struct {
+	name   string
+	server string
+	txt    bool
+}
+
+func (r *dnsResolver) Name() string {
+	return "dns:" + r.name + "@" + r.server
+}
+
+func (r *dnsResolver) Resolve(ctx context.Context) (string, error) {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			// 经 IPv4 查询，o-o.myaddr 等按查询来源返回地址的服务才会返回 IPv4 地址
+			var d net.Dialer
+			return d.DialContext(ctx, network+"4", r.server)
+		},
+	}
+	name := r.name
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+
+	if r.txt {
+		records, err := resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		for _, record := range records {
+			if ip := strings.TrimSpace(record); net.ParseIP(ip) != nil {
+				return ip, nil
+			}
+		}
+		return "", fmt.Errorf("TXT 记录中没有 IP: %v", records)
+	}
+
+	addrs, err := resolver.LookupIP(ctx, "ip4", name)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", errors.New("没有 A 记录")
+	}
+	return addrs[0].String(), nil
+}
+
+// STUN 协议常量（RFC 5389）
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunMappedAddress    = 0x0001
+	stunXorMappedAddress = 0x0020
+)
+
+// stunResolver 通过 STUN Binding 请求获取 NAT 映射后的公网 IP
+type stunResolver struct {
+	server string
+}
+
+func (r *stunResolver) Name() string {
+	return "stun:" + r.server
+}
+
+func (r *stunResolver) Resolve(ctx context.Context) (string, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp4", r.server)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+
+	response := make([]byte, 1024)
+	n, err := conn.Read(response)
+	if err != nil {
+		return "", err
+	}
+	return parseSTUNResponse(response[:n], request[8:20])
+}
+
+// parseSTUNResponse 从 Binding 成功响应中解析 XOR-MAPPED-ADDRESS 或 MAPPED-ADDRESS
+func parseSTUNResponse(msg, transactionID []byte) (string, error) {
+	if len(msg) < 20 || binary.BigEndian.Uint16(msg[0:2]) != stunBindingSuccess {
+		return "", errors.New("不是 STUN Binding 成功响应")
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || string(msg[8:20]) != string(transactionID) {
+		return "", errors.New("STUN 响应的事务 ID 不匹配")
+	}
+
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	attrs := msg[20:]
+	if len(attrs) < length {
+		return "", errors.New("STUN 响应长度错误")
+	}
+	attrs = attrs[:length]
+
+	mapped := ""
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLen {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		// 属性按 4 字节对齐，最后一个属性可能没有填充
+		attrs = attrs[4+min((attrLen+3)/4*4, len(attrs)-4):]
+
+		if len(value) < 8 || value[1] != 0x01 { // 只处理 IPv4
+			continue
+		}
+		ip := net.IP(append([]byte(nil), value[4:8]...))
+		switch attrType {
+		case stunXorMappedAddress:
+			cookie := make([]byte, 4)
+			binary.BigEndian.PutUint32(cookie, stunMagicCookie)
+			for i := range ip {
+				ip[i] ^= cookie[i]
+			}
+			return ip.String(), nil
+		case stunMappedAddress:
+			mapped = ip.String()
+		}
+	}
+	if mapped != "" {
+		return mapped, nil
+	}
+	return "", errors.New("STUN 响应中没有映射地址")
+}
+
+// staticResolver 返回固定 IP，适用于出口 IP 固定的网络
+type staticResolver string
+
+func (r staticResolver) Name() string {
+	return "static:" + string(r)
+}
+
+func (r staticResolver) Resolve(context.Context) (string, error) {
+	return string(r), nil
+}
@@ -0,0 +1,299 @@
+package workflow
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+// serveUDP 在本地 UDP 端口上用 handle 应答每个请求，测试结束时关闭
+func serveUDP(t *testing.T, handle func(request []byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := handle(append([]byte(nil), buf[:n]...)); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// fakeDNSAnswer 应答 A 查询返回 ip，应答 TXT 查询返回 ip 的文本
+func fakeDNSAnswer(ip string) func([]byte) []byte {
+	return func(query []byte) []byte {
+		// 跳过头部和 QNAME，读取 QTYPE
+		end := 12
+		for end < len(query) && query[end] != 0 {
+			end += int(query[end]) + 1
+		}
+		if end+5 > len(query) {
+			return nil
+		}
+		qtype := binary.BigEndian.Uint16(query[end+1 : end+3])
+		question := query[12 : end+5]
+
+		var rdata []byte
+		switch qtype {
+		case 1: // A
+			rdata = net.ParseIP(ip).To4()
+		case 16: // TXT
+			rdata = append([]byte{byte(len(ip))}, ip...)
+		default:
+			return nil
+		}
+
+		response := append([]byte(nil), query[0:2]...)
+		response = append(response, 0x81, 0x80, 0, 1, 0, 1, 0, 0, 0, 0)
+		response = append(response, question...)
+		response = append(response, 0xc0, 12) // 指向问题中的域名
+		response = binary.BigEndian.AppendUint16(response, qtype)
+		response = append(response, 0, 1, 0, 0, 0, 60)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(rdata)))
+		return append(response, rdata...)
+	}
+}
+
+// fakeSTUNAnswer 应答 Binding 请求，返回 ip 的 XOR-MAPPED-ADDRESS
+func fakeSTUNAnswer(ip string) func([]byte) []byte {
+	return func(request []byte) []byte {
+		if len(request) < 20 || binary.BigEndian.Uint16(request[0:2]) != stunBindingRequest {
+			return nil
+		}
+		value := []byte{0, 0x01, 0, 0}
+		binary.BigEndian.PutUint16(value[2:4], 40000^uint16(stunMagicCookie>>16))
+		addr := binary.BigEndian.Uint32(net.ParseIP(ip).To4()) ^ stunMagicCookie
+		value = binary.BigEndian.AppendUint32(value, addr)
+
+		response := binary.BigEndian.AppendUint16(nil, stunBindingSuccess)
+		response = binary.BigEndian.AppendUint16(response, uint16(4+len(value)))
+		response = append(response, request[4:20]...)
+		response = binary.BigEndian.AppendUint16(response, stunXorMappedAddress)
+		response = binary.BigEndian.AppendUint16(response, uint16(len(value)))
+		return append(response, value...)
+	}
+}
+
+func TestIPResolvers(t *testing.T) {
+	echo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "198.51.100.1")
+	}))
+	defer echo.Close()
+	dnsServer := serveUDP(t, fakeDNSAnswer("198.51.100.2"))
+	stunServer := serveUDP(t, fakeSTUNAnswer("198.51.100.3"))
+
+	for spec, want := range map[string]string{
+		echo.URL:                                "198.51.100.1",
+		"dns:myip.example.com@" + dnsServer:     "198.51.100.2",
+		"dns-txt:myip.example.com@" + dnsServer: "198.51.100.2",
+		"stun:" + stunServer:                    "198.51.100.3",
+		"static:198.51.100.4":                   "198.51.100.4",
+	} {
+		resolver, err := newIPResolver(spec)
+		if err != nil {
+			t.Fatalf("newIPResolver(%s) failed: %v", spec, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		got, err := resolver.Resolve(ctx)
+		cancel()
+		if err != nil || got != want {
+			t.Errorf("%s resolved %q, %v, want %s", resolver.Name(), got, err, want)
+		}
+	}
+
+	for _, bad := range []string{"ftp://example.com", "dns:myip.example.com", "stun:", "static:not-an-ip", "static:2001:db8::1"} {
+		if _, err := newIPResolver(bad); err == nil {
+			t.Errorf("newIPResolver(%s) should fail", bad)
+		}
+	}
+}
+
+func TestParseSTUNResponseMalformed(t *testing.T) {
+	request := make([]byte, 20)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	copy(request[8:20], "transaction1")
+	valid := fakeSTUNAnswer("198.51.100.3")(request)
+	if got, err := parseSTUNResponse(valid, request[8:20]); err != nil || got != "198.51.100.3" {
+		t.Fatalf("parseSTUNResponse(valid) = %q, %v", got, err)
+	}
+
+	// MAPPED-ADDRESS 之后的最后一个属性长度不是 4 的倍数且没有填充
+	unpadded := append([]byte(nil), valid[:20]...)
+	unpadded = binary.BigEndian.AppendUint16(unpadded, stunMappedAddress)
+	unpadded = binary.BigEndian.AppendUint16(unpadded, 8)
+	unpadded = append(unpadded, 0, 0x01, 0x9c, 0x40, 198, 51, 100, 4)
+	unpadded = binary.BigEndian.AppendUint16(unpadded, 0x8022) // SOFTWARE
+	unpadded = binary.BigEndian.AppendUint16(unpadded, 3)
+	unpadded = append(unpadded, "abc"...)
+	binary.BigEndian.PutUint16(unpadded[2:4], uint16(len(unpadded)-20))
+
+	truncated := append([]byte(nil), valid[:len(valid)-2]...)
+	binary.BigEndian.PutUint16(truncated[2:4], uint16(len(truncated)-20))
+
+	for name, tt := range map[string]struct {
+		msg  []byte
+		want string
+	}{
+		"unpadded last attribute": {unpadded, "198.51.100.4"},
+		"truncated attribute":     {truncated, ""},
+		"short header":            {valid[:12], ""},
+		"length beyond message":   {append(append([]byte(nil), valid[:2]...), append([]byte{0xff, 0xff}, valid[4:]...)...), ""},
+	} {
+		got, err := parseSTUNResponse(tt.msg, request[8:20])
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("%s: parseSTUNResponse = %q, %v", name, got, err)
+		}
+	}
+}
+
+// fakeResolver 在 delay 后返回 ip 或 err
+type fakeResolver struct {
+	name  string
+	ip    string
+	err   error
+	delay time.Duration
+}
+
+func (r fakeResolver) Name() string {
+	return r.name
+}
+
+func (r fakeResolver) Resolve(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(r.delay):
+		return r.ip, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestResolvePublicIPOrder(t *testing.T) {
+	failed := fakeResolver{name: "failed", err: errors.New("unreachable")}
+	slow := fakeResolver{name: "slow", ip: "198.51.100.1", delay: 50 * time.Millisecond}
+	fast := fakeResolver{name: "fast", ip: "198.51.100.2"}
+	hung := fakeResolver{name: "hung", ip: "198.51.100.3", delay: time.Hour}
+
+	tests := []struct {
+		name      string
+		resolvers []IPResolver
+		want      string
+	}{
+		{"前面的来源优先", []IPResolver{slow, fast}, "198.51.100.1"},
+		{"前面的来源失败后采用后面的", []IPResolver{failed, fast}, "198.51.100.2"},
+		{"超时后采用已返回的结果", []IPResolver{hung, fast}, "198.51.100.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolvePublicIP(context.Background(), tt.resolvers, 200*time.Millisecond, 1)
+			if err != nil || got.IP != tt.want {
+				t.Errorf("resolvePublicIP = %q, %v, want %s", got.IP, err, tt.want)
+			}
+		})
+	}
+
+	start := time.Now()
+	if _, err := resolvePublicIP(context.Background(), []IPResolver{failed, hung}, 100*time.Millisecond, 1); err == nil {
+		t.Error("resolvePublicIP should fail when no resolver succeeds")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolvePublicIP took %s, should stop at the timeout", elapsed)
+	}
+	if _, err := resolvePublicIP(context.Background(), []IPResolver{fakeResolver{name: "bad", ip: "not-an-ip"}}, time.Second, 1); err == nil {
+		t.Error("an invalid IP should be rejected")
+	}
+}
+
+func TestResolvePublicIPQuorum(t *testing.T) {
+	honest := func(name string) IPResolver { return fakeResolver{name: name, ip: "198.51.100.1"} }
+	// 被劫持的回显服务返回门户的地址，且比其他来源更快
+	liar := fakeResolver{name: "portal", ip: "10.0.0.1"}
+
+	got, err := resolvePublicIP(context.Background(), []IPResolver{liar, honest("a"), honest("b")}, time.Second, 2)
+	if err != nil || got.IP != "198.51.100.1" {
+		t.Fatalf("resolvePublicIP = %+v, %v, want 198.51.100.1", got, err)
+	}
+
+	got, err = resolvePublicIP(context.Background(), []IPResolver{liar, honest("a")}, time.Second, 2)
+	if err == nil {
+		t.Fatalf("resolvePublicIP without quorum = %+v, want error", got)
+	}
+	if want := "10.0.0.1 (portal); 198.51.100.1 (a)"; got.conflicts() != want {
+		t.Errorf("conflicts = %q, want %q", got.conflicts(), want)
+	}
+
+	// 凑够票数后剩余来源仍可能推翻结果时继续等待，较慢的不一致结果也会被统计
+	slowLiar := func(name string) IPResolver {
+		return fakeResolver{name: name, ip: "10.0.0.1", delay: 50 * time.Millisecond}
+	}
+	got, err = resolvePublicIP(context.Background(), []IPResolver{honest("a"), honest("b"), slowLiar("p1"), slowLiar("p2")}, time.Second, 2)
+	if err == nil || got.conflicts() == "" {
+		t.Errorf("resolvePublicIP = %+v, %v, want error with conflicts", got, err)
+	}
+
+	// 剩余来源已无法推翻结果时不等待无法访问的来源超时
+	hung := fakeResolver{name: "hung", ip: "10.0.0.1", delay: time.Hour}
+	start := time.Now()
+	got, err = resolvePublicIP(context.Background(), []IPResolver{honest("a"), hung, honest("b"), honest("c")}, 5*time.Second, 2)
+	if err != nil || got.IP != "198.51.100.1" {
+		t.Errorf("resolvePublicIP = %+v, %v, want 198.51.100.1", got, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("resolvePublicIP took %s, should return once the majority is settled", elapsed)
+	}
+
+	// 双栈网络下返回的 IPv6 地址不参与统计
+	v6 := fakeResolver{name: "v6", ip: "2001:db8::1"}
+	got, err = resolvePublicIP(context.Background(), []IPResolver{v6, honest("a"), honest("b")}, time.Second, 2)
+	if err != nil || got.IP != "198.51.100.1" || got.conflicts() != "" {
+		t.Errorf("resolvePublicIP = %+v, %v, want 198.51.100.1 without conflicts", got, err)
+	}
+
+	// 两个 IP 票数相同时不采用任何一个
+	liar2 := fakeResolver{name: "portal2", ip: "10.0.0.1"}
+	if got, err = resolvePublicIP(context.Background(), []IPResolver{liar, liar2, honest("a"), honest("b")}, time.Second, 2); err == nil {
+		t.Errorf("tied votes = %+v, want error", got)
+	}
+
+	if _, err := resolvePublicIP(context.Background(), []IPResolver{honest("a")}, time.Second, 2); err == nil {
+		t.Error("a quorum larger than the number of resolvers should be rejected")
+	}
+	if got := (ipConsensus{Votes: map[string][]string{"198.51.100.1": {"a", "b"}}}).conflicts(); got != "" {
+		t.Errorf("agreeing resolvers reported conflicts: %q", got)
+	}
+}
+
+func TestIPQuorum(t *testing.T) {
+	for _, tt := range []struct {
+		quorum    string
+		resolvers int
+		want      int
+	}{
+		{"", len(defaultIPResolvers), defaultIPQuorum},
+		{"", 1, 1},
+		{"3", 7, 3},
+		{"0", 7, defaultIPQuorum},
+	} {
+		if got := ipQuorum(&config.Config{IPQuorum: tt.quorum}, tt.resolvers); got != tt.want {
+			t.Errorf("ipQuorum(%q, %d) = %d, want %d", tt.quorum, tt.resolvers, got, tt.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package workflow
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
@@ -32,12 +33,6 @@ func List(wf *aw.Workflow) {
 		wf.SendFeedback()
 		return
 	}
-	if cfg.SecurityGroupId == "" {
-		log.Error("安全组 ID 未配置")
-		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置").Valid(false).Icon(aw.IconWarning)
-		wf.SendFeedback()
-		return
-	}
 	if cfg.Region == "" {
 		log.Error("腾讯云区域未配置")
 		wf.NewItem("腾讯云区域未配置").Subtitle("请使用 'frp config set_region' 设置").Valid(false).Icon(aw.IconWarning)
@@ -45,23 +40,17 @@ func List(wf *aw.Workflow) {
 		return
 	}
 
-	secretID, err := config.GetSecretId()
-	if err != nil {
-		log.Error("获取 SecretId 失败: %v", err)
-		wf.NewItem("获取 SecretId 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	cred, ok := loadCredentials(wf, cfg)
+	if !ok {
 		return
 	}
-	secretKey, err := config.GetSecretKey()
-	if err != nil {
-		log.Error("获取 SecretKey 失败: %v", err)
-		wf.NewItem("获取 SecretKey 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	if !checkRegion(wf, cfg, cred) {
 		return
 	}
-	if secretID == "" || secretKey == "" {
-		log.Error("SecretId 或 SecretKey 未配置")
-		wf.NewItem("SecretId 或 SecretKey 未配置").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconWarning)
+	backend, err := newBackend(wf, cfg, cred)
+	if err != nil {
+		log.Error("安全组 ID 未配置: %v", err)
+		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置或配置 INSTANCE_ID: " + err.Error()).Valid(false).Icon(aw.IconWarning)
 		wf.SendFeedback()
 		return
 	}
@@ -73,14 +62,20 @@ func List(wf *aw.Workflow) {
 		return
 	}
 
-	// 获取所有规则（包括ACCEPT和DROP）
-	allRules, err := getAllSecurityGroupRules(cfg, secretID, secretKey)
+	// 获取所有规则（包括ACCEPT和DROP），按安全组分别保存以便检查各安全组状态是否一致
+	rulesByGroup, err := getSecurityGroupRulesByGroup(backend)
 	if err != nil {
 		log.Error("获取所有安全组规则失败: %v", err)
 		wf.NewItem("获取所有安全组规则失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
+	securityGroupIds := backend.Groups()
+	// 本人的规则按 frpc.toml 中的服务展示，其他人的规则和本人开放给其他来源的授权单独展示在后面
+	owner := ruleOwner(cfg)
+	_, othersRules := splitRulesByOwner(mergeGroupRules(securityGroupIds, rulesByGroup), owner)
+	rulesByGroup = ownRulesByGroup(rulesByGroup, owner)
+	allRules := mergeGroupRules(securityGroupIds, rulesByGroup)
 
 	// 过滤出 Action == "ACCEPT" 的规则，生成 openedPorts
 	openedPorts := make(map[string]FetchedRuleInfo)
@@ -91,11 +86,21 @@ func List(wf *aw.Workflow) {
 	}
 
 	// 新增：展示本机外网IP
-	ip, err := getCurrentPublicIP()
+	consensus, err := lookupPublicIP(cfg)
 	if err != nil {
 		wf.NewItem("本机外网IP获取失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconWarning)
 	} else {
-		wf.NewItem("本机外网IP: " + ip).Subtitle("用于安全组规则开放").Valid(false).Icon(aw.IconInfo)
+		wf.NewItem("本机外网IP: " + consensus.IP).Subtitle("用于安全组规则开放").Valid(false).Icon(aw.IconInfo)
+	}
+	if conflicts := consensus.conflicts(); conflicts != "" {
+		log.Warn("公网IP来源结果不一致: %s", conflicts)
+		wf.NewItem("⚠️ 公网IP来源结果不一致").Subtitle(conflicts).Valid(false).Icon(aw.IconWarning)
+	}
+
+	// 来源在地址簿中时显示名称
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		log.Warn("%v", err)
 	}
 
 	for _, p := range frpcConf.Proxies { // 遍历 Proxies 切片
@@ -131,13 +136,13 @@ func List(wf *aw.Workflow) {
 		var policyDescription, lastMod string
 		if isDrop {
 			displayTitle = IconDrop + " " + title
-			subtitle += "IP: " + dropRule.CidrBlock
+			subtitle += "IP: " + book.label(dropRule)
 			subtitle += " 已拒绝(DROP)"
 			policyDescription = dropRule.PolicyDescription
 			lastMod = dropRule.ModifyTime
 		} else if isOpen {
 			displayTitle = IconOpen + " " + title
-			subtitle += "IP: " + openRule.CidrBlock
+			subtitle += "IP: " + book.label(openRule)
 			subtitle += " 已开放"
 			policyDescription = openRule.PolicyDescription
 			lastMod = openRule.ModifyTime
@@ -148,6 +153,11 @@ func List(wf *aw.Workflow) {
 		if lastMod != "" {
 			lastMod = "最后修改时间: " + lastMod
 		}
+		// 服务在多个安全组中的状态不一致时给出警告
+		if summary, consistent := describeGroupStates(securityGroupIds, rulesByGroup, actualServiceName); !consistent {
+			displayTitle = IconMismatch + " " + title
+			subtitle += " | 安全组状态不一致: " + summary
+		}
 
 		log.Debug("actualServiceName: %s, p.Type: %s, p.RemotePort: %d, policyDescription: %s, lastMod: %s", actualServiceName, p.Type, p.RemotePort, policyDescription, lastMod)
 		item := wf.NewItem(displayTitle).
@@ -158,5 +168,36 @@ func List(wf *aw.Workflow) {
 			Subtitle(fmt.Sprintf("%s %s", policyDescription, lastMod))
 	}
 
+	showOthersRules(wf, othersRules, book, owner)
+
 	wf.SendFeedback()
 }
+
+// showOthersRules 展示共用安全组中其他人开放的规则，以及本人开放给其他来源的授权，每个授权单独一行
+func showOthersRules(wf *aw.Workflow, rules map[string]FetchedRuleInfo, book addressBook, self string) {
+	keys := make([]string, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rule := rules[key]
+		_, owner := splitRuleKey(key)
+		icon := IconOthers
+		if owner == self {
+			icon = IconGrant
+		}
+		if owner == "" {
+			owner = "未标记（旧规则）"
+		}
+		state := "已开放"
+		if rule.Action == "DROP" {
+			state = "已拒绝(DROP)"
+		}
+		item := wf.NewItem(fmt.Sprintf("%s %s [%s]", icon, ruleTitle(key), rule.Protocol)).
+			Subtitle(fmt.Sprintf("属主: %s | 远程端口:%s  本地端口:%s | IP: %s %s", owner, rule.Port, rule.LocalPort, book.label(rule), state)).
+			Valid(false)
+		item.NewModifier(aw.ModCmd).
+			Subtitle(fmt.Sprintf("%s 最后修改时间: %s", rule.PolicyDescription, rule.ModifyTime))
+	}
+}
@@ -1,11 +1,12 @@
 package workflow
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net"
-	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
@@ -14,8 +15,6 @@ import (
 	"github.com/BurntSushi/toml"
 	aw "github.com/deanishe/awgo"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
 )
 
@@ -40,12 +39,6 @@ func OpenCommand(wf *aw.Workflow) {
 		wf.SendFeedback()
 		return
 	}
-	if cfg.SecurityGroupId == "" {
-		log.Error("安全组 ID 未配置")
-		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置").Valid(false).Icon(aw.IconWarning)
-		wf.SendFeedback()
-		return
-	}
 	if cfg.Region == "" {
 		log.Error("腾讯云区域未配置")
 		wf.NewItem("腾讯云区域未配置").Subtitle("请使用 'frp config set_region' 设置").Valid(false).Icon(aw.IconWarning)
@@ -53,23 +46,17 @@ func OpenCommand(wf *aw.Workflow) {
 		return
 	}
 
-	secretID, err := config.GetSecretId()
-	if err != nil {
-		log.Error("获取 SecretId 失败: %v", err)
-		wf.NewItem("获取 SecretId 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	cred, ok := loadCredentials(wf, cfg)
+	if !ok {
 		return
 	}
-	secretKey, err := config.GetSecretKey()
-	if err != nil {
-		log.Error("获取 SecretKey 失败: %v", err)
-		wf.NewItem("获取 SecretKey 失败").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconError)
-		wf.SendFeedback()
+	if !checkRegion(wf, cfg, cred) {
 		return
 	}
-	if secretID == "" || secretKey == "" {
-		log.Error("SecretId 或 SecretKey 未配置")
-		wf.NewItem("SecretId 或 SecretKey 未配置").Subtitle("请使用 'frp config setup_keys' 设置").Valid(false).Icon(aw.IconWarning)
+	backend, err := newBackend(wf, cfg, cred)
+	if err != nil {
+		log.Error("安全组 ID 未配置: %v", err)
+		wf.NewItem("安全组 ID 未配置").Subtitle("请使用 'frp config set_sgid' 设置或配置 INSTANCE_ID: " + err.Error()).Valid(false).Icon(aw.IconWarning)
 		wf.SendFeedback()
 		return
 	}
@@ -82,16 +69,20 @@ func OpenCommand(wf *aw.Workflow) {
 	}
 
 	log.Info("frpcConf.proxies: %v", frpcConf.Proxies)
-	allRules, err := getAllSecurityGroupRules(cfg, secretID, secretKey)
+	rulesByGroup, err := getSecurityGroupRulesByGroup(backend)
 	if err != nil {
 		log.Error("获取所有安全组规则失败: %v", err)
 		wf.NewItem("获取所有安全组规则失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
+	securityGroupIds := backend.Groups()
+	rulesByGroup = ownRulesByGroup(rulesByGroup, ruleOwner(cfg))
+	allRules := mergeGroupRules(securityGroupIds, rulesByGroup)
+	// 只有在所有安全组中均已开放才视为已开放
 	openedRules := make(map[string]FetchedRuleInfo)
 	for proxyName, v := range allRules {
-		if v.Action == "ACCEPT" {
+		if isOpenInAllGroups(securityGroupIds, rulesByGroup, proxyName) {
 			openedRules[proxyName] = v
 		}
 	}
@@ -105,6 +96,11 @@ func OpenCommand(wf *aw.Workflow) {
 		return
 	}
 
+	book, err := loadAddressBook(wf)
+	if err != nil {
+		log.Warn("%v", err)
+	}
+
 	hasUnopened := false
 	for _, p := range frpcConf.Proxies {
 		actualServiceName := p.Name
@@ -128,13 +124,14 @@ func OpenCommand(wf *aw.Workflow) {
 		if !isOpen || hasDropRule {
 			hasUnopened = true
 			title := fmt.Sprintf("%s [%s]", actualServiceName, strings.ToUpper(p.Type))
+			arg := fmt.Sprintf("open %s|%s|%d|%d", actualServiceName, strings.ToUpper(p.Type), p.RemotePort, p.LocalPort)
 			subtitle := ""
 			if hasDropRule {
 				subtitle = fmt.Sprintf("远程端口:%d  本地端口:%d | 状态: 已拒绝(DROP)", p.RemotePort, p.LocalPort)
 				displayTitle := IconDrop + " " + title
 				item := wf.NewItem(displayTitle).
 					Subtitle(subtitle).
-					Arg(fmt.Sprintf("open %s|%s|%d|%d", actualServiceName, strings.ToUpper(p.Type), p.RemotePort, p.LocalPort)).
+					Arg(arg).
 					Valid(true).
 					Icon(aw.IconWarning).
 					Var("action", "open")
@@ -150,12 +147,13 @@ func OpenCommand(wf *aw.Workflow) {
 				}
 				item.NewModifier(aw.ModCmd).
 					Subtitle(modSubtitle)
+				addAddressBookModifiers(item, arg, book)
 			} else if isOpen {
 				subtitle = fmt.Sprintf("远程端口:%d  本地端口:%d | 状态: 已开放", p.RemotePort, p.LocalPort)
 				displayTitle := IconOpen + " " + title
 				item := wf.NewItem(displayTitle).
 					Subtitle(subtitle).
-					Arg(fmt.Sprintf("open %s|%s|%d|%d", actualServiceName, strings.ToUpper(p.Type), p.RemotePort, p.LocalPort)).
+					Arg(arg).
 					Valid(true).
 					Icon(aw.IconWarning).
 					Var("action", "open")
@@ -171,18 +169,23 @@ func OpenCommand(wf *aw.Workflow) {
 				}
 				item.NewModifier(aw.ModCmd).
 					Subtitle(modSubtitle)
+				addAddressBookModifiers(item, arg, book)
 			} else {
 				subtitle = fmt.Sprintf("远程端口:%d  本地端口:%d | 状态: 未开放", p.RemotePort, p.LocalPort)
+				if summary, consistent := describeGroupStates(securityGroupIds, rulesByGroup, actualServiceName); !consistent {
+					subtitle = fmt.Sprintf("远程端口:%d  本地端口:%d | 状态: 部分开放(%s)", p.RemotePort, p.LocalPort, summary)
+				}
 				displayTitle := IconUnknown + " " + title
 				item := wf.NewItem(displayTitle).
 					Subtitle(subtitle).
-					Arg(fmt.Sprintf("open %s|%s|%d|%d", actualServiceName, strings.ToUpper(p.Type), p.RemotePort, p.LocalPort)).
+					Arg(arg).
 					Valid(true).
 					Icon(aw.IconWarning).
 					Var("action", "open")
 				modSubtitle := "无描述信息"
 				item.NewModifier(aw.ModCmd).
 					Subtitle(modSubtitle)
+				addAddressBookModifiers(item, arg, book)
 			}
 		}
 	}
@@ -194,9 +197,64 @@ func OpenCommand(wf *aw.Workflow) {
 	wf.SendFeedback()
 }
 
-// OpenPort 开放指定的端口
+// defaultMinCIDRPrefix 未配置 MIN_CIDR_PREFIX 时，显式指定的网段允许的最短前缀
+const defaultMinCIDRPrefix = 16
+
+// minCIDRPrefix 返回显式指定来源时允许的最短前缀长度，即最宽能开放到多大的网段
+func minCIDRPrefix(cfg *config.Config) int {
+	if cfg.MinCIDRPrefix != "" {
+		if prefix, err := strconv.Atoi(cfg.MinCIDRPrefix); err == nil && prefix >= 1 && prefix <= 32 {
+			return prefix
+		}
+		log.Warn("无效的 MIN_CIDR_PREFIX: %s，使用默认值 %d", cfg.MinCIDRPrefix, defaultMinCIDRPrefix)
+	}
+	return defaultMinCIDRPrefix
+}
+
+// parseOpenOptions 解析 open 参数中端口之后的可选项，目前支持 ip=<IP 或 CIDR>
+func parseOpenOptions(options []string) (source string, err error) {
+	for _, option := range options {
+		switch {
+		case strings.HasPrefix(option, "ip="):
+			source = strings.TrimSpace(strings.TrimPrefix(option, "ip="))
+			if source == "" {
+				return "", errors.New("ip= 后缺少 IP 或网段")
+			}
+		default:
+			return "", fmt.Errorf("未知参数: %s，支持 ip=<IP 或网段>", option)
+		}
+	}
+	return source, nil
+}
+
+// normalizeSource 校验显式指定的 IP 或网段并转换为 CIDR，拒绝比 /minPrefix 更宽的网段
+func normalizeSource(source string, minPrefix int) (string, error) {
+	if !strings.Contains(source, "/") {
+		ip := net.ParseIP(source)
+		if ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("无效的 IPv4 地址: %s", source)
+		}
+		return ip.To4().String() + "/32", nil
+	}
+
+	ip, network, err := net.ParseCIDR(source)
+	if err != nil || ip.To4() == nil {
+		return "", fmt.Errorf("无效的 IPv4 网段: %s", source)
+	}
+	ones, _ := network.Mask.Size()
+	if ones == 0 || ones < minPrefix {
+		return "", fmt.Errorf("网段 %s 过宽，最多允许 /%d（可通过 MIN_CIDR_PREFIX 调整）", source, minPrefix)
+	}
+	if network.String() != source {
+		log.Info("网段 %s 含主机位，按 %s 开放", source, network.String())
+	}
+	return network.String(), nil
+}
+
+// OpenPort 开放指定的端口，带 --all 时同时接管同名服务没有属主的旧规则
 func OpenPort(wf *aw.Workflow, args []string) {
-	// 检查参数格式，需要接收服务名称|协议|远程端口|本地端口
+	// 检查参数格式，需要接收服务名称|协议|远程端口|本地端口，可选 |ip=<IP 或网段>；带 --all 时接管没有属主的旧规则
+	args, all := SplitAllFlag(args)
 	if len(args) < 1 {
 		log.Error("缺少参数，期望格式: 服务名|协议|远程端口|本地端口")
 		wf.NewItem("参数错误").Subtitle("缺少参数，期望格式: 服务名|协议|远程端口|本地端口").Icon(aw.IconError)
@@ -216,6 +274,13 @@ func OpenPort(wf *aw.Workflow, args []string) {
 	protocol := parts[1]
 	remotePort := parts[2]
 	localPort := parts[3]
+	source, err := parseOpenOptions(parts[4:])
+	if err != nil {
+		log.Error("参数格式错误: %v", err)
+		wf.NewItem("参数格式错误").Subtitle(err.Error()).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
 
 	log.Info("开放端口，服务名: %s, 协议: %s, 远程端口: %s, 本地端口: %s", serviceName, protocol, remotePort, localPort)
 
@@ -226,23 +291,57 @@ func OpenPort(wf *aw.Workflow, args []string) {
 		return
 	}
 
-	// 获取当前公网IP
-	currentIP, err := getCurrentPublicIP()
+	var currentIP, grant string
+	if source != "" {
+		// 显式指定了来源，为同事或运营商 NAT 网段开放，不使用本机公网IP
+		currentIP, grant, err = resolveSource(wf, source, minCIDRPrefix(cfg))
+		if err != nil {
+			log.Error("来源校验失败: %v", err)
+			wf.NewItem("来源校验失败").Subtitle(err.Error()).Icon(aw.IconError)
+			wf.SendFeedback()
+			return
+		}
+	} else {
+		// 获取当前公网IP
+		currentIP, err = getCurrentPublicIP(cfg)
+		if err != nil {
+			log.Error("获取公网IP失败: %v", err)
+			wf.NewItem("获取公网IP失败").Subtitle(err.Error()).Icon(aw.IconError)
+			wf.SendFeedback()
+			return
+		}
+	}
+
+	cred, ok := loadCredentials(wf, cfg)
+	if !ok {
+		return
+	}
+	backend, err := newBackend(wf, cfg, cred)
 	if err != nil {
-		log.Error("获取公网IP失败: %v", err)
-		wf.NewItem("获取公网IP失败").Subtitle(err.Error()).Icon(aw.IconError)
+		log.Error("获取安全组失败: %v", err)
+		wf.NewItem("获取安全组失败").Subtitle(err.Error()).Icon(aw.IconError)
 		wf.SendFeedback()
 		return
 	}
+	if source != "" {
+		backend = withoutTemplates(backend)
+	}
 
-	secretID, _ := config.GetSecretId()
-	secretKey, _ := config.GetSecretKey()
+	// 为端口规则创建说明标识，开放给其他来源时带上授权来源，避免替换本机 IP 的规则
+	ruleTag := withGrant(ruleDescription(serviceName, localPort, ruleOwner(cfg)), grant)
 
-	// 为端口规则创建说明标识
-	ruleTag := fmt.Sprintf("AlfredFRP_%s_local%s", serviceName, localPort)
+	// 带 --all 时先接管同名服务没有属主的旧规则，再按本人的规则开放
+	if all && grant == "" {
+		adopted, err := adoptLegacyRules(backend, protocol, remotePort, sourceCIDR(currentIP), ruleTag)
+		if err != nil {
+			log.Warn("接管旧规则失败: %v", err)
+		} else if adopted > 0 {
+			log.Info("已在 %d 个规则组中接管服务 %s 的旧规则", adopted, serviceName)
+		}
+	}
 
 	// 调用腾讯云API创建安全组规则
-	err = createSecurityGroupRule(cfg, secretID, secretKey, protocol, remotePort, currentIP, ruleTag)
+	err = createSecurityGroupRule(backend, protocol, remotePort, currentIP, ruleTag)
 	if err != nil {
 		log.Error("创建安全组规则失败: %v", err)
 		wf.NewItem("创建安全组规则失败").Subtitle(err.Error()).Icon(aw.IconError)
@@ -257,148 +356,144 @@ func OpenPort(wf *aw.Workflow, args []string) {
 	wf.SendFeedback()
 }
 
-// getCurrentPublicIP 获取当前公网IP
-func getCurrentPublicIP() (string, error) {
-	// 尝试从多个服务获取公网IP，以提高可靠性
-	ipServices := []string{
-		"https://api.ipify.org",
-		"https://ifconfig.me/ip",
-		"https://icanhazip.com",
-		"https://ipinfo.io/ip",
+// getCurrentPublicIP 获取当前公网IP，未达到 IP_QUORUM 时返回错误
+func getCurrentPublicIP(cfg *config.Config) (string, error) {
+	consensus, err := lookupPublicIP(cfg)
+	if err != nil {
+		return "", err
 	}
+	return consensus.IP, nil
+}
 
-	var lastErr error
-	for _, service := range ipServices {
-		resp, err := http.Get(service)
-		if err != nil {
-			lastErr = err
-			log.Warn("从 %s 获取IP失败: %v", service, err)
-			continue
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			lastErr = fmt.Errorf("HTTP 状态码非正常: %d", resp.StatusCode)
-			log.Warn("从 %s 获取IP失败: HTTP状态码 %d", service, resp.StatusCode)
-			continue
-		}
-
-		// 读取完整响应
-		ipBytes, err := io.ReadAll(resp.Body)
-		if err != nil {
-			lastErr = err
-			log.Warn("从 %s 读取响应失败: %v", service, err)
-			continue
-		}
-
-		// 清理IP地址（去除空白字符）
-		ip := strings.TrimSpace(string(ipBytes))
-
-		// 验证是否为有效IP地址
-		if net.ParseIP(ip) != nil {
-			log.Info("成功从 %s 获取公网IP: %s", service, ip)
-			return ip, nil
-		}
-
-		lastErr = fmt.Errorf("获取到无效的IP地址: %s", ip)
-		log.Warn("从 %s 获取到无效的IP格式: %s", service, ip)
+// lookupPublicIP 按 IP_RESOLVERS 配置的来源并发获取当前公网IP，总耗时不超过 IP_TIMEOUT，
+// 返回结果中包含各来源的结果，供展示不一致的情况
+func lookupPublicIP(cfg *config.Config) (ipConsensus, error) {
+	resolvers, err := newIPResolvers(cfg)
+	if err != nil {
+		return ipConsensus{}, err
 	}
+	return resolvePublicIP(context.Background(), resolvers, ipTimeout(cfg), ipQuorum(cfg, len(resolvers)))
+}
 
-	if lastErr != nil {
-		return "", fmt.Errorf("无法获取公网IP: %w", lastErr)
+// sourceCIDR 单个 IP 添加/32子网掩码，显式指定的网段保持不变
+func sourceCIDR(ip string) string {
+	if !strings.Contains(ip, "/") {
+		return ip + "/32"
 	}
-	return "", fmt.Errorf("所有IP服务均失败")
+	return ip
 }
 
 // createSecurityGroupRule 创建安全组规则
-func createSecurityGroupRule(cfg *config.Config, secretID, secretKey, protocol, port, ip, description string) error {
+func createSecurityGroupRule(backend Backend, protocol, port, ip, description string) error {
 	log.Info("开始创建安全组规则, 协议: %s, 端口: %s, IP: %s, 描述: %s", protocol, port, ip, description)
 
-	// 创建腾讯云VPC客户端
-	cred := common.NewCredential(secretID, secretKey)
-	cpf := profile.NewClientProfile()
-	cpf.HttpProfile.Endpoint = "vpc.tencentcloudapi.com"
-	client, err := vpc.NewClient(cred, cfg.Region, cpf)
-	if err != nil {
-		return fmt.Errorf("创建腾讯云VPC客户端失败: %w", err)
-	}
+	cidrBlock := sourceCIDR(ip)
 
-	// 添加/32子网掩码
-	cidrBlock := ip + "/32"
+	// 从description中提取服务名
+	serviceName := ""
+	if strings.HasPrefix(description, "AlfredFRP_") {
+		serviceName = ruleKey(description)
+	}
 
-	// 先获取所有现有规则，查找服务名相同的规则
-	allRules, err := getAllSecurityGroupRules(cfg, secretID, secretKey)
-	if err == nil {
-		// 从description中提取服务名
-		serviceName := ""
-		if strings.HasPrefix(description, "AlfredFRP_") {
-			parts := strings.Split(description, "_local")
-			if len(parts) > 0 {
-				serviceName = strings.TrimPrefix(parts[0], "AlfredFRP_")
-			}
+	// 在每个安全组中分别创建规则，某个安全组失败不影响其余安全组
+	var failures []string
+	for _, group := range backend.Groups() {
+		if err := backend.OpenInGroup(group, serviceName, protocol, port, cidrBlock, description); err != nil {
+			log.Error("安全组 %s 创建规则失败: %v", group, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", group, err))
 		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
 
-		// 如果找到服务名，寻找匹配的规则并删除
+// createSecurityGroupRuleInGroup 在单个安全组中创建规则，source 为 CIDR 或 IP 地址模板 ID
+func createSecurityGroupRuleInGroup(client *vpc.Client, securityGroupId, serviceName, protocol, port, source, description string) error {
+	// 读取规则及 Version -> 删除同名服务的旧规则 -> 创建新规则，Version 冲突时整体重试
+	return updateSecurityGroupPolicies(client, securityGroupId, func(set *vpc.SecurityGroupPolicySet) error {
+		version := set.Version
+
+		// 寻找同名服务、相同协议和端口的旧规则（无论 ACCEPT 还是 DROP）
+		var stalePolicies []*vpc.SecurityGroupPolicy
+		alreadyOpen := false
 		if serviceName != "" {
-			for key, rule := range allRules {
-				// 检查是否是同名服务，不论协议和端口
-				ruleServiceName := extractServiceName(rule.PolicyDescription)
-				if ruleServiceName == serviceName && strings.HasPrefix(key, fmt.Sprintf("%s:%s:", protocol, port)) {
-					log.Info("找到匹配的规则需要删除: %s, PolicyIndex: %d", key, rule.PolicyIndex)
-
-					// 创建删除规则请求
-					deleteRequest := vpc.NewDeleteSecurityGroupPoliciesRequest()
-					deleteRequest.SecurityGroupId = common.StringPtr(cfg.SecurityGroupId)
-
-					// 设置要删除的规则
-					deletePolicySet := &vpc.SecurityGroupPolicySet{
-						Ingress: []*vpc.SecurityGroupPolicy{
-							{
-								PolicyIndex: common.Int64Ptr(rule.PolicyIndex),
-							},
-						},
-					}
-					deleteRequest.SecurityGroupPolicySet = deletePolicySet
-
-					// 发送删除请求
-					deleteResponse, err := client.DeleteSecurityGroupPolicies(deleteRequest)
-					if err != nil {
-						log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
-					} else {
-						log.Info("成功删除旧规则，响应: %s", deleteResponse.ToJsonString())
+			for _, policy := range set.Ingress {
+				if policy.PolicyDescription == nil || policy.Protocol == nil || policy.Port == nil {
+					continue
+				}
+				if !replacesRule(*policy.PolicyDescription, serviceName) {
+					continue
+				}
+				if strings.EqualFold(*policy.Protocol, protocol) && *policy.Port == port {
+					// 引用地址模板的规则已存在时无需重建，更新模板即可生效
+					if !alreadyOpen && stringValue(policy.Action) == "ACCEPT" && *policy.PolicyDescription == description &&
+						isAddressTemplateId(source) && policySource(policy) == source {
+						log.Info("规则 %s 已引用地址模板 %s，保留", description, source)
+						alreadyOpen = true
+						continue
 					}
+					log.Info("找到匹配的规则需要删除: %s, 动作: %s, 来源: %s", *policy.PolicyDescription, stringValue(policy.Action), policySource(policy))
+					stalePolicies = append(stalePolicies, policyMatchSpec(policy))
 				}
 			}
 		}
-	}
 
-	// 创建安全组规则请求
-	request := vpc.NewCreateSecurityGroupPoliciesRequest()
-	request.SecurityGroupId = common.StringPtr(cfg.SecurityGroupId)
+		if len(stalePolicies) > 0 {
+			// 按规则内容匹配删除，并带上读取时的 Version
+			deleteRequest := vpc.NewDeleteSecurityGroupPoliciesRequest()
+			deleteRequest.SecurityGroupId = common.StringPtr(securityGroupId)
+			deleteRequest.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+				Version: version,
+				Ingress: stalePolicies,
+			}
 
-	// 创建入站规则
-	policySet := &vpc.SecurityGroupPolicySet{
-		Ingress: []*vpc.SecurityGroupPolicy{
-			{
-				Protocol:          common.StringPtr(protocol),
-				Port:              common.StringPtr(port),
-				CidrBlock:         common.StringPtr(cidrBlock),
-				Action:            common.StringPtr("ACCEPT"),
-				PolicyDescription: common.StringPtr(description),
-			},
-		},
-	}
-	request.SecurityGroupPolicySet = policySet
+			deleteResponse, err := client.DeleteSecurityGroupPolicies(deleteRequest)
+			if err != nil {
+				if isVersionMismatch(err) {
+					return err
+				}
+				log.Warn("删除旧规则失败，将继续创建新规则: %v", err)
+			} else {
+				log.Info("成功删除旧规则，响应: %s", deleteResponse.ToJsonString())
+				if version, err = currentPolicyVersion(client, securityGroupId); err != nil {
+					return err
+				}
+			}
+		}
 
-	// 发送API请求
-	response, err := client.CreateSecurityGroupPolicies(request)
-	if err != nil {
-		if sdkErr, ok := err.(*tcErrors.TencentCloudSDKError); ok {
-			return fmt.Errorf("腾讯云API错误: Code=%s, Message=%s, RequestId=%s", sdkErr.GetCode(), sdkErr.GetMessage(), sdkErr.GetRequestId())
+		if alreadyOpen {
+			return nil
 		}
-		return fmt.Errorf("调用腾讯云API失败: %w", err)
-	}
 
-	log.Info("创建安全组规则成功，响应: %s", response.ToJsonString())
-	return nil
+		// 创建安全组规则请求
+		request := vpc.NewCreateSecurityGroupPoliciesRequest()
+		request.SecurityGroupId = common.StringPtr(securityGroupId)
+
+		// 创建入站规则
+		policy := &vpc.SecurityGroupPolicy{
+			Protocol:          common.StringPtr(protocol),
+			Port:              common.StringPtr(port),
+			Action:            common.StringPtr("ACCEPT"),
+			PolicyDescription: common.StringPtr(description),
+		}
+		setPolicySource(policy, source)
+		request.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+			Version: version,
+			Ingress: []*vpc.SecurityGroupPolicy{policy},
+		}
+
+		// 发送API请求
+		response, err := client.CreateSecurityGroupPolicies(request)
+		if err != nil {
+			if isVersionMismatch(err) {
+				return err
+			}
+			return sdkError("创建规则", err)
+		}
+
+		log.Info("创建安全组规则成功，响应: %s", response.ToJsonString())
+		return nil
+	})
 }
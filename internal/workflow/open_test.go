@@ -0,0 +1,63 @@
+package workflow
+
+import "testing"
+
+func TestNormalizeSource(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"203.0.113.7", "203.0.113.7/32"},
+		{"203.0.113.0/24", "203.0.113.0/24"},
+		{"203.0.113.7/24", "203.0.113.0/24"},
+		{"100.64.0.0/16", "100.64.0.0/16"},
+	}
+	for _, tt := range tests {
+		if got, err := normalizeSource(tt.source, 16); err != nil || got != tt.want {
+			t.Errorf("normalizeSource(%s) = %q, %v, want %s", tt.source, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"0.0.0.0/0", "100.64.0.0/10", "2001:db8::1", "2001:db8::/64", "example.com", "203.0.113.0/33"} {
+		if got, err := normalizeSource(bad, 16); err == nil {
+			t.Errorf("normalizeSource(%s) = %q, should fail", bad, got)
+		}
+	}
+	if _, err := normalizeSource("0.0.0.0/0", 0); err == nil {
+		t.Error("0.0.0.0/0 must be rejected whatever the configured prefix")
+	}
+}
+
+func TestParseOpenOptions(t *testing.T) {
+	if source, err := parseOpenOptions([]string{"ip=203.0.113.0/24"}); err != nil || source != "203.0.113.0/24" {
+		t.Errorf("parseOpenOptions = %q, %v", source, err)
+	}
+	if source, err := parseOpenOptions(nil); err != nil || source != "" {
+		t.Errorf("parseOpenOptions(nil) = %q, %v", source, err)
+	}
+	for _, bad := range []string{"ip=", "cidr=203.0.113.0/24"} {
+		if _, err := parseOpenOptions([]string{bad}); err == nil {
+			t.Errorf("parseOpenOptions(%s) should fail", bad)
+		}
+	}
+}
+
+func TestOpenExplicitCIDR(t *testing.T) {
+	backend := newHostBackend(&iptablesFirewall{runner: &fakeRunner{}}, "203.0.113.1")
+	if err := createSecurityGroupRule(backend, "TCP", "8022", "198.51.100.0/24", "AlfredFRP_ssh_home_local22"); err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	rules, _ := getAllSecurityGroupRules(backend)
+	if got := rules["ssh_home"]; got.Action != "ACCEPT" || got.CidrBlock != "198.51.100.0/24" {
+		t.Errorf("ssh_home rule = %+v, want ACCEPT for 198.51.100.0/24", got)
+	}
+
+	vpc := &vpcBackend{templateName: "AlfredFRP-alice", serviceTemplate: &serviceTemplateState{name: "AlfredFRP-alice"}}
+	direct, ok := withoutTemplates(vpc).(*vpcBackend)
+	if !ok || direct.templateName != "" || direct.serviceTemplate != nil {
+		t.Errorf("withoutTemplates kept templates: %+v", direct)
+	}
+	if vpc.templateName == "" || vpc.serviceTemplate == nil {
+		t.Error("withoutTemplates must not modify the original backend")
+	}
+}
@@ -0,0 +1,204 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// 规则备注格式: AlfredFRP_服务名_local端口@属主#授权来源。
+// 多人共用一个安全组时，属主用于区分各自开放的规则；没有 @属主 的是引入属主前创建的旧规则，open --all 时才会接管。
+// 通过 ip= 开放给其他来源的规则带 #授权来源，与本机 IP 的规则以及其他来源的规则互不替换。
+
+// ruleOwner 返回当前用户的属主标识：OWNER，未配置时为 frpc.toml 中的 user
+func ruleOwner(cfg *config.Config) string {
+	owner := cfg.Owner
+	if owner == "" {
+		owner = frpcUser(cfg)
+	}
+	return sanitizeOwner(owner)
+}
+
+// sanitizeOwner 属主会写入规则备注和模板名称，只保留字母、数字和 _ . -
+func sanitizeOwner(owner string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, owner)
+}
+
+// ruleDescription 生成规则备注
+func ruleDescription(serviceName, localPort, owner string) string {
+	description := fmt.Sprintf("AlfredFRP_%s_local%s", serviceName, localPort)
+	if owner != "" {
+		description += "@" + owner
+	}
+	return description
+}
+
+// grantSeparator 之后的部分由 _local端口@属主 之后开始匹配，服务名中的 # 不会被误认为授权来源
+var grantSeparator = regexp.MustCompile(`_local[0-9]*(@[A-Za-z0-9_.-]*)?#`)
+
+// withGrant 为规则备注或规则标识追加授权来源，grant 为空时原样返回
+func withGrant(s, grant string) string {
+	if grant == "" {
+		return s
+	}
+	return s + "#" + grant
+}
+
+// splitGrant 将规则备注拆分为不含授权来源的部分和授权来源
+func splitGrant(description string) (base, grant string) {
+	matches := grantSeparator.FindAllStringIndex(description, -1)
+	if len(matches) == 0 {
+		return description, ""
+	}
+	end := matches[len(matches)-1][1]
+	return description[:end-1], description[end:]
+}
+
+// extractGrant 从规则备注中提取授权来源，为本机 IP 开放的规则返回空字符串
+func extractGrant(description string) string {
+	_, grant := splitGrant(description)
+	return grant
+}
+
+// extractOwner 从规则备注中提取属主，旧规则返回空字符串
+func extractOwner(description string) string {
+	description, _ = splitGrant(description)
+	idx := strings.LastIndex(description, "_local")
+	if idx == -1 {
+		return ""
+	}
+	_, owner, _ := strings.Cut(description[idx+len("_local"):], "@")
+	return owner
+}
+
+// ruleKey 返回区分不同属主同名服务的规则标识：服务名@属主#授权来源，旧规则为服务名
+func ruleKey(description string) string {
+	key := extractServiceName(description)
+	if owner := extractOwner(description); owner != "" {
+		key += "@" + owner
+	}
+	return withGrant(key, extractGrant(description))
+}
+
+// keyGrant 返回规则标识中的授权来源
+func keyGrant(key string) string {
+	if idx := strings.LastIndex(key, "#"); idx != -1 {
+		return key[idx+1:]
+	}
+	return ""
+}
+
+// ruleTitle 返回规则标识的展示名：服务名，开放给其他来源的规则为 服务名 → 授权来源
+func ruleTitle(key string) string {
+	serviceName, _ := splitRuleKey(key)
+	if grant := keyGrant(key); grant != "" {
+		return serviceName + " → " + grant
+	}
+	return serviceName
+}
+
+// keyDescription 由规则标识和本地端口还原规则备注
+func keyDescription(key, localPort string) string {
+	serviceName, owner := splitRuleKey(key)
+	return withGrant(ruleDescription(serviceName, localPort, owner), keyGrant(key))
+}
+
+// splitRuleKey 将规则标识拆分为服务名和属主，忽略授权来源
+func splitRuleKey(key string) (serviceName, owner string) {
+	if idx := strings.LastIndex(key, "#"); idx != -1 {
+		key = key[:idx]
+	}
+	if idx := strings.LastIndex(key, "@"); idx != -1 {
+		return key[:idx], key[idx+1:]
+	}
+	return key, ""
+}
+
+// replacesRule 判断开放 key 对应的服务时，是否应替换备注为 description 的已有规则：
+// 只替换同一属主、同一授权来源的同名服务。没有属主的旧规则可能是同事升级前创建的，
+// 只在 open --all 时由 adoptLegacyRules 接管。授权来源不同的规则互不替换，开放给同事不会删掉本机 IP 的规则。
+func replacesRule(description, key string) bool {
+	return strings.HasPrefix(description, "AlfredFRP_") && ruleKey(description) == key
+}
+
+// adoptLegacyRules 将各规则组中同名服务没有属主的旧规则替换为 description 对应的规则，返回接管的规则组数
+func adoptLegacyRules(backend Backend, protocol, port, cidrBlock, description string) (int, error) {
+	legacyKey := extractServiceName(description)
+	adopted := 0
+	var failures []string
+	for _, group := range backend.Groups() {
+		rules, err := backend.ListGroupRules(group)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", group, err))
+			continue
+		}
+		if _, ok := rules[legacyKey]; !ok {
+			continue
+		}
+		// 以旧规则的标识开放，替换旧规则的同时写入带属主的备注
+		if err := backend.OpenInGroup(group, legacyKey, protocol, port, cidrBlock, description); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", group, err))
+			continue
+		}
+		log.Info("规则组 %s 中服务 %s 的旧规则已接管为 %s", group, legacyKey, description)
+		adopted++
+	}
+	if len(failures) > 0 {
+		return adopted, errors.New(strings.Join(failures, "; "))
+	}
+	return adopted, nil
+}
+
+// ownedBy 判断规则是否属于 owner，owner 为空时视为所有人的规则都匹配
+func ownedBy(rule FetchedRuleInfo, owner string) bool {
+	return owner == "" || extractOwner(rule.PolicyDescription) == owner
+}
+
+// splitRulesByOwner 将以规则标识为 key 的规则拆分为本人为本机 IP 开放的规则（以服务名为 key）
+// 和其余规则（其他人的规则以及本人开放给其他来源的规则，仍以规则标识为 key）
+func splitRulesByOwner(rules map[string]FetchedRuleInfo, owner string) (mine, others map[string]FetchedRuleInfo) {
+	mine = make(map[string]FetchedRuleInfo)
+	others = make(map[string]FetchedRuleInfo)
+	for key, rule := range rules {
+		serviceName, keyOwner := splitRuleKey(key)
+		if keyOwner == owner && keyGrant(key) == "" {
+			mine[serviceName] = rule
+		} else {
+			others[key] = rule
+		}
+	}
+	return mine, others
+}
+
+// ownRulesByGroup 只保留各安全组中本人为本机 IP 开放的规则，以服务名为 key
+func ownRulesByGroup(rulesByGroup map[string]map[string]FetchedRuleInfo, owner string) map[string]map[string]FetchedRuleInfo {
+	result := make(map[string]map[string]FetchedRuleInfo, len(rulesByGroup))
+	for group, rules := range rulesByGroup {
+		result[group], _ = splitRulesByOwner(rules, owner)
+	}
+	return result
+}
+
+// SplitAllFlag 从参数中移除 --all，返回其余参数以及是否包含 --all
+func SplitAllFlag(args []string) ([]string, bool) {
+	rest := make([]string, 0, len(args))
+	all := false
+	for _, arg := range args {
+		if arg == "--all" {
+			all = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return rest, all
+}
@@ -0,0 +1,181 @@
+package workflow
+
+import "testing"
+
+func TestRuleDescriptionOwner(t *testing.T) {
+	description := ruleDescription("ssh_home", "22", "alice")
+	if description != "AlfredFRP_ssh_home_local22@alice" {
+		t.Fatalf("ruleDescription = %s", description)
+	}
+	if got := extractServiceName(description); got != "ssh_home" {
+		t.Errorf("extractServiceName = %s", got)
+	}
+	if got := extractLocalPort(description); got != "22" {
+		t.Errorf("extractLocalPort = %s", got)
+	}
+	if got := extractOwner(description); got != "alice" {
+		t.Errorf("extractOwner = %s", got)
+	}
+	if got := ruleKey(description); got != "ssh_home@alice" {
+		t.Errorf("ruleKey = %s", got)
+	}
+	if got := ruleKey("AlfredFRP_ssh_home_local22"); got != "ssh_home" {
+		t.Errorf("ruleKey of a legacy rule = %s", got)
+	}
+	granted := withGrant(description, "198.51.100.0/24")
+	if got := ruleKey(granted); got != "ssh_home@alice#198.51.100.0/24" {
+		t.Errorf("ruleKey of a grant = %s", got)
+	}
+	if extractServiceName(granted) != "ssh_home" || extractLocalPort(granted) != "22" || extractOwner(granted) != "alice" {
+		t.Errorf("grant suffix leaked into %s/%s/%s", extractServiceName(granted), extractLocalPort(granted), extractOwner(granted))
+	}
+	if got := keyDescription(ruleKey(granted), "22"); got != granted {
+		t.Errorf("keyDescription = %s, want %s", got, granted)
+	}
+	if got := extractGrant("AlfredFRP_a#b_local22@alice"); got != "" {
+		t.Errorf("# in service name parsed as grant %q", got)
+	}
+	if got := extractGrant("AlfredFRP_ssh_local22@alice#dev_local"); got != "dev_local" {
+		t.Errorf("extractGrant = %q", got)
+	}
+	if got := sanitizeOwner("bob smith@corp"); got != "bob_smith_corp" {
+		t.Errorf("sanitizeOwner = %s", got)
+	}
+}
+
+func TestReplacesRule(t *testing.T) {
+	tests := []struct {
+		description, key string
+		want             bool
+	}{
+		{"AlfredFRP_ssh_local22@alice", "ssh@alice", true},
+		{"AlfredFRP_ssh_local22@bob", "ssh@alice", false},
+		{"AlfredFRP_ssh_local22", "ssh@alice", false},
+		{"AlfredFRP_ssh_local22", "ssh", true},
+		{"AlfredFRP_web_local80@alice", "ssh@alice", false},
+		{"manual ssh", "ssh@alice", false},
+		{"AlfredFRP_ssh_local22@alice#198.51.100.0/24", "ssh@alice", false},
+		{"AlfredFRP_ssh_local22@alice", "ssh@alice#198.51.100.0/24", false},
+		{"AlfredFRP_ssh_local22@alice#198.51.100.0/24", "ssh@alice#198.51.100.0/24", true},
+		{"AlfredFRP_ssh_local22#203.0.113.0/28", "ssh", false},
+	}
+	for _, tt := range tests {
+		if got := replacesRule(tt.description, tt.key); got != tt.want {
+			t.Errorf("replacesRule(%s, %s) = %v, want %v", tt.description, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestSharedGroupOwnership(t *testing.T) {
+	backend := newHostBackend(&iptablesFirewall{runner: &fakeRunner{}}, "203.0.113.1")
+	for _, open := range []struct{ ip, owner string }{
+		{"198.51.100.7", "alice"},
+		{"198.51.100.9", "bob"},
+	} {
+		if err := createSecurityGroupRule(backend, "TCP", "2222", open.ip, ruleDescription("ssh", "22", open.owner)); err != nil {
+			t.Fatalf("open for %s failed: %v", open.owner, err)
+		}
+	}
+
+	rules, _ := getAllSecurityGroupRules(backend)
+	mine, others := splitRulesByOwner(rules, "alice")
+	if got := mine["ssh"]; got.CidrBlock != "198.51.100.7/32" {
+		t.Errorf("alice's ssh = %+v, bob's open must not replace it", got)
+	}
+	if got := others["ssh@bob"]; got.CidrBlock != "198.51.100.9/32" {
+		t.Errorf("others = %+v, want bob's ssh", others)
+	}
+
+	// alice 的 IP 变化时只改指向 alice 的规则
+	if _, _, err := repointRules(backend, "198.51.100.9", "198.51.100.8", false, "alice"); err != nil {
+		t.Fatalf("repoint failed: %v", err)
+	}
+	rules, _ = getAllSecurityGroupRules(backend)
+	if got := rules["ssh@bob"]; got.CidrBlock != "198.51.100.9/32" {
+		t.Errorf("repointing as alice touched bob's rule: %+v", got)
+	}
+}
+
+func TestGrantsCoexistWithOwnRule(t *testing.T) {
+	backend := newHostBackend(&iptablesFirewall{runner: &fakeRunner{}}, "203.0.113.1")
+	own := ruleDescription("ssh", "22", "alice")
+	for _, open := range []struct{ ip, description string }{
+		{"198.51.100.7", own},
+		{"203.0.113.16/28", withGrant(own, "203.0.113.16/28")},
+		{"192.0.2.5/32", withGrant(own, "192.0.2.5/32")},
+		// 重新开放同一授权只替换该授权
+		{"203.0.113.16/28", withGrant(own, "203.0.113.16/28")},
+	} {
+		if err := createSecurityGroupRule(backend, "TCP", "2222", open.ip, open.description); err != nil {
+			t.Fatalf("open %s failed: %v", open.description, err)
+		}
+	}
+
+	rules, _ := getAllSecurityGroupRules(backend)
+	if len(rules) != 3 {
+		t.Fatalf("rules = %+v, want own rule and two grants", rules)
+	}
+	mine, others := splitRulesByOwner(rules, "alice")
+	if got := mine["ssh"]; got.CidrBlock != "198.51.100.7/32" {
+		t.Errorf("own rule = %+v, grants must not replace it", got)
+	}
+	if others["ssh@alice#203.0.113.16/28"].CidrBlock != "203.0.113.16/28" || others["ssh@alice#192.0.2.5/32"].CidrBlock != "192.0.2.5/32" {
+		t.Errorf("grants = %+v", others)
+	}
+
+	if err := createDenyRuleAndDeleteOriginal(backend, "TCP", "2222", "192.0.2.5/32", "ssh@alice#192.0.2.5/32", "22"); err != nil {
+		t.Fatalf("close grant failed: %v", err)
+	}
+	rules, _ = getAllSecurityGroupRules(backend)
+	if rules["ssh@alice#192.0.2.5/32"].Action == "ACCEPT" || rules["ssh@alice#203.0.113.16/28"].Action != "ACCEPT" || rules["ssh@alice"].CidrBlock != "198.51.100.7/32" {
+		t.Errorf("closing a grant should only remove that grant: %+v", rules)
+	}
+}
+
+func TestAdoptLegacyRules(t *testing.T) {
+	backend := newHostBackend(&iptablesFirewall{runner: &fakeRunner{}}, "203.0.113.1")
+	for _, description := range []string{"AlfredFRP_ssh_local22", "AlfredFRP_web_local80"} {
+		if err := createSecurityGroupRule(backend, "TCP", "2222", "198.51.100.9", description); err != nil {
+			t.Fatal(err)
+		}
+	}
+	own := ruleDescription("ssh", "22", "alice")
+
+	// 普通的 open 不接管旧规则
+	if err := createSecurityGroupRule(backend, "TCP", "2222", "198.51.100.7", own); err != nil {
+		t.Fatal(err)
+	}
+	rules, _ := getAllSecurityGroupRules(backend)
+	if rules["ssh"].CidrBlock != "198.51.100.9/32" || rules["ssh@alice"].CidrBlock != "198.51.100.7/32" {
+		t.Fatalf("plain open must leave the legacy rule alone: %+v", rules)
+	}
+
+	adopted, err := adoptLegacyRules(backend, "TCP", "2222", "198.51.100.7/32", own)
+	if err != nil || adopted != 1 {
+		t.Fatalf("adoptLegacyRules = %d, %v", adopted, err)
+	}
+	rules, _ = getAllSecurityGroupRules(backend)
+	if _, ok := rules["ssh"]; ok {
+		t.Errorf("legacy ssh rule should be adopted: %+v", rules)
+	}
+	if rules["ssh@alice"].CidrBlock != "198.51.100.7/32" || rules["web"].CidrBlock != "198.51.100.9/32" {
+		t.Errorf("rules = %+v", rules)
+	}
+}
+
+func TestCheckCloseTarget(t *testing.T) {
+	for _, tt := range []struct {
+		key, localPort string
+		ok             bool
+	}{
+		{"ssh@alice", "22", true},
+		{"ssh", "22", true},
+		{"ssh@alice#198.51.100.0/24", "22", true},
+		{"", "22", false},
+		{"ssh", "22@bob", false},
+	} {
+		if err := checkCloseTarget(tt.key, tt.localPort); (err == nil) != tt.ok {
+			t.Errorf("checkCloseTarget(%q, %q) = %v", tt.key, tt.localPort, err)
+		}
+	}
+}
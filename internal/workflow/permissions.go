@@ -0,0 +1,439 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+const (
+	// permissionProbeDescription 写权限探测使用的规则备注
+	permissionProbeDescription = "AlfredFRP_permission_check"
+	// permissionProbeCidr 写权限探测使用的来源，为 RFC 5737 保留的文档地址
+	permissionProbeCidr = "192.0.2.1/32"
+	// permissionProbeAddressTemplateId、permissionProbeServiceTemplateId 模板写权限探测使用的不存在的模板 ID
+	permissionProbeAddressTemplateId = "ipm-00000000"
+	permissionProbeServiceTemplateId = "ppm-00000000"
+	// staleVersionFallback 无法读取安全组 Version 时使用的版本号，确保与实际版本不一致
+	staleVersionFallback = "999999999"
+)
+
+// permissionStatus CAM 权限探测结果
+type permissionStatus int
+
+const (
+	permissionAllowed permissionStatus = iota
+	permissionUnknown                  // 网络错误或密钥无效等，无法判断是否有权限
+	permissionDenied
+)
+
+// camAction Workflow 需要的一个 CAM 接口权限
+type camAction struct {
+	action string
+	// scoped 为 true 时资源为安全组，对每个安全组分别探测；否则资源为 *
+	scoped bool
+	// probe 以不会改动资源的方式调用接口：只读接口直接调用，安全组写接口带上过期的 Version，
+	// 模板写接口使用无效的参数。无法在不改动资源的前提下确认的接口，结果为无法判断
+	probe func(p *permissionProbe, group string) error
+}
+
+// permissionResult 一个 CAM 接口权限的探测结果
+type permissionResult struct {
+	action string
+	status permissionStatus
+	detail string
+}
+
+// permissionProbe 探测权限时共用的客户端与安全组 Version
+type permissionProbe struct {
+	cfg      *config.Config
+	cred     *config.Credential
+	client   *vpc.Client
+	versions map[string]string
+}
+
+// requiredCAMActions 根据配置返回 Workflow 需要的 CAM 接口权限
+func requiredCAMActions(cfg *config.Config) []camAction {
+	actions := []camAction{
+		{"vpc:DescribeSecurityGroupPolicies", true, probeDescribeSecurityGroupPolicies},
+		{"vpc:CreateSecurityGroupPolicies", true, probeCreateSecurityGroupPolicies},
+		{"vpc:DeleteSecurityGroupPolicies", true, probeDeleteSecurityGroupPolicies},
+		// 校验 REGION 与 doctor 检查规则配额时使用
+		{"cvm:DescribeRegions", false, probeDescribeRegions},
+		{"vpc:DescribeSecurityGroupLimits", false, probeDescribeSecurityGroupLimits},
+	}
+	if cfg.SecurityGroupId == "" {
+		// 未配置安全组时通过实例反查
+		actions = append(actions,
+			camAction{"cvm:DescribeInstances", false, probeDescribeInstances},
+			camAction{"vpc:DescribeNetworkInterfaces", false, probeDescribeNetworkInterfaces},
+		)
+	}
+	if cfg.UseAddressTemplate() {
+		actions = append(actions,
+			camAction{"vpc:DescribeAddressTemplates", false, probeDescribeAddressTemplates},
+			camAction{"vpc:CreateAddressTemplate", false, probeCreateAddressTemplate},
+			camAction{"vpc:ModifyAddressTemplateAttribute", false, probeModifyAddressTemplate},
+		)
+	}
+	if cfg.UseServiceTemplate() {
+		actions = append(actions,
+			camAction{"vpc:DescribeServiceTemplates", false, probeDescribeServiceTemplates},
+			camAction{"vpc:CreateServiceTemplate", false, probeCreateServiceTemplate},
+			camAction{"vpc:ModifyServiceTemplateAttribute", false, probeModifyServiceTemplate},
+		)
+	}
+	return actions
+}
+
+// PermissionsCommand 探测当前密钥是否具备 Workflow 需要的 CAM 权限，列出缺少的接口，
+// 并生成以所配置安全组为资源的最小权限策略
+func PermissionsCommand(wf *aw.Workflow, args []string) {
+	cfg, err := config.Load()
+	if err != nil {
+		wf.FatalError(fmt.Errorf("配置文件读取失败: %v", err))
+		return
+	}
+	if cfg.Provider != "" && cfg.Provider != config.ProviderTencent {
+		showPermissionError(wf, args, errors.New("CAM 权限检查仅支持 tencent 后端"))
+		return
+	}
+	cred, err := config.LoadCredential()
+	if err == nil {
+		cred, err = assumeRoleIfConfigured(wf, cfg, cred)
+	}
+	if err != nil {
+		showPermissionError(wf, args, fmt.Errorf("获取密钥失败: %w", err))
+		return
+	}
+	backend, err := newBackend(wf, cfg, cred)
+	if err != nil {
+		showPermissionError(wf, args, err)
+		return
+	}
+	b := backend.(*vpcBackend)
+	probe := &permissionProbe{cfg: cfg, cred: cred, client: b.client, versions: make(map[string]string)}
+	results := checkPermissions(probe, requiredCAMActions(cfg), b.groups)
+	policy, err := minimalCAMPolicy(cfg.Region, requiredCAMActions(cfg), b.groups)
+	if err != nil {
+		showPermissionError(wf, args, err)
+		return
+	}
+
+	if wantsTextOutput(args) {
+		for _, r := range results {
+			fmt.Printf("%s %s: %s\n", r.status.icon(), r.action, r.detail)
+		}
+		fmt.Println(permissionSummary(results))
+		fmt.Println("最小权限策略:")
+		fmt.Println(policy)
+		return
+	}
+	wf.NewItem(permissionSummary(results)).
+		Subtitle("安全组: " + strings.Join(b.groups, ", ")).
+		Valid(false).
+		Icon(aw.IconInfo)
+	for _, r := range results {
+		wf.NewItem(r.status.icon() + " " + r.action).
+			Subtitle(r.detail).
+			Valid(false)
+	}
+	wf.NewItem("📋 最小权限策略").
+		Subtitle("⌘C 复制策略 JSON，在 CAM 控制台创建自定义策略后关联到子用户或角色").
+		Copytext(policy).
+		Valid(false)
+	wf.SendFeedback()
+}
+
+// showPermissionError 输出无法进行权限检查的原因
+func showPermissionError(wf *aw.Workflow, args []string, err error) {
+	log.Error("CAM 权限检查失败: %v", err)
+	if wantsTextOutput(args) {
+		fmt.Println(err)
+		return
+	}
+	wf.NewItem("无法检查 CAM 权限").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+	wf.SendFeedback()
+}
+
+// icon 返回权限探测结果的图标
+func (s permissionStatus) icon() string {
+	switch s {
+	case permissionAllowed:
+		return checkPass.icon()
+	case permissionDenied:
+		return checkFail.icon()
+	}
+	return IconUnknown
+}
+
+// checkPermissions 依次探测每个 CAM 接口，作用于安全组的接口需对每个安全组都有权限，
+// 多个安全组的结果不同时取最差的结果
+func checkPermissions(p *permissionProbe, actions []camAction, groups []string) []permissionResult {
+	var results []permissionResult
+	for _, action := range actions {
+		targets := []string{""}
+		if action.scoped {
+			targets = groups
+		}
+		result := permissionResult{action: action.action, status: permissionAllowed}
+		var details []string
+		for _, group := range targets {
+			status, detail := classifyPermissionError(action.probe(p, group))
+			if status > result.status {
+				result.status = status
+			}
+			if group != "" {
+				detail = group + ": " + detail
+			}
+			details = append(details, detail)
+		}
+		result.detail = strings.Join(details, "；")
+		log.Info("CAM 权限 %s: %s %s", result.action, result.status.icon(), result.detail)
+		results = append(results, result)
+	}
+	return results
+}
+
+// classifyPermissionError 根据接口返回的错误判断是否有权限。
+// 只有版本冲突能说明写请求已通过 CAM 鉴权；参数错误、资源不存在等错误可能在鉴权之前返回，视为无法判断
+func classifyPermissionError(err error) (permissionStatus, string) {
+	if err == nil {
+		return permissionAllowed, "已授权"
+	}
+	var sdkErr *tcErrors.TencentCloudSDKError
+	if !errors.As(err, &sdkErr) {
+		return permissionUnknown, err.Error()
+	}
+	code := sdkErr.GetCode()
+	switch {
+	case code == "AuthFailure.UnauthorizedOperation" || strings.HasPrefix(code, "UnauthorizedOperation"):
+		return permissionDenied, "未授权（" + code + "）"
+	case code == "UnsupportedOperation.VersionMismatch":
+		return permissionAllowed, "已授权（探测请求因版本冲突未生效）"
+	}
+	return permissionUnknown, code + ": " + sdkErr.GetMessage()
+}
+
+// missingCAMActions 返回探测结果为未授权的接口
+func missingCAMActions(results []permissionResult) []string {
+	var missing []string
+	for _, r := range results {
+		if r.status == permissionDenied {
+			missing = append(missing, r.action)
+		}
+	}
+	return missing
+}
+
+// permissionSummary 汇总缺少和无法判断的权限，所有接口都已授权时才显示通过
+func permissionSummary(results []permissionResult) string {
+	var unknown []string
+	for _, r := range results {
+		if r.status == permissionUnknown {
+			unknown = append(unknown, r.action)
+		}
+	}
+	if missing := missingCAMActions(results); len(missing) > 0 {
+		summary := fmt.Sprintf("缺少 %d 个 CAM 权限: %s", len(missing), strings.Join(missing, ", "))
+		if len(unknown) > 0 {
+			summary += fmt.Sprintf("；另有 %d 个无法判断", len(unknown))
+		}
+		return summary
+	}
+	if len(unknown) > 0 {
+		return fmt.Sprintf("%s 未发现缺少的 CAM 权限，%d 个无法判断: %s", IconUnknown, len(unknown), strings.Join(unknown, ", "))
+	}
+	return IconOpen + " 所有 CAM 权限均已授权"
+}
+
+// minimalCAMPolicy 生成最小权限策略：安全组规则接口只授权给所配置的安全组，
+// 模板、实例、地域和配额查询接口无法限定到具体资源，授权给 *。资源中省略主账号表示策略所属的主账号
+func minimalCAMPolicy(region string, actions []camAction, groups []string) (string, error) {
+	type statement struct {
+		Effect   string   `json:"effect"`
+		Action   []string `json:"action"`
+		Resource []string `json:"resource"`
+	}
+	var scoped, unscoped []string
+	for _, action := range actions {
+		if action.scoped {
+			scoped = append(scoped, action.action)
+		} else {
+			unscoped = append(unscoped, action.action)
+		}
+	}
+	var resources []string
+	for _, group := range groups {
+		resources = append(resources, fmt.Sprintf("qcs::vpc:%s::securitygroup/%s", region, group))
+	}
+	policy := struct {
+		Version   string      `json:"version"`
+		Statement []statement `json:"statement"`
+	}{Version: "2.0"}
+	policy.Statement = append(policy.Statement, statement{"allow", scoped, resources})
+	if len(unscoped) > 0 {
+		policy.Statement = append(policy.Statement, statement{"allow", unscoped, []string{"*"}})
+	}
+	data, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// staleVersion 返回与安全组当前 Version 不一致的版本号，写接口带上它时会因版本冲突而不生效
+func staleVersion(version string) string {
+	if v, err := strconv.ParseInt(version, 10, 64); err == nil && v > 0 {
+		return strconv.FormatInt(v-1, 10)
+	}
+	return staleVersionFallback
+}
+
+// probePolicy 写权限探测使用的规则：拒绝文档地址访问 discard 端口，即使意外生效也不影响访问
+func probePolicy() *vpc.SecurityGroupPolicy {
+	return &vpc.SecurityGroupPolicy{
+		Protocol:          common.StringPtr("TCP"),
+		Port:              common.StringPtr("9"),
+		CidrBlock:         common.StringPtr(permissionProbeCidr),
+		Action:            common.StringPtr("DROP"),
+		PolicyDescription: common.StringPtr(permissionProbeDescription),
+	}
+}
+
+func probeDescribeSecurityGroupPolicies(p *permissionProbe, group string) error {
+	set, err := describeSecurityGroupPolicySet(p.client, group)
+	if err == nil {
+		p.versions[group] = stringValue(set.Version)
+	}
+	return err
+}
+
+func probeCreateSecurityGroupPolicies(p *permissionProbe, group string) error {
+	request := vpc.NewCreateSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(group)
+	request.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+		Version: common.StringPtr(staleVersion(p.versions[group])),
+		Ingress: []*vpc.SecurityGroupPolicy{probePolicy()},
+	}
+	_, err := p.client.CreateSecurityGroupPolicies(request)
+	if err == nil {
+		// 版本校验未生效时会真的创建探测规则，立即删除
+		log.Warn("探测规则意外创建成功，删除安全组 %s 中的探测规则", group)
+		if cleanupErr := deleteProbePolicy(p.client, group, nil); cleanupErr != nil {
+			return fmt.Errorf("探测规则已创建但删除失败，请手动删除备注为 %s 的规则: %w", permissionProbeDescription, cleanupErr)
+		}
+	}
+	return err
+}
+
+func probeDeleteSecurityGroupPolicies(p *permissionProbe, group string) error {
+	return deleteProbePolicy(p.client, group, common.StringPtr(staleVersion(p.versions[group])))
+}
+
+// deleteProbePolicy 按内容删除探测规则，安全组中没有该规则，不会删除其他规则
+func deleteProbePolicy(client *vpc.Client, group string, version *string) error {
+	request := vpc.NewDeleteSecurityGroupPoliciesRequest()
+	request.SecurityGroupId = common.StringPtr(group)
+	request.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+		Version: version,
+		Ingress: []*vpc.SecurityGroupPolicy{probePolicy()},
+	}
+	_, err := client.DeleteSecurityGroupPolicies(request)
+	return err
+}
+
+func probeDescribeInstances(p *permissionProbe, _ string) error {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	client := common.NewCommonClient(tencentCredential(p.cred), p.cfg.Region, cpf)
+	request := tchttp.NewCommonRequest("cvm", "2017-03-12", "DescribeInstances")
+	if err := request.SetActionParameters(map[string]interface{}{"Limit": 1}); err != nil {
+		return err
+	}
+	return client.Send(request, tchttp.NewCommonResponse())
+}
+
+func probeDescribeRegions(p *permissionProbe, _ string) error {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	client := common.NewCommonClient(tencentCredential(p.cred), regionQueryRegion, cpf)
+	return client.Send(tchttp.NewCommonRequest("cvm", "2017-03-12", "DescribeRegions"), tchttp.NewCommonResponse())
+}
+
+func probeDescribeSecurityGroupLimits(p *permissionProbe, _ string) error {
+	_, err := p.client.DescribeSecurityGroupLimits(vpc.NewDescribeSecurityGroupLimitsRequest())
+	return err
+}
+
+func probeDescribeNetworkInterfaces(p *permissionProbe, _ string) error {
+	request := vpc.NewDescribeNetworkInterfacesRequest()
+	request.Limit = common.Uint64Ptr(1)
+	_, err := p.client.DescribeNetworkInterfaces(request)
+	return err
+}
+
+func probeDescribeAddressTemplates(p *permissionProbe, _ string) error {
+	request := vpc.NewDescribeAddressTemplatesRequest()
+	request.Limit = common.StringPtr("1")
+	_, err := p.client.DescribeAddressTemplates(request)
+	return err
+}
+
+// probeCreateAddressTemplate 使用无效的地址，请求不会创建模板。参数错误可能先于鉴权返回，
+// 因此只有未授权时能得出结论，其余结果为无法判断
+func probeCreateAddressTemplate(p *permissionProbe, _ string) error {
+	request := vpc.NewCreateAddressTemplateRequest()
+	request.AddressTemplateName = common.StringPtr(permissionProbeDescription)
+	request.Addresses = common.StringPtrs([]string{"invalid-address"})
+	_, err := p.client.CreateAddressTemplate(request)
+	return err
+}
+
+// probeModifyAddressTemplate 使用不存在的模板 ID 和无效的地址，请求不会修改任何模板，
+// 结果的判断同 probeCreateAddressTemplate
+func probeModifyAddressTemplate(p *permissionProbe, _ string) error {
+	request := vpc.NewModifyAddressTemplateAttributeRequest()
+	request.AddressTemplateId = common.StringPtr(permissionProbeAddressTemplateId)
+	request.Addresses = common.StringPtrs([]string{"invalid-address"})
+	_, err := p.client.ModifyAddressTemplateAttribute(request)
+	return err
+}
+
+func probeDescribeServiceTemplates(p *permissionProbe, _ string) error {
+	request := vpc.NewDescribeServiceTemplatesRequest()
+	request.Limit = common.StringPtr("1")
+	_, err := p.client.DescribeServiceTemplates(request)
+	return err
+}
+
+// probeCreateServiceTemplate 使用无效的协议端口，请求不会创建模板，结果的判断同 probeCreateAddressTemplate
+func probeCreateServiceTemplate(p *permissionProbe, _ string) error {
+	request := vpc.NewCreateServiceTemplateRequest()
+	request.ServiceTemplateName = common.StringPtr(permissionProbeDescription)
+	request.Services = common.StringPtrs([]string{"invalid:port"})
+	_, err := p.client.CreateServiceTemplate(request)
+	return err
+}
+
+// probeModifyServiceTemplate 使用不存在的模板 ID 和无效的协议端口，请求不会修改任何模板，
+// 结果的判断同 probeCreateAddressTemplate
+func probeModifyServiceTemplate(p *permissionProbe, _ string) error {
+	request := vpc.NewModifyServiceTemplateAttributeRequest()
+	request.ServiceTemplateId = common.StringPtr(permissionProbeServiceTemplateId)
+	request.Services = common.StringPtrs([]string{"invalid:port"})
+	_, err := p.client.ModifyServiceTemplateAttribute(request)
+	return err
+}
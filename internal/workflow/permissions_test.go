@@ -0,0 +1,117 @@
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	tcErrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+func TestClassifyPermissionError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want permissionStatus
+	}{
+		{nil, permissionAllowed},
+		{tcErrors.NewTencentCloudSDKError("UnsupportedOperation.VersionMismatch", "", ""), permissionAllowed},
+		{tcErrors.NewTencentCloudSDKError("ResourceNotFound", "", ""), permissionUnknown},
+		{tcErrors.NewTencentCloudSDKError("InvalidParameterValue", "", ""), permissionUnknown},
+		{tcErrors.NewTencentCloudSDKError("AuthFailure.UnauthorizedOperation", "", ""), permissionDenied},
+		{tcErrors.NewTencentCloudSDKError("UnauthorizedOperation.NoPermission", "", ""), permissionDenied},
+		{tcErrors.NewTencentCloudSDKError("AuthFailure.SecretIdNotFound", "", ""), permissionUnknown},
+		{errors.New("dial tcp: timeout"), permissionUnknown},
+	}
+	for _, tt := range tests {
+		if got, _ := classifyPermissionError(tt.err); got != tt.want {
+			t.Errorf("classifyPermissionError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPermissionsWorstGroup(t *testing.T) {
+	denied := tcErrors.NewTencentCloudSDKError("UnauthorizedOperation", "", "")
+	actions := []camAction{
+		{"vpc:CreateSecurityGroupPolicies", true, func(_ *permissionProbe, group string) error {
+			if group == "sg-bbbbbbbb" {
+				return denied
+			}
+			return nil
+		}},
+		{"vpc:DescribeAddressTemplates", false, func(*permissionProbe, string) error { return nil }},
+	}
+	results := checkPermissions(&permissionProbe{}, actions, []string{"sg-aaaaaaaa", "sg-bbbbbbbb"})
+	if results[0].status != permissionDenied || results[1].status != permissionAllowed {
+		t.Errorf("results = %+v", results)
+	}
+	if missing := missingCAMActions(results); len(missing) != 1 || missing[0] != "vpc:CreateSecurityGroupPolicies" {
+		t.Errorf("missing = %v", missing)
+	}
+}
+
+func TestPermissionSummary(t *testing.T) {
+	allowed := permissionResult{action: "vpc:DescribeSecurityGroupPolicies", status: permissionAllowed}
+	unknown := permissionResult{action: "vpc:ModifyAddressTemplateAttribute", status: permissionUnknown}
+	denied := permissionResult{action: "vpc:CreateSecurityGroupPolicies", status: permissionDenied}
+	tests := []struct {
+		results []permissionResult
+		want    string
+	}{
+		{[]permissionResult{allowed}, IconOpen + " 所有 CAM 权限均已授权"},
+		{[]permissionResult{allowed, unknown}, IconUnknown + " 未发现缺少的 CAM 权限，1 个无法判断: vpc:ModifyAddressTemplateAttribute"},
+		{[]permissionResult{denied, unknown}, "缺少 1 个 CAM 权限: vpc:CreateSecurityGroupPolicies；另有 1 个无法判断"},
+	}
+	for _, tt := range tests {
+		if got := permissionSummary(tt.results); got != tt.want {
+			t.Errorf("permissionSummary = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMinimalCAMPolicy(t *testing.T) {
+	cfg := &config.Config{SecurityGroupId: "sg-aaaaaaaa", AddressTemplate: "1"}
+	policy, err := minimalCAMPolicy("ap-guangzhou", requiredCAMActions(cfg), []string{"sg-aaaaaaaa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed struct {
+		Version   string `json:"version"`
+		Statement []struct {
+			Action   []string `json:"action"`
+			Resource []string `json:"resource"`
+		} `json:"statement"`
+	}
+	if err := json.Unmarshal([]byte(policy), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Version != "2.0" || len(parsed.Statement) != 2 {
+		t.Fatalf("policy = %s", policy)
+	}
+	if got := parsed.Statement[0].Resource; len(got) != 1 || got[0] != "qcs::vpc:ap-guangzhou::securitygroup/sg-aaaaaaaa" {
+		t.Errorf("scoped resource = %v", got)
+	}
+	if len(parsed.Statement[0].Action) != 3 || len(parsed.Statement[1].Action) != 5 || parsed.Statement[1].Resource[0] != "*" {
+		t.Errorf("policy = %s", policy)
+	}
+
+	// 配置了安全组且未启用模板时，无法限定资源的只有地域和配额查询接口
+	parsed.Statement = nil
+	policy, _ = minimalCAMPolicy("ap-guangzhou", requiredCAMActions(&config.Config{SecurityGroupId: "sg-aaaaaaaa"}), []string{"sg-aaaaaaaa"})
+	json.Unmarshal([]byte(policy), &parsed)
+	if len(parsed.Statement) != 2 {
+		t.Fatalf("policy = %s", policy)
+	}
+	unscoped := parsed.Statement[1].Action
+	if len(unscoped) != 2 || unscoped[0] != "cvm:DescribeRegions" || unscoped[1] != "vpc:DescribeSecurityGroupLimits" {
+		t.Errorf("unscoped actions = %v", unscoped)
+	}
+}
+
+func TestStaleVersion(t *testing.T) {
+	for version, want := range map[string]string{"12": "11", "0": staleVersionFallback, "": staleVersionFallback} {
+		if got := staleVersion(version); got != want {
+			t.Errorf("staleVersion(%q) = %s, want %s", version, got, want)
+		}
+	}
+}
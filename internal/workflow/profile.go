@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// ProfileCommand 列出配置文件中的 profile，或通过 profile use <name> 切换当前 profile
+func ProfileCommand(wf *aw.Workflow, args []string) {
+	if len(args) >= 3 && args[1] == "use" {
+		useProfile(wf, args[2])
+		return
+	}
+	showProfiles(wf)
+}
+
+// showProfiles 展示所有 profile，回车切换
+func showProfiles(wf *aw.Workflow) {
+	file, err := config.LoadFile()
+	if err != nil {
+		wf.NewItem("读取配置文件失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
+	path, _ := config.FilePath()
+	if len(file.Profiles) == 0 {
+		wf.NewItem("配置文件中没有 profile").
+			Subtitle("在 " + path + " 的 profiles 中添加后重试").
+			Valid(false)
+		wf.SendFeedback()
+		return
+	}
+
+	cfg, _ := config.LoadPartial()
+	for _, name := range file.ProfileNames() {
+		profile := file.Profiles[name]
+		title := name
+		if cfg != nil && cfg.Profile == name {
+			title = IconOpen + " " + name + "（当前）"
+		}
+		subtitle := fmt.Sprintf("区域: %s | 后端: %s", profile.Region, profileProvider(profile))
+		if ids := profile.SecurityGroups(); len(ids) > 0 {
+			subtitle += " | 安全组: " + strings.Join(ids, ", ")
+		}
+		wf.NewItem(title).
+			Subtitle(subtitle).
+			Arg("profile use " + name).
+			Valid(true)
+	}
+	if env := os.Getenv("PROFILE"); env != "" {
+		wf.NewItem("⚠️ 已通过环境变量 PROFILE 指定: " + env).
+			Subtitle("环境变量优先于 profile use 的选择").
+			Valid(false)
+	}
+	wf.SendFeedback()
+}
+
+// profileProvider 返回 profile 的规则后端，未配置时为腾讯云
+func profileProvider(profile *config.Config) string {
+	if profile.Provider == "" {
+		return config.ProviderTencent
+	}
+	return profile.Provider
+}
+
+// useProfile 切换当前 profile
+func useProfile(wf *aw.Workflow, name string) {
+	if err := config.UseProfile(name); err != nil {
+		log.Error("切换 profile 失败: %v", err)
+		wf.NewItem("切换 profile 失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+	} else {
+		log.Info("已切换到 profile: %s", name)
+		wf.NewItem("已切换到 profile: " + name).Valid(false)
+	}
+	wf.SendFeedback()
+}
@@ -0,0 +1,238 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+const (
+	// regionCacheName 缓存 DescribeRegions 查询到的地域列表
+	regionCacheName = "regions.json"
+	// regionCacheMaxAge 地域列表很少变化，缓存一周
+	regionCacheMaxAge = 7 * 24 * time.Hour
+	// regionFailureCacheName 记录最近一次 DescribeRegions 失败，避免每次调用都等待超时
+	regionFailureCacheName = "regions-failed.txt"
+	// regionFailureMaxAge 查询失败后在此时间内不再重试
+	regionFailureMaxAge = 10 * time.Minute
+	// regionQueryRegion 调用 DescribeRegions 时使用的地域，该接口返回所有地域，与调用地域无关
+	regionQueryRegion = "ap-guangzhou"
+)
+
+// regionInfo 腾讯云地域
+type regionInfo struct {
+	Region string `json:"region"`
+	Name   string `json:"name"`
+}
+
+// fallbackRegions 无法调用 DescribeRegions（未配置密钥或网络不可用）时使用的内置地域列表
+var fallbackRegions = []regionInfo{
+	{"ap-guangzhou", "华南地区(广州)"},
+	{"ap-shanghai", "华东地区(上海)"},
+	{"ap-nanjing", "华东地区(南京)"},
+	{"ap-beijing", "华北地区(北京)"},
+	{"ap-chengdu", "西南地区(成都)"},
+	{"ap-chongqing", "西南地区(重庆)"},
+	{"ap-hongkong", "港澳台地区(中国香港)"},
+	{"ap-singapore", "亚太东南(新加坡)"},
+	{"ap-jakarta", "亚太东南(雅加达)"},
+	{"ap-seoul", "亚太东北(首尔)"},
+	{"ap-tokyo", "亚太东北(东京)"},
+	{"ap-bangkok", "亚太东南(曼谷)"},
+	{"ap-mumbai", "亚太南部(孟买)"},
+	{"na-siliconvalley", "美国西部(硅谷)"},
+	{"na-ashburn", "美国东部(弗吉尼亚)"},
+	{"eu-frankfurt", "欧洲地区(法兰克福)"},
+	{"sa-saopaulo", "南美地区(圣保罗)"},
+}
+
+// regionPattern 其他云厂商的区域格式，如 ap-northeast-1、cn-hangzhou
+var regionPattern = regexp.MustCompile(`^[a-z]{2,}(-[a-z0-9]+)+$`)
+
+// usesTencentRegions 返回后端是否使用腾讯云地域
+func usesTencentRegions(provider string) bool {
+	return provider == "" || provider == config.ProviderTencent || provider == config.ProviderLighthouse
+}
+
+// knownRegions 返回腾讯云地域列表：有密钥时通过 DescribeRegions 查询并缓存，
+// 没有密钥或查询失败时依次退回过期的缓存和内置列表。live 表示列表来自未过期的查询结果，
+// 为 false 时列表可能缺少新开放的地域，不在列表中的地域只应给出警告
+func knownRegions(wf *aw.Workflow, cred *config.Credential) (regions []regionInfo, live bool) {
+	var fetch func() ([]regionInfo, error)
+	if cred.SecretId != "" && cred.SecretKey != "" {
+		fetch = func() ([]regionInfo, error) {
+			return describeRegions(cred)
+		}
+	}
+	return loadRegions(wf, fetch)
+}
+
+// loadRegions 按 knownRegions 的顺序取地域列表，fetch 为 nil 表示无法查询。
+// 查询失败会记录在缓存中，regionFailureMaxAge 内不再重试
+func loadRegions(wf *aw.Workflow, fetch func() ([]regionInfo, error)) (regions []regionInfo, live bool) {
+	if wf.Cache.Exists(regionCacheName) && !wf.Cache.Expired(regionCacheName, regionCacheMaxAge) {
+		if err := wf.Cache.LoadJSON(regionCacheName, &regions); err == nil && len(regions) > 0 {
+			return regions, true
+		}
+	}
+	recentFailure := wf.Cache.Exists(regionFailureCacheName) && !wf.Cache.Expired(regionFailureCacheName, regionFailureMaxAge)
+	if fetch != nil && !recentFailure {
+		fetched, err := fetch()
+		if err == nil {
+			if err := wf.Cache.StoreJSON(regionCacheName, fetched); err != nil {
+				log.Warn("缓存地域列表失败: %v", err)
+			}
+			return fetched, true
+		}
+		log.Warn("查询地域列表失败，%s 内不再重试，使用缓存或内置列表: %v", regionFailureMaxAge, err)
+		if err := wf.Cache.Store(regionFailureCacheName, []byte(err.Error())); err != nil {
+			log.Warn("记录地域查询失败: %v", err)
+		}
+	}
+	if wf.Cache.Exists(regionCacheName) {
+		if err := wf.Cache.LoadJSON(regionCacheName, &regions); err == nil && len(regions) > 0 {
+			return regions, false
+		}
+	}
+	return fallbackRegions, false
+}
+
+// describeRegions 通过 CVM DescribeRegions 查询可用地域
+func describeRegions(cred *config.Credential) ([]regionInfo, error) {
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "cvm.tencentcloudapi.com"
+	client := common.NewCommonClient(tencentCredential(cred), regionQueryRegion, cpf)
+
+	request := tchttp.NewCommonRequest("cvm", "2017-03-12", "DescribeRegions")
+	response := tchttp.NewCommonResponse()
+	if err := client.Send(request, response); err != nil {
+		return nil, sdkError("查询地域列表", err)
+	}
+
+	var body struct {
+		Response struct {
+			RegionSet []struct {
+				Region      string `json:"Region"`
+				RegionName  string `json:"RegionName"`
+				RegionState string `json:"RegionState"`
+			} `json:"RegionSet"`
+		} `json:"Response"`
+	}
+	if err := json.Unmarshal(response.GetBody(), &body); err != nil {
+		return nil, fmt.Errorf("解析 DescribeRegions 响应失败: %w", err)
+	}
+	var regions []regionInfo
+	for _, r := range body.Response.RegionSet {
+		if r.RegionState == "AVAILABLE" {
+			regions = append(regions, regionInfo{Region: r.Region, Name: r.RegionName})
+		}
+	}
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("DescribeRegions 未返回可用地域")
+	}
+	log.Info("查询到 %d 个可用地域", len(regions))
+	return regions, nil
+}
+
+// validateRegion 校验区域：腾讯云后端需在地域列表中，AWS、阿里云只校验格式，本机防火墙后端不使用区域
+func validateRegion(provider, region string, regions []regionInfo) error {
+	switch {
+	case usesTencentRegions(provider):
+		for _, known := range regions {
+			if region == known.Region {
+				return nil
+			}
+		}
+		if suggestion := closestRegion(region, regions); suggestion != "" {
+			return fmt.Errorf("未知的腾讯云地域: %s，是否为 %s？", region, suggestion)
+		}
+		return fmt.Errorf("未知的腾讯云地域: %s", region)
+	case provider == config.ProviderAWS || provider == config.ProviderAliyun:
+		if !regionPattern.MatchString(region) {
+			return fmt.Errorf("区域格式不正确: %s", region)
+		}
+	}
+	return nil
+}
+
+// checkRegionList 校验区域，地域列表不是查询所得（live 为 false）时可能缺少新开放的地域，
+// 此时只有与已知地域相近、像是拼写错误的区域返回错误，其余只记录警告
+func checkRegionList(provider, region string, regions []regionInfo, live bool) error {
+	err := validateRegion(provider, region, regions)
+	if err == nil || live || !usesTencentRegions(provider) || closestRegion(region, regions) != "" {
+		return err
+	}
+	log.Warn("%v，地域列表不是查询所得，不阻止使用", err)
+	return nil
+}
+
+// closestRegion 返回与输入编辑距离最近（不超过 3）的地域，用于提示拼写错误
+func closestRegion(region string, regions []regionInfo) string {
+	best, bestDistance := "", 4
+	for _, known := range regions {
+		if d := editDistance(region, known.Region); d < bestDistance {
+			best, bestDistance = known.Region, d
+		}
+	}
+	return best
+}
+
+// editDistance 计算两个字符串的编辑距离
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkRegion 在调用云 API 前校验 REGION，拼写错误时给出明确提示，而不是等到 SDK 报错
+func checkRegion(wf *aw.Workflow, cfg *config.Config, cred *config.Credential) bool {
+	var regions []regionInfo
+	live := true
+	if usesTencentRegions(cfg.Provider) {
+		regions, live = knownRegions(wf, cred)
+	}
+	if err := checkRegionList(cfg.Provider, cfg.Region, regions, live); err != nil {
+		log.Error("REGION 无效: %v", err)
+		wf.NewItem(err.Error()).Subtitle("请使用 'frp config set_region' 重新设置").Valid(false).Icon(aw.IconWarning)
+		wf.SendFeedback()
+		return false
+	}
+	return true
+}
+
+// regionCandidates 返回 set_region 的候选值，腾讯云后端使用地域列表
+func regionCandidates(wf *aw.Workflow, cfg *config.Config) []settingCandidate {
+	if !usesTencentRegions(cfg.Provider) {
+		return nil
+	}
+	var candidates []settingCandidate
+	regions, _ := knownRegions(wf, currentCredential(wf, cfg))
+	for _, r := range regions {
+		candidates = append(candidates, settingCandidate{value: r.Region, title: strings.TrimSpace(r.Name)})
+	}
+	return candidates
+}
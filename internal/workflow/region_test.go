@@ -0,0 +1,102 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+func TestValidateRegion(t *testing.T) {
+	tests := []struct {
+		provider, region string
+		ok               bool
+	}{
+		{"", "ap-guangzhou", true},
+		{config.ProviderLighthouse, "ap-hongkong", true},
+		{config.ProviderTencent, "ap-guangzou", false},
+		{config.ProviderTencent, "us-east-1", false},
+		{config.ProviderAWS, "us-east-1", true},
+		{config.ProviderAliyun, "cn-hangzhou", true},
+		{config.ProviderAliyun, "Hangzhou", false},
+		{config.ProviderNftables, "anything", true},
+	}
+	for _, tt := range tests {
+		if err := validateRegion(tt.provider, tt.region, fallbackRegions); (err == nil) != tt.ok {
+			t.Errorf("validateRegion(%q, %q) = %v", tt.provider, tt.region, err)
+		}
+	}
+
+	err := validateRegion("", "ap-shanghia", fallbackRegions)
+	if err == nil || !strings.Contains(err.Error(), "ap-shanghai") {
+		t.Errorf("typo should suggest ap-shanghai, got %v", err)
+	}
+	if got := closestRegion("eu-moscow", fallbackRegions); got != "" {
+		t.Errorf("closestRegion(eu-moscow) = %s, want no suggestion", got)
+	}
+}
+
+func TestKnownRegionsFallback(t *testing.T) {
+	wf := newTestWorkflow(t)
+	if got, live := knownRegions(wf, &config.Credential{}); len(got) != len(fallbackRegions) || live {
+		t.Errorf("without credentials or cache got %d regions, live=%v", len(got), live)
+	}
+
+	// 没有密钥时也优先使用缓存的地域列表
+	cached := []regionInfo{{Region: "ap-new", Name: "新地域"}}
+	if err := wf.Cache.StoreJSON(regionCacheName, cached); err != nil {
+		t.Fatal(err)
+	}
+	got, live := knownRegions(wf, &config.Credential{})
+	if len(got) != 1 || got[0].Region != "ap-new" || !live {
+		t.Errorf("knownRegions = %+v, %v, want cached list", got, live)
+	}
+	if err := validateRegion("", "ap-new", got); err != nil {
+		t.Errorf("cached region rejected: %v", err)
+	}
+}
+
+func TestLoadRegionsNegativeCache(t *testing.T) {
+	wf := newTestWorkflow(t)
+	calls := 0
+	failing := func() ([]regionInfo, error) {
+		calls++
+		return nil, errors.New("dial tcp: i/o timeout")
+	}
+	for i := 0; i < 3; i++ {
+		if got, live := loadRegions(wf, failing); live || len(got) != len(fallbackRegions) {
+			t.Errorf("failed query should fall back to the built-in list, live=%v", live)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("DescribeRegions called %d times, failure should be cached", calls)
+	}
+
+	// 地域列表来自内置列表时，列表外的地域不阻止调用
+	cfg := &config.Config{Provider: config.ProviderTencent, Region: "ap-taipei"}
+	if !checkRegion(wf, cfg, &config.Credential{}) {
+		t.Error("unknown region should only warn when the list is not live")
+	}
+	if err := checkRegionList("", "ap-shanghia", fallbackRegions, false); err == nil {
+		t.Error("a likely typo should still be rejected")
+	}
+	if err := checkRegionList("", "na-toronto", []regionInfo{{Region: "ap-guangzhou"}}, true); err == nil {
+		t.Error("unknown region should be rejected when the list is live")
+	}
+
+	if err := wf.Cache.Store(regionFailureCacheName, nil); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(wf.CacheDir(), regionFailureCacheName), time.Now().Add(-regionFailureMaxAge-time.Minute), time.Now().Add(-regionFailureMaxAge-time.Minute))
+	got, live := loadRegions(wf, func() ([]regionInfo, error) {
+		calls++
+		return []regionInfo{{Region: "ap-taipei"}}, nil
+	})
+	if calls != 2 || !live || len(got) != 1 {
+		t.Errorf("expired failure should retry: calls=%d live=%v regions=%+v", calls, live, got)
+	}
+}
@@ -0,0 +1,384 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// serviceMember 协议端口模板中的一个成员，Description 为服务的 AlfredFRP_ 备注
+type serviceMember struct {
+	Protocol    string
+	Port        string
+	Description string
+}
+
+// service 返回模板成员的 "tcp:8080" 写法
+func (m serviceMember) service() string {
+	return strings.ToLower(m.Protocol) + ":" + m.Port
+}
+
+// parseServiceMember 解析 "tcp:8080" 或 "udp:7000-7010" 形式的模板成员
+func parseServiceMember(service, description string) serviceMember {
+	protocol, port, found := strings.Cut(service, ":")
+	if !found {
+		port = "ALL"
+	}
+	return serviceMember{Protocol: strings.ToUpper(protocol), Port: port, Description: description}
+}
+
+// serviceTemplateMembers 返回模板中的全部成员
+func serviceTemplateMembers(template *vpc.ServiceTemplate) []serviceMember {
+	var members []serviceMember
+	for _, extra := range template.ServiceExtraSet {
+		members = append(members, parseServiceMember(stringValue(extra.Service), stringValue(extra.Description)))
+	}
+	if len(members) == 0 {
+		for _, service := range template.ServiceSet {
+			members = append(members, parseServiceMember(stringValue(service), ""))
+		}
+	}
+	return members
+}
+
+// addServiceMember 将服务加入成员列表，同名服务或相同协议端口的旧成员会被替换
+func addServiceMember(members []serviceMember, member serviceMember) []serviceMember {
+	key := ruleKey(member.Description)
+	result := make([]serviceMember, 0, len(members)+1)
+	for _, existing := range members {
+		if existing.service() == member.service() || replacesRule(existing.Description, key) {
+			continue
+		}
+		result = append(result, existing)
+	}
+	return append(result, member)
+}
+
+// removeServiceMember 从成员列表中移除服务，found 表示成员是否存在
+func removeServiceMember(members []serviceMember, protocol, port, description string) (result []serviceMember, found bool) {
+	for _, existing := range members {
+		if strings.EqualFold(existing.Protocol, protocol) && existing.Port == port && existing.Description == description {
+			found = true
+			continue
+		}
+		result = append(result, existing)
+	}
+	return result, found
+}
+
+// serviceTemplateOwners 返回安全组中引用协议端口模板的放行规则所属的属主，按规则备注中的模板名称判断
+func serviceTemplateOwners(set *vpc.SecurityGroupPolicySet) []string {
+	var owners []string
+	for _, policy := range set.Ingress {
+		if policy.ServiceTemplate == nil || stringValue(policy.Action) != "ACCEPT" {
+			continue
+		}
+		if owner, ok := strings.CutPrefix(stringValue(policy.PolicyDescription), templateNamePrefix); ok && owner != "" {
+			owners = append(owners, owner)
+		}
+	}
+	return owners
+}
+
+// describeServiceTemplate 按名称查询协议端口模板，不存在时返回 nil
+func describeServiceTemplate(client *vpc.Client, name string) (*vpc.ServiceTemplate, error) {
+	request := vpc.NewDescribeServiceTemplatesRequest()
+	request.Filters = []*vpc.Filter{
+		{Name: common.StringPtr("service-template-name"), Values: common.StringPtrs([]string{name})},
+	}
+	response, err := client.DescribeServiceTemplates(request)
+	if err != nil {
+		return nil, sdkError("查询协议端口模板", err)
+	}
+	if response.Response == nil {
+		return nil, nil
+	}
+	for _, template := range response.Response.ServiceTemplateSet {
+		// 名称过滤为模糊匹配，需要再精确比对一次
+		if stringValue(template.ServiceTemplateName) == name {
+			return template, nil
+		}
+	}
+	return nil, nil
+}
+
+// saveServiceTemplate 写入模板成员，模板不存在（id 为空）时创建，返回模板 ID
+func saveServiceTemplate(client *vpc.Client, name, id string, members []serviceMember) (string, error) {
+	var services []*vpc.ServicesInfo
+	for _, member := range members {
+		services = append(services, &vpc.ServicesInfo{
+			Service:     common.StringPtr(member.service()),
+			Description: common.StringPtr(member.Description),
+		})
+	}
+
+	if id == "" {
+		request := vpc.NewCreateServiceTemplateRequest()
+		request.ServiceTemplateName = common.StringPtr(name)
+		request.ServicesExtra = services
+		response, err := client.CreateServiceTemplate(request)
+		if err != nil {
+			return "", sdkError("创建协议端口模板", err)
+		}
+		if response.Response == nil || response.Response.ServiceTemplate == nil {
+			return "", fmt.Errorf("创建协议端口模板 %s 未返回模板信息", name)
+		}
+		id = stringValue(response.Response.ServiceTemplate.ServiceTemplateId)
+		log.Info("创建协议端口模板 %s(%s)，成员 %d 个", name, id, len(members))
+		return id, nil
+	}
+
+	request := vpc.NewModifyServiceTemplateAttributeRequest()
+	request.ServiceTemplateId = common.StringPtr(id)
+	request.ServicesExtra = services
+	if _, err := client.ModifyServiceTemplateAttribute(request); err != nil {
+		return "", sdkError("更新协议端口模板", err)
+	}
+	log.Info("更新协议端口模板 %s(%s)，成员 %d 个", name, id, len(members))
+	return id, nil
+}
+
+// findServiceTemplatePolicy 在规则快照中查找引用协议端口模板的放行规则
+func findServiceTemplatePolicy(set *vpc.SecurityGroupPolicySet, templateId string) *vpc.SecurityGroupPolicy {
+	for _, policy := range set.Ingress {
+		if policy.ServiceTemplate != nil && stringValue(policy.ServiceTemplate.ServiceId) == templateId &&
+			stringValue(policy.Action) == "ACCEPT" {
+			return policy
+		}
+	}
+	return nil
+}
+
+// serviceTemplateRules 将引用协议端口模板的规则按模板成员展开为逐服务规则，以 proxy name 为 key
+func serviceTemplateRules(set *vpc.SecurityGroupPolicySet, template *vpc.ServiceTemplate, securityGroupId string) map[string]FetchedRuleInfo {
+	rules := make(map[string]FetchedRuleInfo)
+	policy := findServiceTemplatePolicy(set, stringValue(template.ServiceTemplateId))
+	if policy == nil {
+		return rules
+	}
+	var policyIndex int64 = -1
+	if policy.PolicyIndex != nil {
+		policyIndex = *policy.PolicyIndex
+	}
+	for _, member := range serviceTemplateMembers(template) {
+		if !strings.HasPrefix(member.Description, "AlfredFRP_") {
+			continue
+		}
+		rules[ruleKey(member.Description)] = FetchedRuleInfo{
+			PolicyDescription: member.Description,
+			Protocol:          member.Protocol,
+			Port:              member.Port,
+			CidrBlock:         policySource(policy),
+			PolicyIndex:       policyIndex,
+			ModifyTime:        stringValue(policy.ModifyTime),
+			Action:            "ACCEPT",
+			LocalPort:         extractLocalPort(member.Description),
+			SecurityGroupId:   securityGroupId,
+		}
+	}
+	return rules
+}
+
+// ensureServiceTemplateRuleInGroup 确保安全组中存在 来源 × 协议端口模板 的放行规则。
+// 来源变化时替换原规则，同时删除该服务遗留的逐服务规则。
+func ensureServiceTemplateRuleInGroup(client *vpc.Client, securityGroupId, templateId, source, ruleDescription, serviceName, protocol, port string) error {
+	return updateSecurityGroupPolicies(client, securityGroupId, func(set *vpc.SecurityGroupPolicySet) error {
+		version := set.Version
+		existing := findServiceTemplatePolicy(set, templateId)
+
+		var stalePolicies []*vpc.SecurityGroupPolicy
+		if existing != nil && policySource(existing) != source {
+			log.Info("模板规则来源由 %s 变为 %s，替换规则", policySource(existing), source)
+			stalePolicies = append(stalePolicies, policyMatchSpec(existing))
+		}
+		for _, policy := range set.Ingress {
+			description := stringValue(policy.PolicyDescription)
+			if replacesRule(description, serviceName) &&
+				strings.EqualFold(stringValue(policy.Protocol), protocol) && stringValue(policy.Port) == port {
+				log.Info("删除服务 %s 遗留的逐服务规则: %s", serviceName, description)
+				stalePolicies = append(stalePolicies, policyMatchSpec(policy))
+			}
+		}
+
+		if len(stalePolicies) > 0 {
+			deleteRequest := vpc.NewDeleteSecurityGroupPoliciesRequest()
+			deleteRequest.SecurityGroupId = common.StringPtr(securityGroupId)
+			deleteRequest.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+				Version: version,
+				Ingress: stalePolicies,
+			}
+			if _, err := client.DeleteSecurityGroupPolicies(deleteRequest); err != nil {
+				if isVersionMismatch(err) {
+					return err
+				}
+				return sdkError("删除旧规则", err)
+			}
+			current, err := currentPolicyVersion(client, securityGroupId)
+			if err != nil {
+				return err
+			}
+			version = current
+		}
+
+		if existing != nil && policySource(existing) == source {
+			log.Info("安全组 %s 已存在模板规则，无需创建", securityGroupId)
+			return nil
+		}
+
+		policy := &vpc.SecurityGroupPolicy{
+			ServiceTemplate:   &vpc.ServiceTemplateSpecification{ServiceId: common.StringPtr(templateId)},
+			Action:            common.StringPtr("ACCEPT"),
+			PolicyDescription: common.StringPtr(ruleDescription),
+		}
+		setPolicySource(policy, source)
+		request := vpc.NewCreateSecurityGroupPoliciesRequest()
+		request.SecurityGroupId = common.StringPtr(securityGroupId)
+		request.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+			Version: version,
+			Ingress: []*vpc.SecurityGroupPolicy{policy},
+		}
+		if _, err := client.CreateSecurityGroupPolicies(request); err != nil {
+			if isVersionMismatch(err) {
+				return err
+			}
+			return sdkError("创建模板规则", err)
+		}
+		log.Info("安全组 %s 创建模板规则成功，来源: %s，协议端口模板: %s", securityGroupId, source, templateId)
+		return nil
+	})
+}
+
+// deleteServiceTemplateRuleInGroup 删除安全组中引用协议端口模板的规则，不存在时返回 errRuleNotInGroup
+func deleteServiceTemplateRuleInGroup(client *vpc.Client, securityGroupId, templateId string) error {
+	return updateSecurityGroupPolicies(client, securityGroupId, func(set *vpc.SecurityGroupPolicySet) error {
+		existing := findServiceTemplatePolicy(set, templateId)
+		if existing == nil {
+			return errRuleNotInGroup
+		}
+		request := vpc.NewDeleteSecurityGroupPoliciesRequest()
+		request.SecurityGroupId = common.StringPtr(securityGroupId)
+		request.SecurityGroupPolicySet = &vpc.SecurityGroupPolicySet{
+			Version: set.Version,
+			Ingress: []*vpc.SecurityGroupPolicy{policyMatchSpec(existing)},
+		}
+		if _, err := client.DeleteSecurityGroupPolicies(request); err != nil {
+			if isVersionMismatch(err) {
+				return err
+			}
+			return sdkError("删除模板规则", err)
+		}
+		log.Info("安全组 %s 中的模板规则已删除", securityGroupId)
+		return nil
+	})
+}
+
+// serviceTemplateState 记录本次运行中对协议端口模板的修改，多个安全组只需修改一次模板
+type serviceTemplateState struct {
+	name     string
+	loaded   bool
+	template *vpc.ServiceTemplate
+	id       string
+
+	opened   bool // 本次运行已加入成员
+	closed   bool // 本次运行已处理关闭
+	fallback bool // 待关闭的服务不在模板中，按逐服务规则关闭
+	emptied  bool // 关闭的是最后一个成员，需要删除模板规则
+}
+
+// load 读取协议端口模板，每次运行只查询一次
+func (s *serviceTemplateState) load(client *vpc.Client) (*vpc.ServiceTemplate, error) {
+	if s.loaded {
+		return s.template, nil
+	}
+	template, err := describeServiceTemplate(client, s.name)
+	if err != nil {
+		return nil, err
+	}
+	s.loaded = true
+	s.template = template
+	if template != nil {
+		s.id = stringValue(template.ServiceTemplateId)
+	}
+	return template, nil
+}
+
+// openWithServiceTemplate 将服务加入协议端口模板，并确保安全组中存在 来源 × 模板 的规则。
+// 所有安全组都没有模板规则时，模板中的成员均已失效，以本次开放的服务重置模板。
+func (b *vpcBackend) openWithServiceTemplate(group, serviceName, protocol, port, source, description string) error {
+	state := b.serviceTemplate
+	if !state.opened {
+		template, err := state.load(b.client)
+		if err != nil {
+			return err
+		}
+		var members []serviceMember
+		if template != nil {
+			for _, securityGroupId := range b.groups {
+				set, err := describeSecurityGroupPolicySet(b.client, securityGroupId)
+				if err != nil {
+					return sdkError("查询安全组规则", err)
+				}
+				if findServiceTemplatePolicy(set, state.id) != nil {
+					members = serviceTemplateMembers(template)
+					break
+				}
+			}
+		}
+		members = addServiceMember(members, serviceMember{Protocol: protocol, Port: port, Description: description})
+		id, err := saveServiceTemplate(b.client, state.name, state.id, members)
+		if err != nil {
+			return err
+		}
+		state.id = id
+		state.opened = true
+	}
+	return ensureServiceTemplateRuleInGroup(b.client, group, state.id, source, state.name, serviceName, protocol, port)
+}
+
+// closeWithServiceTemplate 从规则属主的协议端口模板中移除服务，close --all 关闭其他人的服务时修改对方的模板；
+// 服务不在模板中时按逐服务规则关闭
+func (b *vpcBackend) closeWithServiceTemplate(group, protocol, port, cidrBlock, description string) error {
+	state := b.serviceTemplateOf(extractOwner(description))
+	if !state.closed {
+		template, err := state.load(b.client)
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			state.fallback = true
+		} else {
+			members, found := removeServiceMember(serviceTemplateMembers(template), protocol, port, description)
+			switch {
+			case !found:
+				state.fallback = true
+			case len(members) == 0:
+				// 协议端口模板不能为空，保留最后一个成员并删除引用模板的规则
+				state.emptied = true
+			default:
+				if _, err := saveServiceTemplate(b.client, state.name, state.id, members); err != nil {
+					return err
+				}
+			}
+		}
+		state.closed = true
+	}
+
+	if state.fallback {
+		return closeRuleInGroup(b.client, group, protocol, port, cidrBlock, description)
+	}
+	if state.emptied {
+		return deleteServiceTemplateRuleInGroup(b.client, group, state.id)
+	}
+	set, err := describeSecurityGroupPolicySet(b.client, group)
+	if err != nil {
+		return sdkError("查询安全组规则", err)
+	}
+	if findServiceTemplatePolicy(set, state.id) == nil {
+		return errRuleNotInGroup
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+func TestServiceTemplateMembership(t *testing.T) {
+	web := serviceMember{Protocol: "TCP", Port: "8080", Description: "AlfredFRP_web_local8080"}
+	game := serviceMember{Protocol: "UDP", Port: "7000-7010", Description: "AlfredFRP_game_local7000"}
+
+	members := addServiceMember(nil, web)
+	members = addServiceMember(members, game)
+	// 同名服务换端口后重新开放，旧成员被替换
+	webMoved := serviceMember{Protocol: "TCP", Port: "8081", Description: "AlfredFRP_web_local8080"}
+	members = addServiceMember(members, webMoved)
+	if want := []serviceMember{game, webMoved}; !reflect.DeepEqual(members, want) {
+		t.Fatalf("members = %+v, want %+v", members, want)
+	}
+
+	members, found := removeServiceMember(members, "tcp", "8081", "AlfredFRP_web_local8080")
+	if !found || !reflect.DeepEqual(members, []serviceMember{game}) {
+		t.Errorf("remove web: found=%v members=%+v", found, members)
+	}
+	if _, found := removeServiceMember(members, "TCP", "22", "AlfredFRP_ssh_local22"); found {
+		t.Error("removing a missing member should report not found")
+	}
+
+	if got := parseServiceMember(game.service(), game.Description); got != game {
+		t.Errorf("parseServiceMember(%s) = %+v, want %+v", game.service(), got, game)
+	}
+}
+
+func TestServiceTemplateRules(t *testing.T) {
+	template := &vpc.ServiceTemplate{
+		ServiceTemplateId: common.StringPtr("ppm-abc"),
+		ServiceExtraSet: []*vpc.ServicesInfo{
+			{Service: common.StringPtr("tcp:8080"), Description: common.StringPtr("AlfredFRP_web_local8080")},
+			{Service: common.StringPtr("udp:7000"), Description: common.StringPtr("AlfredFRP_game_local7000")},
+			{Service: common.StringPtr("tcp:22"), Description: common.StringPtr("manual")},
+		},
+	}
+	set := &vpc.SecurityGroupPolicySet{Ingress: []*vpc.SecurityGroupPolicy{{
+		ServiceTemplate:   &vpc.ServiceTemplateSpecification{ServiceId: common.StringPtr("ppm-abc")},
+		AddressTemplate:   &vpc.AddressTemplateSpecification{AddressId: common.StringPtr("ipm-abc")},
+		Action:            common.StringPtr("ACCEPT"),
+		PolicyDescription: common.StringPtr("AlfredFRP-alice"),
+	}}}
+
+	rules := serviceTemplateRules(set, template, "sg-1")
+	if len(rules) != 2 {
+		t.Fatalf("expanded %d rules, want 2: %+v", len(rules), rules)
+	}
+	if got := rules["game"]; got.Protocol != "UDP" || got.Port != "7000" || got.CidrBlock != "ipm-abc" ||
+		got.Action != "ACCEPT" || got.LocalPort != "7000" || got.SecurityGroupId != "sg-1" {
+		t.Errorf("game rule = %+v", got)
+	}
+
+	if rules := serviceTemplateRules(&vpc.SecurityGroupPolicySet{}, template, "sg-2"); len(rules) != 0 {
+		t.Errorf("group without template rule expanded to %+v", rules)
+	}
+}
+
+func TestServiceTemplateOfOwner(t *testing.T) {
+	set := &vpc.SecurityGroupPolicySet{Ingress: []*vpc.SecurityGroupPolicy{
+		{ServiceTemplate: &vpc.ServiceTemplateSpecification{ServiceId: common.StringPtr("ppm-a")}, Action: common.StringPtr("ACCEPT"), PolicyDescription: common.StringPtr("AlfredFRP-alice")},
+		{ServiceTemplate: &vpc.ServiceTemplateSpecification{ServiceId: common.StringPtr("ppm-b")}, Action: common.StringPtr("ACCEPT"), PolicyDescription: common.StringPtr("AlfredFRP-bob")},
+		{Protocol: common.StringPtr("TCP"), Action: common.StringPtr("ACCEPT"), PolicyDescription: common.StringPtr("AlfredFRP_ssh_local22@carol")},
+	}}
+	if got := serviceTemplateOwners(set); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("serviceTemplateOwners = %v", got)
+	}
+
+	b := &vpcBackend{serviceTemplate: &serviceTemplateState{name: ownerTemplateName("alice")}}
+	if b.serviceTemplateOf("alice") != b.serviceTemplate || b.serviceTemplateOf("") != b.serviceTemplate {
+		t.Error("own and legacy rules should use the own template")
+	}
+	bob := b.serviceTemplateOf("bob")
+	if bob == b.serviceTemplate || bob.name != "AlfredFRP-bob" || b.serviceTemplateOf("bob") != bob {
+		t.Errorf("bob's template state = %+v", bob)
+	}
+}
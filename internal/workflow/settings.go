@@ -0,0 +1,416 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	aw "github.com/deanishe/awgo"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+)
+
+// clearSettingValue 输入该值表示清除可选配置项
+const clearSettingValue = "-"
+
+// setting 描述一个可通过 frp config <action> <值> 设置的配置项
+type setting struct {
+	action   string // config 子命令，如 set_toml_path
+	key      string // 对应的环境变量名
+	title    string
+	hint     string // 输入提示
+	required bool   // 必填项不能清除
+	// normalize 校验输入并返回规范化后写入配置文件的值
+	normalize func(wf *aw.Workflow, cfg *config.Config, value string) (string, error)
+	// describe 可选，返回配置列表中展示的当前值说明，如默认值或生效的模板名
+	describe func(cfg *config.Config) string
+	// candidates 可选，返回输入值时的候选项
+	candidates func(wf *aw.Workflow, cfg *config.Config) []settingCandidate
+}
+
+// settingCandidate 配置项的候选值
+type settingCandidate struct {
+	value string
+	title string
+}
+
+// settings 所有可设置的配置项，按 fc 中的展示顺序排列
+var settings = []setting{
+	{action: "set_toml_path", key: "FRPC_TOML_PATH", title: "🔒 frpc.toml 路径", hint: "输入 frpc.toml 的路径", required: true, normalize: normalizeTomlPath},
+	{action: "set_provider", key: "PROVIDER", title: "🔒 规则后端", hint: "输入 tencent / lighthouse / aws / aliyun / nftables / iptables", normalize: normalizeProvider, describe: providerDescription, candidates: providerCandidates},
+	{action: "set_sgid", key: "SECURITY_GROUP_ID", title: "🔒 安全组 ID", hint: "输入安全组 ID，多个以英文逗号分隔，如 sg-aaaa,sg-bbbb", normalize: normalizeSecurityGroups},
+	{action: "set_instance", key: "INSTANCE_ID", title: "🖥 实例", hint: "输入实例 ID、实例名称或公网 IP", normalize: normalizeNoSpace},
+	{action: "set_region", key: "REGION", title: "🔒 API 区域", hint: "输入区域，如 ap-guangzhou", required: true, normalize: normalizeRegion, candidates: regionCandidates},
+	{action: "set_log_path", key: "LOG_PATH", title: "🔒 日志路径", hint: "输入日志文件路径，目录需可写", required: true, normalize: normalizeLogPath},
+	{action: "set_endpoint", key: "API_ENDPOINT", title: "🌐 API 地址", hint: "输入自定义云 API 地址，如 https://vpc.example.com", normalize: normalizeEndpoint},
+	{action: "set_address_template", key: "ADDRESS_TEMPLATE", title: "📇 IP 地址模板", hint: "输入 1 启用、0 关闭", normalize: normalizeBool, describe: addressTemplateSummary},
+	{action: "set_service_template", key: "SERVICE_TEMPLATE", title: "🧩 协议端口模板", hint: "输入 1 启用、0 关闭", normalize: normalizeBool, describe: serviceTemplateSummary},
+	{action: "set_ip_resolvers", key: "IP_RESOLVERS", title: "🌍 公网 IP 来源", hint: "输入来源，逗号分隔，如 https://api.ipify.org,stun:stun.l.google.com:19302", normalize: normalizeIPResolvers},
+	{action: "set_ip_timeout", key: "IP_TIMEOUT", title: "🌍 公网 IP 超时", hint: "输入超时，如 3s", normalize: normalizeIPTimeout},
+	{action: "set_ip_quorum", key: "IP_QUORUM", title: "🌍 公网 IP 一致来源数", hint: "输入正整数", normalize: positiveIntNormalizer(0)},
+	{action: "set_min_cidr_prefix", key: "MIN_CIDR_PREFIX", title: "🌍 最短网段前缀", hint: "输入 1-32 之间的整数", normalize: positiveIntNormalizer(32)},
+	{action: "set_owner", key: "OWNER", title: "👤 规则属主", hint: "输入属主，只能包含字母、数字和 _ . -", normalize: normalizeIdentifier, describe: describeOwner},
+	{action: "set_ssh_host", key: "SSH_HOST", title: "🔑 SSH 登录目标", hint: "输入 SSH 登录目标，如 admin@1.2.3.4", normalize: normalizeNoSpace},
+	{action: "set_ssh_port", key: "SSH_PORT", title: "🔑 SSH 端口", hint: "输入 1-65535 之间的端口", normalize: positiveIntNormalizer(65535)},
+	{action: "set_nft_input_chain", key: "NFT_INPUT_CHAIN", title: "🧱 nftables input 链", hint: "输入主机防火墙的 input 链（协议族 表 链），如 inet filter input", normalize: normalizeNftInputChain},
+	{action: "set_secret_store", key: "SECRET_STORE", title: "🔑 密钥存储", hint: "输入 keychain / secret-service / file / memory", normalize: normalizeSecretStore, candidates: secretStoreCandidates},
+	{action: "set_role_arn", key: "ROLE_ARN", title: "🎭 扮演角色", hint: "输入角色 ARN，如 qcs::cam::uin/100000000001:roleName/frp-sg-writer，仅腾讯云后端", normalize: normalizeRoleArn},
+	{action: "set_role_duration", key: "ROLE_DURATION", title: "🎭 临时密钥有效期", hint: "输入 15m-12h 之间的时长，如 1h", normalize: normalizeRoleDuration, describe: describeRoleDuration},
+	{action: "set_credentials", key: "CREDENTIALS", title: "🔑 密钥引用名", hint: "输入引用名，不同 profile 可使用不同账号的密钥", normalize: normalizeIdentifier},
+}
+
+// settingByAction 根据 config 子命令查找配置项
+func settingByAction(action string) (setting, bool) {
+	for _, s := range settings {
+		if s.action == action {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// showSettings 在配置列表中展示配置项，回车后输入新值
+func showSettings(wf *aw.Workflow, cfg *config.Config) {
+	for _, s := range settings {
+		value, _ := cfg.Value(s.key)
+		subtitle := "未设置"
+		if s.describe != nil {
+			subtitle = s.describe(cfg)
+		} else if value != "" {
+			subtitle = value
+		}
+		subtitle += " | 回车后" + s.hint
+		wf.NewItem(s.title).
+			Subtitle(subtitle).
+			Valid(true).
+			Arg(s.action)
+	}
+}
+
+// describeOwner 展示生效的属主，未设置时为 frpc.toml 中的 user
+func describeOwner(cfg *config.Config) string {
+	return ruleOwner(cfg) + "（close/watch 默认只处理该属主的规则）"
+}
+
+// addressTemplateSummary 展示是否启用 IP 地址模板及模板名称
+func addressTemplateSummary(cfg *config.Config) string {
+	if !cfg.UseAddressTemplate() {
+		return "未启用"
+	}
+	return ownerTemplateName(ruleOwner(cfg)) + "（规则引用模板，换 IP 只需更新模板，仅 tencent 后端）"
+}
+
+// serviceTemplateSummary 展示是否启用协议端口模板及模板名称
+func serviceTemplateSummary(cfg *config.Config) string {
+	if !cfg.UseServiceTemplate() {
+		return "未启用"
+	}
+	return ownerTemplateName(ruleOwner(cfg)) + "（开放/关闭只修改模板成员，仅 tencent 后端）"
+}
+
+// applySetting 校验输入并将配置项写入当前 profile
+func applySetting(wf *aw.Workflow, s setting, args []string) {
+	if len(args) < 3 {
+		hint := s.hint
+		if !s.required {
+			hint += "，输入 " + clearSettingValue + " 清除"
+		}
+		wf.NewItem(hint + " 后回车").Valid(false)
+		wf.SendFeedback()
+		return
+	}
+	cfg, _ := loadSetupConfig()
+	value := strings.TrimSpace(strings.Join(args[2:], " "))
+
+	var err error
+	if value == clearSettingValue {
+		if s.required {
+			err = fmt.Errorf("%s 为必填项，不能清除", s.key)
+		}
+		value = ""
+	} else {
+		value, err = s.normalize(wf, cfg, value)
+	}
+	profile := ""
+	if err == nil {
+		profile, err = config.SaveSetting(s.key, value)
+	}
+	if err != nil {
+		log.Error("设置 %s 失败: %v", s.key, err)
+		wf.NewItem("设置 " + s.key + " 失败").Subtitle(err.Error()).Valid(false).Icon(aw.IconError)
+		wf.SendFeedback()
+		return
+	}
+
+	log.Info("已将 %s=%s 写入 profile %s", s.key, value, profile)
+	shown := value
+	if shown == "" {
+		shown = "已清除"
+	}
+	wf.NewItem(fmt.Sprintf("%s 已保存到 profile %s", s.key, profile)).
+		Subtitle(shown).
+		Valid(false)
+	// 环境变量优先于配置文件，提醒用户清空 Alfred 中的同名变量
+	if env := os.Getenv(s.key); env != "" && env != value {
+		wf.NewItem("⚠️ 环境变量 " + s.key + " 仍会覆盖该设置").
+			Subtitle("当前生效值: " + env + "，请在 Alfred 的 Workflow 变量中清空").
+			Valid(false).
+			Icon(aw.IconWarning)
+	}
+	wf.SendFeedback()
+}
+
+// completeSetting 在输入配置值时列出候选项：第一项为当前输入，其后为匹配输入的候选值
+func completeSetting(wf *aw.Workflow, args []string) {
+	query := strings.TrimSpace(strings.Join(args[3:], " "))
+	wf.NewItem("确认输入: " + query).
+		Subtitle("按下回车继续").
+		Arg(query).
+		Valid(true)
+
+	if s, ok := settingByAction(args[2]); ok && s.candidates != nil {
+		cfg, _ := loadSetupConfig()
+		lower := strings.ToLower(query)
+		for _, c := range s.candidates(wf, cfg) {
+			if c.value == query {
+				continue
+			}
+			if lower != "" && !strings.Contains(c.value, lower) && !strings.Contains(c.title, query) {
+				continue
+			}
+			wf.NewItem(c.value).
+				Subtitle(c.title).
+				Arg(c.value).
+				Autocomplete(c.value).
+				Valid(true)
+		}
+	}
+	wf.SendFeedback()
+}
+
+// providerCandidates 返回 set_provider 的候选值
+func providerCandidates(_ *aw.Workflow, _ *config.Config) []settingCandidate {
+	var candidates []settingCandidate
+	for _, provider := range []string{config.ProviderTencent, config.ProviderLighthouse, config.ProviderAWS,
+		config.ProviderAliyun, config.ProviderNftables, config.ProviderIptables} {
+		candidates = append(candidates, settingCandidate{
+			value: provider,
+			title: providerDescription(&config.Config{Provider: provider}),
+		})
+	}
+	return candidates
+}
+
+// loadSetupConfig 读取不校验必填项的配置，失败时返回空配置，保证设置流程可用于补全配置
+func loadSetupConfig() (*config.Config, error) {
+	cfg, err := config.LoadPartial()
+	if err != nil {
+		log.Warn("读取配置失败: %v", err)
+		return &config.Config{}, err
+	}
+	return cfg, nil
+}
+
+// expandHome 展开路径开头的 ~
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// normalizeTomlPath 校验 frpc.toml 存在、可解析且至少包含一个代理
+func normalizeTomlPath(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	path, err := expandHome(value)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	var frpcConf SimpleFrpcConfig
+	if _, err := toml.DecodeFile(path, &frpcConf); err != nil {
+		return "", fmt.Errorf("frpc.toml 读取或解析失败: %w", err)
+	}
+	if len(frpcConf.Proxies) == 0 {
+		return "", fmt.Errorf("%s 中没有任何代理", path)
+	}
+	return path, nil
+}
+
+// normalizeProvider 校验规则后端名称
+func normalizeProvider(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	provider := strings.ToLower(value)
+	switch provider {
+	case config.ProviderTencent, config.ProviderLighthouse, config.ProviderAWS, config.ProviderAliyun,
+		config.ProviderNftables, config.ProviderIptables:
+		return provider, nil
+	}
+	return "", fmt.Errorf("不支持的 PROVIDER: %s", value)
+}
+
+// securityGroupIDPattern 腾讯云、AWS、阿里云的安全组 ID 均以 sg- 开头
+var securityGroupIDPattern = regexp.MustCompile(`^sg-[0-9a-z]{8,}$`)
+
+// normalizeSecurityGroups 校验逗号分隔的安全组 ID，并去除多余空白
+func normalizeSecurityGroups(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	ids := (&config.Config{SecurityGroupId: value}).SecurityGroups()
+	if len(ids) == 0 {
+		return "", errors.New("安全组 ID 不能为空")
+	}
+	for _, id := range ids {
+		if !securityGroupIDPattern.MatchString(id) {
+			return "", fmt.Errorf("安全组 ID 格式不正确: %s，应为 sg- 开头", id)
+		}
+	}
+	return strings.Join(ids, ","), nil
+}
+
+// normalizeRegion 校验区域是否为当前后端支持的区域
+func normalizeRegion(wf *aw.Workflow, cfg *config.Config, value string) (string, error) {
+	region := strings.ToLower(value)
+	var regions []regionInfo
+	live := true
+	if usesTencentRegions(cfg.Provider) {
+		regions, live = knownRegions(wf, currentCredential(wf, cfg))
+	}
+	if err := checkRegionList(cfg.Provider, region, regions, live); err != nil {
+		return "", err
+	}
+	return region, nil
+}
+
+// normalizeLogPath 校验日志文件所在目录可写
+func normalizeLogPath(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	path, err := expandHome(value)
+	if err != nil {
+		return "", err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("日志文件不可写: %w", err)
+	}
+	file.Close()
+	return path, nil
+}
+
+// normalizeEndpoint 校验 API 地址为 http(s) URL
+func normalizeEndpoint(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("API 地址应为 http:// 或 https:// 开头的 URL: %s", value)
+	}
+	return strings.TrimSuffix(value, "/"), nil
+}
+
+// normalizeBool 将开关统一写为 1 或 0
+func normalizeBool(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("请输入 1 或 0: %s", value)
+	}
+	if enabled {
+		return "1", nil
+	}
+	return "0", nil
+}
+
+// normalizeIPResolvers 校验每个公网 IP 来源的写法
+func normalizeIPResolvers(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	specs := (&config.Config{IPResolvers: value}).IPResolverList()
+	if len(specs) == 0 {
+		return "", errors.New("公网 IP 来源不能为空")
+	}
+	for _, spec := range specs {
+		if _, err := newIPResolver(spec); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(specs, ","), nil
+}
+
+// normalizeIPTimeout 校验超时为正的时长
+func normalizeIPTimeout(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return "", fmt.Errorf("超时格式不正确: %s，如 3s", value)
+	}
+	return timeout.String(), nil
+}
+
+// positiveIntNormalizer 校验正整数，max 大于 0 时还需不超过 max
+func positiveIntNormalizer(max int) func(*aw.Workflow, *config.Config, string) (string, error) {
+	return func(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || (max > 0 && n > max) {
+			if max > 0 {
+				return "", fmt.Errorf("请输入 1-%d 之间的整数: %s", max, value)
+			}
+			return "", fmt.Errorf("请输入正整数: %s", value)
+		}
+		return strconv.Itoa(n), nil
+	}
+}
+
+// normalizeSecretStore 校验密钥存储方式在当前系统可用
+func normalizeSecretStore(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	name := strings.ToLower(value)
+	if _, err := config.NewSecretStore(&config.Config{SecretStore: name}); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// secretStoreCandidates 返回 set_secret_store 的候选值
+func secretStoreCandidates(_ *aw.Workflow, _ *config.Config) []settingCandidate {
+	return []settingCandidate{
+		{value: config.SecretStoreKeychain, title: "macOS 钥匙串"},
+		{value: config.SecretStoreSecretService, title: "Linux Secret Service（secret-tool）"},
+		{value: config.SecretStoreFile, title: "以 SECRET_PASSPHRASE 加密的本地文件"},
+		{value: config.SecretStoreMemory, title: "仅保存在内存中，用于测试"},
+	}
+}
+
+// normalizeIdentifier 属主和密钥引用名会写入规则备注或密钥存储的条目名，只允许字母、数字和 _ . -
+func normalizeIdentifier(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	if sanitizeOwner(value) != value {
+		return "", fmt.Errorf("只能包含字母、数字和 _ . -: %s", value)
+	}
+	return value, nil
+}
+
+// normalizeNftInputChain 校验 NFT_INPUT_CHAIN 为 协议族 表 链 三部分
+func normalizeNftInputChain(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("格式应为 协议族 表 链，如 inet filter input: %s", value)
+	}
+	switch fields[0] {
+	case "ip", "ip6", "inet":
+	default:
+		return "", fmt.Errorf("input 链的协议族应为 ip、ip6 或 inet: %s", fields[0])
+	}
+	return strings.Join(fields, " "), nil
+}
+
+// normalizeNoSpace 校验值中不含空白
+func normalizeNoSpace(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	if strings.ContainsAny(value, " \t") {
+		return "", fmt.Errorf("不能包含空白: %s", value)
+	}
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package workflow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+// newTestWorkflow 创建使用临时数据和缓存目录的 Workflow
+func newTestWorkflow(t *testing.T) *aw.Workflow {
+	t.Helper()
+	t.Setenv("alfred_workflow_bundleid", "dev.test")
+	t.Setenv("alfred_workflow_cache", t.TempDir())
+	t.Setenv("alfred_workflow_data", t.TempDir())
+	return aw.New()
+}
+
+func TestSettingNormalizers(t *testing.T) {
+	dir := t.TempDir()
+	tomlPath := filepath.Join(dir, "frpc.toml")
+	os.WriteFile(tomlPath, []byte("serverAddr = \"1.2.3.4\"\n[[proxies]]\nname = \"ssh\"\ntype = \"tcp\"\nlocalPort = 22\nremotePort = 6000\n"), 0o600)
+	emptyToml := filepath.Join(dir, "empty.toml")
+	os.WriteFile(emptyToml, []byte("serverAddr = \"1.2.3.4\"\n"), 0o600)
+
+	wf := newTestWorkflow(t)
+	tencent := &config.Config{}
+	aws := &config.Config{Provider: config.ProviderAWS}
+	tests := []struct {
+		action string
+		cfg    *config.Config
+		input  string
+		want   string
+		ok     bool
+	}{
+		{"set_toml_path", tencent, tomlPath, tomlPath, true},
+		{"set_toml_path", tencent, emptyToml, "", false},
+		{"set_toml_path", tencent, filepath.Join(dir, "missing.toml"), "", false},
+		{"set_sgid", tencent, "sg-abcd1234, sg-0123456789abcdef0", "sg-abcd1234,sg-0123456789abcdef0", true},
+		{"set_sgid", tencent, "sg-abcd1234,ins-abcd1234", "", false},
+		{"set_region", tencent, "AP-Shanghai", "ap-shanghai", true},
+		{"set_region", tencent, "ap-shanghia", "", false},
+		{"set_region", aws, "ap-northeast-1", "ap-northeast-1", true},
+		{"set_region", aws, "tokyo 1", "", false},
+		{"set_log_path", tencent, filepath.Join(dir, "frp.log"), filepath.Join(dir, "frp.log"), true},
+		{"set_log_path", tencent, filepath.Join(dir, "missing", "frp.log"), "", false},
+		{"set_provider", tencent, "AWS", config.ProviderAWS, true},
+		{"set_provider", tencent, "gcp", "", false},
+		{"set_endpoint", tencent, "http://127.0.0.1:8080/", "http://127.0.0.1:8080", true},
+		{"set_endpoint", tencent, "127.0.0.1:8080", "", false},
+		{"set_address_template", tencent, "true", "1", true},
+		{"set_ip_resolvers", tencent, "https://api.ipify.org, stun:stun.l.google.com:19302", "https://api.ipify.org,stun:stun.l.google.com:19302", true},
+		{"set_ip_resolvers", tencent, "ftp://example.com", "", false},
+		{"set_ip_timeout", tencent, "1500ms", "1.5s", true},
+		{"set_ip_quorum", tencent, "0", "", false},
+		{"set_min_cidr_prefix", tencent, "33", "", false},
+		{"set_ssh_port", tencent, "2222", "2222", true},
+		{"set_owner", tencent, "alice@home", "", false},
+		{"set_ssh_host", tencent, "admin@1.2.3.4", "admin@1.2.3.4", true},
+	}
+	for _, tt := range tests {
+		s, ok := settingByAction(tt.action)
+		if !ok {
+			t.Fatalf("unknown action %s", tt.action)
+		}
+		got, err := s.normalize(wf, tt.cfg, tt.input)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%s(%q) = %q, %v; want %q, ok=%v", tt.action, tt.input, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSettingsCoverConfigFields(t *testing.T) {
+	cfg := &config.Config{}
+	for _, s := range settings {
+		if _, ok := cfg.Value(s.key); !ok {
+			t.Errorf("setting %s refers to unknown key %s", s.action, s.key)
+		}
+	}
+}
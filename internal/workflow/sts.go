@@ -0,0 +1,174 @@
+package workflow
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tchttp "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/http"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+)
+
+const (
+	// roleSessionName AssumeRole 的会话名，会出现在操作审计记录中
+	roleSessionName = "alfred-frp-sg"
+	// defaultRoleDuration 未配置 ROLE_DURATION 时临时密钥的有效期
+	defaultRoleDuration = time.Hour
+	// STS 允许的临时密钥有效期范围
+	minRoleDuration = 15 * time.Minute
+	maxRoleDuration = 12 * time.Hour
+	// roleRefreshMargin 临时密钥剩余有效期不足该值时重新获取，避免请求途中过期
+	roleRefreshMargin = 5 * time.Minute
+)
+
+// roleArnPattern CAM 角色的资源描述，如 qcs::cam::uin/100000000001:roleName/frp-sg-writer，
+// 也可使用角色 ID：qcs::cam::uin/100000000001:role/4611686018427397919
+var roleArnPattern = regexp.MustCompile(`^qcs::cam::uin/[0-9]+:role(Name/[\w+=,.@-]+|/[0-9]+)$`)
+
+// roleCredential 缓存在 Workflow 缓存目录中的临时密钥
+type roleCredential struct {
+	SecretId   string    `json:"secret_id"`
+	SecretKey  string    `json:"secret_key"`
+	Token      string    `json:"token"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// roleDuration 返回临时密钥的有效期，ROLE_DURATION 无效时使用默认值
+func roleDuration(cfg *config.Config) time.Duration {
+	if cfg.RoleDuration != "" {
+		if duration, err := time.ParseDuration(cfg.RoleDuration); err == nil && duration >= minRoleDuration && duration <= maxRoleDuration {
+			return duration
+		}
+		log.Warn("无效的 ROLE_DURATION: %s，使用默认值 %s", cfg.RoleDuration, defaultRoleDuration)
+	}
+	return defaultRoleDuration
+}
+
+// assumeRoleIfConfigured 配置了 ROLE_ARN 且使用腾讯云后端时，用基础密钥换取角色的临时密钥，否则原样返回基础密钥
+func assumeRoleIfConfigured(wf *aw.Workflow, cfg *config.Config, base *config.Credential) (*config.Credential, error) {
+	if cfg.RoleArn == "" || !cfg.UsesTencentCloud() {
+		return base, nil
+	}
+	role, err := cachedRoleCredential(wf, roleCacheName(cfg.RoleArn, base.SecretId), func() (*roleCredential, error) {
+		return assumeRole(cfg, base)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &config.Credential{
+		SecretId:  role.SecretId,
+		SecretKey: role.SecretKey,
+		Token:     role.Token,
+		Source:    fmt.Sprintf("STS AssumeRole(%s)，基础密钥来自 %s", cfg.RoleArn, base.Source),
+	}, nil
+}
+
+// roleCacheName 返回临时密钥的缓存文件名，角色或基础密钥变化后不会误用旧的临时密钥
+func roleCacheName(roleArn, baseSecretId string) string {
+	sum := sha1.Sum([]byte(roleArn + "|" + baseSecretId))
+	return "sts-" + hex.EncodeToString(sum[:8]) + ".json"
+}
+
+// cachedRoleCredential 返回缓存中仍然有效的临时密钥，即将过期或没有缓存时调用 fetch 重新获取并缓存
+func cachedRoleCredential(wf *aw.Workflow, name string, fetch func() (*roleCredential, error)) (*roleCredential, error) {
+	if wf.Cache.Exists(name) {
+		var cached roleCredential
+		if err := wf.Cache.LoadJSON(name, &cached); err != nil {
+			log.Warn("读取临时密钥缓存失败: %v", err)
+		} else if time.Until(cached.Expiration) > roleRefreshMargin {
+			return &cached, nil
+		}
+	}
+	role, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := wf.Cache.StoreJSON(name, role); err != nil {
+		log.Warn("缓存临时密钥失败: %v", err)
+	}
+	return role, nil
+}
+
+// assumeRole 使用基础密钥调用 STS AssumeRole 获取临时密钥
+func assumeRole(cfg *config.Config, base *config.Credential) (*roleCredential, error) {
+	duration := roleDuration(cfg)
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = "sts.tencentcloudapi.com"
+	client := common.NewCommonClient(tencentCredential(base), cfg.Region, cpf)
+
+	request := tchttp.NewCommonRequest("sts", "2018-08-13", "AssumeRole")
+	params := map[string]interface{}{
+		"RoleArn":         cfg.RoleArn,
+		"RoleSessionName": roleSessionName,
+		"DurationSeconds": int(duration / time.Second),
+	}
+	if err := request.SetActionParameters(params); err != nil {
+		return nil, err
+	}
+	response := tchttp.NewCommonResponse()
+	if err := client.Send(request, response); err != nil {
+		return nil, sdkError("扮演角色", err)
+	}
+
+	var body struct {
+		Response struct {
+			Credentials struct {
+				TmpSecretId  string `json:"TmpSecretId"`
+				TmpSecretKey string `json:"TmpSecretKey"`
+				Token        string `json:"Token"`
+			} `json:"Credentials"`
+			ExpiredTime int64 `json:"ExpiredTime"`
+		} `json:"Response"`
+	}
+	if err := json.Unmarshal(response.GetBody(), &body); err != nil {
+		return nil, fmt.Errorf("解析 AssumeRole 响应失败: %w", err)
+	}
+	creds := body.Response.Credentials
+	if creds.TmpSecretId == "" || creds.TmpSecretKey == "" || creds.Token == "" {
+		return nil, fmt.Errorf("AssumeRole 未返回临时密钥")
+	}
+	expiration := time.Unix(body.Response.ExpiredTime, 0)
+	if body.Response.ExpiredTime == 0 {
+		expiration = time.Now().Add(duration)
+	}
+	log.Info("已扮演角色 %s，临时密钥有效期至 %s", cfg.RoleArn, expiration.Format(time.DateTime))
+	return &roleCredential{
+		SecretId:   creds.TmpSecretId,
+		SecretKey:  creds.TmpSecretKey,
+		Token:      creds.Token,
+		Expiration: expiration,
+	}, nil
+}
+
+// normalizeRoleArn 校验 CAM 角色的资源描述
+func normalizeRoleArn(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	if !roleArnPattern.MatchString(value) {
+		return "", fmt.Errorf("角色 ARN 格式不正确: %s，如 qcs::cam::uin/100000000001:roleName/frp-sg-writer", value)
+	}
+	return value, nil
+}
+
+// normalizeRoleDuration 校验临时密钥有效期在 STS 允许的范围内
+func normalizeRoleDuration(_ *aw.Workflow, _ *config.Config, value string) (string, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < minRoleDuration || duration > maxRoleDuration {
+		return "", fmt.Errorf("有效期需在 %s 到 %s 之间: %s", minRoleDuration, maxRoleDuration, value)
+	}
+	return duration.String(), nil
+}
+
+// describeRoleDuration 展示生效的临时密钥有效期
+func describeRoleDuration(cfg *config.Config) string {
+	if cfg.RoleDuration == "" {
+		return defaultRoleDuration.String() + "（默认）"
+	}
+	return roleDuration(cfg).String()
+}
@@ -0,0 +1,91 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+func TestCachedRoleCredential(t *testing.T) {
+	wf := newTestWorkflow(t)
+	name := roleCacheName("qcs::cam::uin/1:roleName/a", "AKID")
+	calls := 0
+	fetch := func(expiration time.Time) func() (*roleCredential, error) {
+		return func() (*roleCredential, error) {
+			calls++
+			return &roleCredential{SecretId: "tmp", SecretKey: "key", Token: "token", Expiration: expiration}, nil
+		}
+	}
+
+	if _, err := cachedRoleCredential(wf, name, fetch(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	role, err := cachedRoleCredential(wf, name, fetch(time.Now().Add(time.Hour)))
+	if err != nil || calls != 1 || role.Token != "token" {
+		t.Errorf("valid cache should be reused: calls=%d role=%+v err=%v", calls, role, err)
+	}
+
+	// 剩余有效期不足 roleRefreshMargin 时重新获取
+	wf.Cache.StoreJSON(name, roleCredential{SecretId: "old", Expiration: time.Now().Add(time.Minute)})
+	if role, _ = cachedRoleCredential(wf, name, fetch(time.Now().Add(time.Hour))); calls != 2 || role.SecretId != "tmp" {
+		t.Errorf("expiring cache should be refreshed: calls=%d role=%+v", calls, role)
+	}
+
+	if _, err := cachedRoleCredential(wf, "sts-other.json", func() (*roleCredential, error) {
+		return nil, errors.New("denied")
+	}); err == nil {
+		t.Error("fetch error should be returned")
+	}
+
+	if roleCacheName("qcs::cam::uin/1:roleName/a", "AKID2") == name {
+		t.Error("different base key should use a different cache")
+	}
+}
+
+func TestAssumeRoleNotConfigured(t *testing.T) {
+	wf := newTestWorkflow(t)
+	base := &config.Credential{SecretId: "id", SecretKey: "key"}
+	for _, cfg := range []*config.Config{
+		{},
+		{Provider: config.ProviderAWS, RoleArn: "qcs::cam::uin/1:roleName/a"},
+	} {
+		got, err := assumeRoleIfConfigured(wf, cfg, base)
+		if err != nil || got != base {
+			t.Errorf("%+v: got %+v, %v, want base credential", cfg, got, err)
+		}
+	}
+}
+
+func TestRoleSettings(t *testing.T) {
+	for _, tt := range []struct {
+		arn string
+		ok  bool
+	}{
+		{"qcs::cam::uin/100000000001:roleName/frp-sg-writer", true},
+		{"qcs::cam::uin/100000000001:role/4611686018427397919", true},
+		{"qcs::cam::uin/abc:roleName/x", false},
+		{"arn:aws:iam::123456789012:role/x", false},
+	} {
+		if _, err := normalizeRoleArn(nil, nil, tt.arn); (err == nil) != tt.ok {
+			t.Errorf("normalizeRoleArn(%s) err = %v", tt.arn, err)
+		}
+	}
+	for _, tt := range []struct {
+		input, want string
+	}{
+		{"90m", "1h30m0s"},
+		{"10m", ""},
+		{"13h", ""},
+		{"abc", ""},
+	} {
+		got, err := normalizeRoleDuration(nil, nil, tt.input)
+		if got != tt.want || (err == nil) != (tt.want != "") {
+			t.Errorf("normalizeRoleDuration(%s) = %q, %v", tt.input, got, err)
+		}
+	}
+	if got := roleDuration(&config.Config{RoleDuration: "5m"}); got != defaultRoleDuration {
+		t.Errorf("out-of-range ROLE_DURATION should fall back to default, got %s", got)
+	}
+}
@@ -0,0 +1,180 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/log"
+
+	aw "github.com/deanishe/awgo"
+)
+
+// watchCacheName 返回缓存上一次检测到的公网 IP 的文件名，进程重启后仍能发现 IP 变化。
+// 不同 profile 可能各有后台任务，分别记录
+func watchCacheName(cfg *config.Config) string {
+	if config.IsDefaultProfile(cfg.Profile) {
+		return "watch_last_ip.json"
+	}
+	return "watch_last_ip-" + sanitizeOwner(cfg.Profile) + ".json"
+}
+
+// defaultWatchInterval 默认的公网 IP 检测间隔
+const defaultWatchInterval = time.Minute
+
+// watchOptions watch 子命令的参数
+type watchOptions struct {
+	interval time.Duration
+	once     bool // 只检测一次，供 launchd/systemd 定时调用
+	closeOld bool // 关闭指向旧 IP 的放行规则，而不是改指向新 IP
+	all      bool // 处理所有人的规则，默认只处理本人的规则
+}
+
+// parseWatchArgs 解析 watch 子命令参数：interval=<时长>、once、close-old、--all
+func parseWatchArgs(args []string) (watchOptions, error) {
+	opts := watchOptions{interval: defaultWatchInterval}
+	for _, arg := range args {
+		switch {
+		case arg == "once":
+			opts.once = true
+		case arg == "close-old":
+			opts.closeOld = true
+		case arg == "--all":
+			opts.all = true
+		case strings.HasPrefix(arg, "interval="):
+			interval, err := time.ParseDuration(strings.TrimPrefix(arg, "interval="))
+			if err != nil || interval <= 0 {
+				return opts, fmt.Errorf("无效的检测间隔: %s", arg)
+			}
+			opts.interval = interval
+		default:
+			return opts, fmt.Errorf("未知参数: %s，用法: watch [interval=1m] [once] [close-old] [--all]", arg)
+		}
+	}
+	return opts, nil
+}
+
+// Watch 定期检测公网 IP，IP 变化时将指向旧 IP 的放行规则改为指向新 IP
+func Watch(wf *aw.Workflow, args []string) {
+	opts, err := parseWatchArgs(args)
+	if err != nil {
+		log.Error("watch 参数错误: %v", err)
+		fmt.Println(err)
+		return
+	}
+	log.Info("开始监测公网 IP 变化，间隔: %s，只检测一次: %v，关闭而不改指向旧 IP 规则: %v", opts.interval, opts.once, opts.closeOld)
+
+	for {
+		if err := watchOnce(wf, opts); err != nil {
+			log.Error("检测公网 IP 变化失败: %v", err)
+			fmt.Println(err)
+		}
+		if opts.once {
+			return
+		}
+		time.Sleep(opts.interval)
+	}
+}
+
+// watchOnce 检测一次公网 IP，与缓存中的上一次 IP 比较，变化时改指向规则
+func watchOnce(wf *aw.Workflow, opts watchOptions) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	currentIP, err := getCurrentPublicIP(cfg)
+	if err != nil {
+		return err
+	}
+
+	cacheName := watchCacheName(cfg)
+	var lastIP string
+	if wf.Cache.Exists(cacheName) {
+		if err := wf.Cache.LoadJSON(cacheName, &lastIP); err != nil {
+			log.Warn("读取上一次公网 IP 失败: %v", err)
+		}
+	}
+	if lastIP == currentIP {
+		log.Debug("公网 IP 未变化: %s", currentIP)
+		return nil
+	}
+	if lastIP == "" {
+		log.Info("首次记录公网 IP: %s", currentIP)
+		return wf.Cache.StoreJSON(cacheName, currentIP)
+	}
+
+	log.Info("公网 IP 由 %s 变为 %s，开始改指向规则", lastIP, currentIP)
+	backend, err := newBackend(wf, cfg, currentCredential(wf, cfg))
+	if err != nil {
+		return err
+	}
+
+	owner := ruleOwner(cfg)
+	if opts.all {
+		owner = ""
+	}
+	repointed, closed, err := repointRules(backend, lastIP, currentIP, opts.closeOld, owner)
+	fmt.Printf("公网 IP 由 %s 变为 %s: 改指向 %d 条规则，关闭 %d 条规则\n", lastIP, currentIP, repointed, closed)
+	if err != nil {
+		// 保留旧 IP，下次检测时重试失败的规则
+		return err
+	}
+	return wf.Cache.StoreJSON(cacheName, currentIP)
+}
+
+// ruleFromIP 判断规则是否指向 ip（直接写入的 CIDR，或引用的地址模板中的地址）
+func ruleFromIP(rule FetchedRuleInfo, ip string) bool {
+	cidrBlock := ip + "/32"
+	if rule.CidrBlock == ip || rule.CidrBlock == cidrBlock {
+		return true
+	}
+	for _, address := range strings.Split(rule.TemplateAddresses, ",") {
+		if address == ip || address == cidrBlock {
+			return true
+		}
+	}
+	return false
+}
+
+// repointRules 将指向 oldIP 的 AlfredFRP_ 放行规则改为指向 newIP。
+// 规则由本机开放时写入的是本机 IP，因此指向旧 IP 的规则即视为本机的规则，其他来源的规则和开放给其他来源的授权保持不变。
+// closeOld 为 true 时不改指向，而是关闭这些规则，换到新网络后需要手动重新开放。
+// owner 不为空时只处理该属主的规则，为空时处理所有人的规则。
+func repointRules(backend Backend, oldIP, newIP string, closeOld bool, owner string) (repointed, closed int, err error) {
+	rulesByGroup, err := getSecurityGroupRulesByGroup(backend)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var failures []string
+	for _, group := range backend.Groups() {
+		for proxyName, rule := range rulesByGroup[group] {
+			if rule.Action != "ACCEPT" || !ruleFromIP(rule, oldIP) || !ownedBy(rule, owner) || extractGrant(rule.PolicyDescription) != "" {
+				continue
+			}
+			if closeOld {
+				log.Info("安全组 %s: 关闭指向旧 IP %s 的规则 %s", group, oldIP, rule.PolicyDescription)
+				if err := backend.CloseInGroup(group, rule.Protocol, rule.Port, rule.CidrBlock, rule.PolicyDescription); err != nil {
+					log.Error("安全组 %s 关闭规则 %s 失败: %v", group, rule.PolicyDescription, err)
+					failures = append(failures, fmt.Sprintf("%s %s: %v", group, proxyName, err))
+					continue
+				}
+				closed++
+				continue
+			}
+			log.Info("安全组 %s: 规则 %s 由 %s 改为指向 %s", group, rule.PolicyDescription, oldIP, newIP)
+			if err := backend.OpenInGroup(group, proxyName, rule.Protocol, rule.Port, newIP+"/32", rule.PolicyDescription); err != nil {
+				log.Error("安全组 %s 改指向规则 %s 失败: %v", group, rule.PolicyDescription, err)
+				failures = append(failures, fmt.Sprintf("%s %s: %v", group, proxyName, err))
+				continue
+			}
+			repointed++
+		}
+	}
+
+	if len(failures) > 0 {
+		return repointed, closed, fmt.Errorf("部分规则处理失败: %s", strings.Join(failures, "; "))
+	}
+	return repointed, closed, nil
+}
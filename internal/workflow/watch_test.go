@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kevin1sMe/alfred-workflow-sg-manager/internal/config"
+)
+
+func TestRepointRules(t *testing.T) {
+	runner := &fakeRunner{}
+	backend := newHostBackend(&iptablesFirewall{runner: runner}, "203.0.113.1")
+	for _, open := range []struct{ protocol, port, ip, description string }{
+		{"TCP", "8080", "198.51.100.7", "AlfredFRP_web_local8080"},
+		{"UDP", "7000", "198.51.100.7", "AlfredFRP_game_local7000"},
+		{"TCP", "2222", "192.0.2.9", "AlfredFRP_ssh_local22"},
+	} {
+		if err := createSecurityGroupRule(backend, open.protocol, open.port, open.ip, open.description); err != nil {
+			t.Fatalf("open %s failed: %v", open.description, err)
+		}
+	}
+
+	repointed, closed, err := repointRules(backend, "198.51.100.7", "198.51.100.8", false, "")
+	if err != nil {
+		t.Fatalf("repoint failed: %v", err)
+	}
+	if repointed != 2 || closed != 0 {
+		t.Errorf("repointed=%d closed=%d, want 2 and 0", repointed, closed)
+	}
+
+	rules, _ := getAllSecurityGroupRules(backend)
+	for _, name := range []string{"web", "game"} {
+		if got := rules[name]; got.Action != "ACCEPT" || got.CidrBlock != "198.51.100.8/32" {
+			t.Errorf("%s rule = %+v, want ACCEPT for 198.51.100.8/32", name, got)
+		}
+	}
+	if got := rules["ssh"]; got.CidrBlock != "192.0.2.9/32" {
+		t.Errorf("rules from other IPs must be kept, ssh = %+v", got)
+	}
+}
+
+func TestRepointRulesCloseOld(t *testing.T) {
+	backend := newHostBackend(&iptablesFirewall{runner: &fakeRunner{}}, "203.0.113.1")
+	for _, open := range []struct{ ip, description string }{
+		{"198.51.100.7", "AlfredFRP_web_local8080"},
+		{"192.0.2.9", "AlfredFRP_ssh_local22"},
+	} {
+		if err := createSecurityGroupRule(backend, "TCP", "8080", open.ip, open.description); err != nil {
+			t.Fatalf("open %s failed: %v", open.description, err)
+		}
+	}
+
+	repointed, closed, err := repointRules(backend, "198.51.100.7", "198.51.100.8", true, "")
+	if err != nil || repointed != 0 || closed != 1 {
+		t.Fatalf("repointed=%d closed=%d err=%v, want 0 and 1", repointed, closed, err)
+	}
+	rules, _ := getAllSecurityGroupRules(backend)
+	if got := rules["web"]; got.Action != "DROP" || got.CidrBlock != "198.51.100.7/32" {
+		t.Errorf("web rule = %+v, want DROP for the old IP", got)
+	}
+	if got := rules["ssh"]; got.Action != "ACCEPT" || got.CidrBlock != "192.0.2.9/32" {
+		t.Errorf("rules from other IPs must be kept, ssh = %+v", got)
+	}
+}
+
+func TestWatchCacheName(t *testing.T) {
+	if got := watchCacheName(&config.Config{Profile: "default"}); got != "watch_last_ip.json" {
+		t.Errorf("default profile cache = %s", got)
+	}
+	if got := watchCacheName(&config.Config{Profile: "work"}); got != "watch_last_ip-work.json" {
+		t.Errorf("work profile cache = %s", got)
+	}
+}
+
+func TestParseWatchArgs(t *testing.T) {
+	opts, err := parseWatchArgs([]string{"interval=30s", "once", "close-old"})
+	if err != nil || opts.interval != 30*time.Second || !opts.once || !opts.closeOld {
+		t.Errorf("parseWatchArgs = %+v, %v", opts, err)
+	}
+	if opts, _ := parseWatchArgs(nil); opts.interval != defaultWatchInterval || opts.once || opts.closeOld {
+		t.Errorf("default options = %+v", opts)
+	}
+	for _, bad := range []string{"interval=0s", "interval=abc", "forever"} {
+		if _, err := parseWatchArgs([]string{bad}); err == nil {
+			t.Errorf("parseWatchArgs(%s) should fail", bad)
+		}
+	}
+}